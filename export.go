@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/porfirion/osp/export"
+)
+
+// runExport converts annotations from labeled path into another dataset format
+func runExport(config ospConfig, args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "coco", "output format: coco, yolo")
+	output := flags.String("output", "", "output path (default depends on format)")
+	_ = flags.Parse(args)
+
+	switch *format {
+	case "coco":
+		if *output == "" {
+			*output = export.COCOFilename
+		}
+		if err := export.ExportCOCO(config.LabeledPath, *output, config.Labels); err != nil {
+			logger.Fatalf("error exporting coco: %v", err)
+		}
+	case "yolo":
+		if *output == "" {
+			*output = export.YOLODirname
+		}
+		if err := export.ExportYOLO(config.LabeledPath, *output, config.Labels); err != nil {
+			logger.Fatalf("error exporting yolo: %v", err)
+		}
+	default:
+		logger.Fatalf("unknown export format %q", *format)
+	}
+
+	logger.Printf("exported %s to %s", *format, *output)
+}
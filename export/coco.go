@@ -0,0 +1,87 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/porfirion/osp/processor"
+)
+
+// COCOFilename is default name of exported COCO document
+const COCOFilename = "instances.json"
+
+// buildCOCO converts annotations into COCO document.
+// Category ids are indexes of configured labels starting from 1, the same as in documents written by processor.
+// If labels are empty, labels found in annotations are used in alphabetical order, so ids are stable between exports
+// of the same label set. Image and annotation ids are assigned in order of image filenames.
+func buildCOCO(annotations []*processor.Annotation, labels []processor.Label) (*processor.COCODocument, error) {
+	classes := processor.NewClassMap(labels, annotations...)
+	doc := &processor.COCODocument{
+		Images:      make([]processor.COCOImage, 0, len(annotations)),
+		Categories:  processor.COCOCategories(classes),
+		Annotations: make([]processor.COCOAnnotation, 0),
+	}
+
+	for ind, a := range annotations {
+		imageID := ind + 1
+		doc.Images = append(doc.Images, processor.COCOImage{
+			ID:       imageID,
+			FileName: a.Filename,
+			Width:    a.Width,
+			Height:   a.Height,
+		})
+
+		for _, o := range a.Objects {
+			ann, err := processor.NewCOCOAnnotation(len(doc.Annotations)+1, imageID, o, classes)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", a.Filename, err)
+			}
+			doc.Annotations = append(doc.Annotations, ann)
+		}
+	}
+
+	return doc, nil
+}
+
+// WriteCOCO writes annotations as single COCO json document
+func WriteCOCO(w io.Writer, annotations []*processor.Annotation, labels []processor.Label) error {
+	doc, err := buildCOCO(annotations, labels)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("error encoding coco document: %w", err)
+	}
+
+	return nil
+}
+
+// ExportCOCO reads all annotations from labeledPath and writes them as COCO document to outputPath
+func ExportCOCO(labeledPath, outputPath string, labels []processor.Label) error {
+	annotations, err := LoadAnnotations(labeledPath)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("error creating output file: %w", err)
+	}
+
+	if err := WriteCOCO(file, annotations, labels); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error flushing file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package export
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/porfirion/osp/processor"
+)
+
+func Test_buildCOCO(t *testing.T) {
+	annotations := []*processor.Annotation{
+		{Filename: "a.jpg", Width: 100, Height: 50, Objects: []processor.Object{obj("dog", 10, 10, 20, 30), obj("cat", 0, 0, 5, 5)}},
+		{Filename: "b.jpg", Width: 10, Height: 10, Objects: []processor.Object{obj("dog", 1, 2, 3, 4)}},
+	}
+
+	doc, err := buildCOCO(annotations, nil)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	wantCategories := []processor.COCOCategory{{ID: 1, Name: "cat"}, {ID: 2, Name: "dog"}}
+	if !reflect.DeepEqual(doc.Categories, wantCategories) {
+		t.Errorf("categories = %v, want %v", doc.Categories, wantCategories)
+	}
+
+	wantImages := []processor.COCOImage{{ID: 1, FileName: "a.jpg", Width: 100, Height: 50}, {ID: 2, FileName: "b.jpg", Width: 10, Height: 10}}
+	if !reflect.DeepEqual(doc.Images, wantImages) {
+		t.Errorf("images = %v, want %v", doc.Images, wantImages)
+	}
+
+	empty := json.RawMessage("[]")
+	wantAnnotations := []processor.COCOAnnotation{
+		{ID: 1, ImageID: 1, CategoryID: 2, BBox: []float64{10, 10, 10, 20}, Area: 200, Segmentation: empty},
+		{ID: 2, ImageID: 1, CategoryID: 1, BBox: []float64{0, 0, 5, 5}, Area: 25, Segmentation: empty},
+		{ID: 3, ImageID: 2, CategoryID: 2, BBox: []float64{1, 2, 2, 2}, Area: 4, Segmentation: empty},
+	}
+	if !reflect.DeepEqual(doc.Annotations, wantAnnotations) {
+		t.Errorf("annotations = %v, want %v", doc.Annotations, wantAnnotations)
+	}
+
+	// ids of configured labels are the same as in documents written by processor
+	labels := []processor.Label{{Name: "dog"}, {Name: "cat"}}
+	if doc, err = buildCOCO(annotations, labels); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if wantCategories := []processor.COCOCategory{{ID: 1, Name: "dog"}, {ID: 2, Name: "cat"}}; !reflect.DeepEqual(doc.Categories, wantCategories) {
+		t.Errorf("categories = %v, want %v", doc.Categories, wantCategories)
+	}
+	if doc.Annotations[0].CategoryID != 1 || doc.Annotations[1].CategoryID != 2 {
+		t.Errorf("annotations = %v, want dog of category 1 and cat of category 2", doc.Annotations)
+	}
+
+	var unknownLabel *processor.UnknownLabelError
+	if _, err := buildCOCO(annotations, labels[:1]); !errors.As(err, &unknownLabel) {
+		t.Errorf("buildCOCO() error = %v, want UnknownLabelError", err)
+	}
+}
@@ -0,0 +1,40 @@
+// Package export converts Pascal VOC annotations of labeled images into other dataset formats
+package export
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/porfirion/osp/processor"
+)
+
+// LoadAnnotations parses all Pascal VOC xml files from labeledPath including sub directories.
+// Filenames of annotations are paths relative to labeledPath. Result is sorted by image filename.
+func LoadAnnotations(labeledPath string) ([]*processor.Annotation, error) {
+	files, err := processor.ListFiles(labeledPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading labeled path: %w", err)
+	}
+
+	annotations := make([]*processor.Annotation, 0, len(files))
+	for _, name := range files {
+		if strings.ToLower(path.Ext(name)) != ".xml" {
+			continue
+		}
+
+		a, err := processor.ReadAnnotation(labeledPath, name)
+		if err != nil {
+			return nil, err
+		}
+
+		annotations = append(annotations, a)
+	}
+
+	sort.Slice(annotations, func(i, j int) bool {
+		return annotations[i].Filename < annotations[j].Filename
+	})
+
+	return annotations, nil
+}
@@ -0,0 +1,61 @@
+package export
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/porfirion/osp/processor"
+)
+
+const testXML = `<?xml version="1.0"?>
+<annotation>
+    <filename>%s</filename>
+    <size><width>100</width><height>50</height><depth>3</depth></size>
+    %s
+</annotation>`
+
+func obj(label string, left, top, right, bottom int) processor.Object {
+	return processor.Object{Label: label, Left: left, Top: top, Right: right, Bottom: bottom}
+}
+
+func TestLoadAnnotations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "labeled")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"b.xml": fmt.Sprintf(testXML, "b.jpg", "<object><name>dog</name><bndbox><xmin>1</xmin><ymin>2</ymin><xmax>3</xmax><ymax>4</ymax></bndbox></object>"),
+		"a.xml": fmt.Sprintf(testXML, "a.jpg", ""),
+		"a.jpg": "not an annotation",
+		// images from sub directories are identified by relative path
+		"cam/c.xml": fmt.Sprintf(testXML, "c.jpg", ""),
+	}
+	if err := os.Mkdir(path.Join(dir, "cam"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	annotations, err := LoadAnnotations(dir)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if len(annotations) != 3 || annotations[0].Filename != "a.jpg" || annotations[1].Filename != "b.jpg" || annotations[2].Filename != "cam/c.jpg" {
+		t.Fatalf("expected annotations of a.jpg, b.jpg and cam/c.jpg but got %v", annotations)
+	}
+
+	want := []processor.Object{obj("dog", 1, 2, 3, 4)}
+	if !reflect.DeepEqual(annotations[1].Objects, want) {
+		t.Errorf("objects = %v, want %v", annotations[1].Objects, want)
+	}
+}
@@ -0,0 +1,74 @@
+package export
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/porfirion/osp/processor"
+)
+
+// YOLODirname is default name of directory for exported YOLO dataset
+const YOLODirname = "yolo"
+
+// yoloDataYAML builds data.yaml describing class names
+func yoloDataYAML(classes []string) []byte {
+	quoted := make([]string, 0, len(classes))
+	for _, c := range classes {
+		quoted = append(quoted, strconv.Quote(c))
+	}
+
+	return []byte(fmt.Sprintf("nc: %d\nnames: [%s]\n", len(classes), strings.Join(quoted, ", ")))
+}
+
+// WriteYOLO writes annotations as YOLO dataset into outputDir: one txt file per image plus classes.txt and data.yaml.
+// Class ids are indexes of configured labels, like in annotations written by processor, or of labels found
+// in annotations in alphabetical order if labels are empty. Nothing is written if any box is out of its image
+// or has unknown label.
+func WriteYOLO(outputDir string, annotations []*processor.Annotation, labels []processor.Label) error {
+	classes := processor.NewClassMap(labels, annotations...)
+
+	files := make(map[string][]byte, len(annotations)+2)
+	for _, a := range annotations {
+		data, err := processor.YOLOLabels(a, classes)
+		if err != nil {
+			return err
+		}
+		files[strings.TrimSuffix(a.Filename, path.Ext(a.Filename))+".txt"] = data
+	}
+
+	files[processor.YOLOClassesFilename] = []byte(strings.Join(classes.Names(), "\n") + "\n")
+	files["data.yaml"] = yoloDataYAML(classes.Names())
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("error creating output dir: %w", err)
+	}
+
+	for name, data := range files {
+		filePath := path.Join(outputDir, name)
+
+		// labels of images from sub directories are placed into the same sub directories
+		if err := os.MkdirAll(path.Dir(filePath), 0755); err != nil {
+			return fmt.Errorf("error creating output dir: %w", err)
+		}
+
+		if err := ioutil.WriteFile(filePath, data, 0644); err != nil {
+			return fmt.Errorf("error writing %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// ExportYOLO reads all annotations from labeledPath and writes them as YOLO dataset into outputDir
+func ExportYOLO(labeledPath, outputDir string, labels []processor.Label) error {
+	annotations, err := LoadAnnotations(labeledPath)
+	if err != nil {
+		return err
+	}
+
+	return WriteYOLO(outputDir, annotations, labels)
+}
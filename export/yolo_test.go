@@ -0,0 +1,79 @@
+package export
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/porfirion/osp/processor"
+)
+
+func TestWriteYOLO(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yolo")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	annotations := []*processor.Annotation{
+		{Filename: "a.jpg", Width: 10, Height: 10, Objects: []processor.Object{obj("dog", 0, 0, 10, 10)}},
+		{Filename: "b.png", Width: 10, Height: 10, Objects: []processor.Object{obj("cat", 0, 0, 5, 5)}},
+		{Filename: "cam/c.png", Width: 10, Height: 10, Objects: []processor.Object{obj("cat", 5, 5, 10, 10)}},
+	}
+
+	if err := WriteYOLO(dir, annotations, nil); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	want := map[string]string{
+		"a.txt":       "1 0.500000 0.500000 1.000000 1.000000\n",
+		"b.txt":       "0 0.250000 0.250000 0.500000 0.500000\n",
+		"cam/c.txt":   "0 0.750000 0.750000 0.500000 0.500000\n",
+		"classes.txt": "cat\ndog\n",
+		"data.yaml":   "nc: 2\nnames: [\"cat\", \"dog\"]\n",
+	}
+	for name, content := range want {
+		data, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Errorf("error reading %s: %v", name, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("%s = %q, want %q", name, data, content)
+		}
+	}
+
+	// class ids of configured labels are the same as in files written by processor
+	labels := []processor.Label{{Name: "dog"}, {Name: "cat"}, {Name: "bird"}}
+	if err := WriteYOLO(dir, annotations, labels); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	written, err := processor.YOLOWriter{Labels: labels}.Marshal(annotations[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]string{
+		"a.txt":       "0 0.500000 0.500000 1.000000 1.000000\n",
+		"b.txt":       string(written),
+		"classes.txt": string(processor.YOLOWriter{Labels: labels}.Classes()),
+		"data.yaml":   "nc: 3\nnames: [\"dog\", \"cat\", \"bird\"]\n",
+	}
+	for name, content := range want {
+		data, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Errorf("error reading %s: %v", name, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("%s = %q, want %q", name, data, content)
+		}
+	}
+
+	var unknownLabel *processor.UnknownLabelError
+	if err := WriteYOLO(dir, annotations, labels[:1]); !errors.As(err, &unknownLabel) {
+		t.Errorf("WriteYOLO() error = %v, want UnknownLabelError", err)
+	}
+}
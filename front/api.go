@@ -0,0 +1,457 @@
+package front
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/porfirion/osp/imageindex"
+	"github.com/porfirion/osp/processor"
+	"github.com/porfirion/osp/safepath"
+)
+
+const apiPrefix = "/api/v1"
+
+const defaultPageLimit = 100
+const maxPageLimit = 1000
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+type apiImagesResponse struct {
+	View   string   `json:"view"`
+	Total  int      `json:"total"`
+	Offset int      `json:"offset"`
+	Limit  int      `json:"limit"`
+	Images []string `json:"images"`
+	// Quarantine lists files that look like images by extension but can't be read
+	Quarantine []imageindex.Quarantined `json:"quarantine,omitempty"`
+}
+
+// apiImageResponse describes image with it's annotation. Size and objects are in coordinates of image
+// as browser shows it (rotated according to EXIF orientation), the same as ones accepted by annotation handler.
+type apiImageResponse struct {
+	View        string             `json:"view"`
+	Filename    string             `json:"filename"`
+	URL         string             `json:"url"`
+	Width       int                `json:"width,omitempty"`
+	Height      int                `json:"height,omitempty"`
+	Depth       int                `json:"depth,omitempty"`
+	Orientation int                `json:"orientation,omitempty"`
+	Objects     []processor.Object `json:"objects"`
+	// Suggestions are proposed boxes of unlabeled image
+	Suggestions []processor.Suggestion `json:"suggestions,omitempty"`
+	// SuggestionsPending is true while labeler proposes boxes, image should be requested again later
+	SuggestionsPending bool `json:"suggestions_pending,omitempty"`
+}
+
+type apiAnnotationRequest struct {
+	Width   int                `json:"width"`
+	Height  int                `json:"height"`
+	Objects []processor.Object `json:"objects"`
+}
+
+type apiSkipResponse struct {
+	// Next is unlabeled image to label after skipped or rejected one (empty if there are no more images)
+	Next string `json:"next"`
+}
+
+type apiUndoResponse struct {
+	// View and Filename tell where image is after undo
+	View     string `json:"view"`
+	Filename string `json:"filename"`
+	// Objects of undone save
+	Objects []processor.Object `json:"objects"`
+}
+
+type apiRejectRequest struct {
+	Reason string `json:"reason"`
+}
+
+type apiNegativeRequest struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// apiErrorStatuses maps processor errors to http statuses and error codes
+var apiErrorStatuses = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{processor.EmptyFilenameError, http.StatusBadRequest, "empty_filename"},
+	{processor.EmptyLabelError, http.StatusUnprocessableEntity, "empty_label"},
+	{processor.NoObjectsError, http.StatusUnprocessableEntity, "no_objects"},
+	{processor.OutOfBoundsError, http.StatusUnprocessableEntity, "out_of_bounds"},
+	{processor.InvertedBoxError, http.StatusUnprocessableEntity, "inverted_box"},
+	{processor.BoxTooSmallError, http.StatusUnprocessableEntity, "box_too_small"},
+	{processor.SizeMismatchError, http.StatusUnprocessableEntity, "size_mismatch"},
+	{processor.UnsupportedImageError, http.StatusUnprocessableEntity, "unsupported_image"},
+	{processor.NotImageError, http.StatusUnprocessableEntity, "not_image"},
+	{processor.MissingInputFileError, http.StatusNotFound, "not_found"},
+	{processor.UnlabeledExistsError, http.StatusConflict, "unlabeled_exists"},
+	{processor.RejectDisabledError, http.StatusBadRequest, "reject_disabled"},
+	{processor.RejectedExistsError, http.StatusConflict, "rejected_exists"},
+	{processor.NothingToUndoError, http.StatusConflict, "nothing_to_undo"},
+	{processor.ChangedSinceSaveError, http.StatusConflict, "changed_since_save"},
+	{processor.MissingBackupError, http.StatusConflict, "missing_backup"},
+	{processor.YOLOClassesConflictError, http.StatusConflict, "yolo_classes_conflict"},
+	{processor.LabelerFailedError, http.StatusBadGateway, "labeler_failed"},
+	{processor.SendTimeoutError, http.StatusServiceUnavailable, "processor_busy"},
+	{processor.ProcessorClosedError, http.StatusServiceUnavailable, "processor_closed"},
+	{processor.ResponseTimeoutError, http.StatusGatewayTimeout, "processor_timeout"},
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Printf("error writing response: %v\n", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code string, message string) {
+	writeJSON(w, status, apiErrorResponse{Error: apiError{Code: code, Message: message}})
+}
+
+// writeProcessorError converts error returned by processor into structured api error
+func writeProcessorError(w http.ResponseWriter, err error) {
+	var unknownLabel *processor.UnknownLabelError
+	if errors.As(err, &unknownLabel) {
+		writeAPIError(w, http.StatusUnprocessableEntity, "unknown_label", err.Error())
+		return
+	}
+
+	var alreadyLabeled *processor.AlreadyLabeledError
+	if errors.As(err, &alreadyLabeled) {
+		writeAPIError(w, http.StatusConflict, "already_labeled", err.Error())
+		return
+	}
+
+	var unsafePath *safepath.UnsafePathError
+	if errors.As(err, &unsafePath) {
+		writeAPIError(w, http.StatusBadRequest, "unsafe_path", err.Error())
+		return
+	}
+
+	for _, e := range apiErrorStatuses {
+		if errors.Is(err, e.err) {
+			writeAPIError(w, e.status, e.code, err.Error())
+			return
+		}
+	}
+
+	writeAPIError(w, http.StatusInternalServerError, "internal", err.Error())
+}
+
+// imageURL returns url of image file served under prefix. Each segment of slash separated filename is escaped,
+// so names with "#", "?", "%" or spaces aren't broken.
+func imageURL(prefix, filename string) string {
+	segments := strings.Split(filename, "/")
+	for ind, segment := range segments {
+		segments[ind] = url.PathEscape(segment)
+	}
+
+	return prefix + "/" + strings.Join(segments, "/")
+}
+
+// decodeOptionalBody parses json body of request into v. Empty body is allowed and leaves v as is.
+func decodeOptionalBody(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && err != io.EOF {
+		return err
+	}
+
+	return nil
+}
+
+// parsePage reads offset and limit from query
+func parsePage(r *http.Request) (offset, limit int, err error) {
+	limit = defaultPageLimit
+
+	if v := r.URL.Query().Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, errors.New("offset should be non-negative integer")
+		}
+	}
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			return 0, 0, errors.New("limit should be positive integer")
+		}
+	}
+
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+
+	return offset, limit, nil
+}
+
+// takePage returns files from offset not longer than limit
+func takePage(files imageindex.Snapshot, offset, limit int) []string {
+	return files.Slice(offset, offset+limit)
+}
+
+func (s *server) apiImagesHandler(w http.ResponseWriter, r *http.Request) {
+	view := mux.Vars(r)["view"]
+
+	offset, limit, err := parsePage(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	index := s.viewIndex(view)
+	files, quarantine := index.Snapshot(), index.Quarantine()
+
+	writeJSON(w, http.StatusOK, apiImagesResponse{
+		View:       view,
+		Total:      files.Len(),
+		Offset:     offset,
+		Limit:      limit,
+		Images:     takePage(files, offset, limit),
+		Quarantine: quarantine,
+	})
+}
+
+func (s *server) apiImageHandler(w http.ResponseWriter, r *http.Request) {
+	view, filename := mux.Vars(r)["view"], mux.Vars(r)["filename"]
+
+	imagePath, err := safepath.Resolve(s.viewDir(view), filename)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+
+	if info, err := os.Stat(imagePath); err != nil || info.IsDir() {
+		writeAPIError(w, http.StatusNotFound, "not_found", "image not found")
+		return
+	}
+
+	if !processor.HasImageExtension(filename, s.processor.Extensions()) {
+		writeProcessorError(w, fmt.Errorf("%w (extension of %s is not allowed)", processor.UnsupportedImageError, filename))
+		return
+	}
+
+	if err := processor.SniffImage(imagePath); err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+
+	resp := apiImageResponse{
+		View:     view,
+		Filename: filename,
+		URL:      imageURL("/img", filename),
+		Objects:  []processor.Object{},
+	}
+
+	if view == viewLabeled {
+		resp.URL = imageURL("/labeled-img", filename)
+
+		annotation, err := processor.ReadAnnotation(s.labeledPath, filename)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "internal", err.Error())
+			return
+		}
+		annotation = annotation.Oriented()
+
+		resp.Width, resp.Height, resp.Depth = annotation.Width, annotation.Height, annotation.Depth
+		resp.Orientation = annotation.Orientation
+		resp.Objects = annotation.Objects
+	} else if resp.Suggestions, err = s.processor.Suggestions(filename); errors.Is(err, processor.SuggestionsPendingError) {
+		resp.SuggestionsPending = true
+	} else if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) apiAnnotationHandler(w http.ResponseWriter, r *http.Request) {
+	view, filename := mux.Vars(r)["view"], mux.Vars(r)["filename"]
+
+	req := &apiAnnotationRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", "error parsing request: "+err.Error())
+		return
+	}
+
+	imageRequest := processor.ImageRequest{
+		Filename: filename,
+		Width:    req.Width,
+		Height:   req.Height,
+		Objects:  req.Objects,
+	}
+
+	var (
+		result interface{}
+		err    error
+	)
+	if view == viewLabeled {
+		result, err = s.processor.UpdateImage(r.Context(), imageRequest)
+	} else {
+		result, err = s.processor.ProcessImage(r.Context(), imageRequest)
+	}
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+
+	if labelResult, ok := result.(*processor.LabelResult); ok {
+		// image could be renamed because of conflict
+		filename = labelResult.Filename
+		s.history.push(sessionID(w, r), labelResult.Revision)
+	}
+
+	s.writeLabeledImage(w, filename)
+}
+
+// writeLabeledImage responds with annotation of just labeled image
+func (s *server) writeLabeledImage(w http.ResponseWriter, filename string) {
+	annotation, err := processor.ReadAnnotation(s.labeledPath, filename)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	annotation = annotation.Oriented()
+
+	writeJSON(w, http.StatusOK, apiImageResponse{
+		View:        viewLabeled,
+		Filename:    filename,
+		URL:         imageURL("/labeled-img", filename),
+		Width:       annotation.Width,
+		Height:      annotation.Height,
+		Depth:       annotation.Depth,
+		Orientation: annotation.Orientation,
+		Objects:     annotation.Objects,
+	})
+}
+
+func (s *server) apiLabelsHandler(w http.ResponseWriter, r *http.Request) {
+	labels := s.processor.Labels()
+	if labels == nil {
+		labels = []processor.Label{}
+	}
+
+	writeJSON(w, http.StatusOK, labels)
+}
+
+// apiSkipHandler skips unlabeled image and returns next one
+func (s *server) apiSkipHandler(w http.ResponseWriter, r *http.Request) {
+	filename := mux.Vars(r)["filename"]
+
+	if _, err := s.processor.SkipImage(r.Context(), filename); err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, apiSkipResponse{Next: s.nextUnlabeled(filename)})
+}
+
+// apiRejectHandler moves unlabeled image to rejected path and returns next one
+func (s *server) apiRejectHandler(w http.ResponseWriter, r *http.Request) {
+	filename := mux.Vars(r)["filename"]
+
+	req := &apiRejectRequest{}
+	if err := decodeOptionalBody(r, req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", "error parsing request: "+err.Error())
+		return
+	}
+
+	if _, err := s.processor.RejectImage(r.Context(), filename, req.Reason); err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, apiSkipResponse{Next: s.nextUnlabeled(filename)})
+}
+
+// apiNegativeHandler labels image as one without objects
+func (s *server) apiNegativeHandler(w http.ResponseWriter, r *http.Request) {
+	filename := mux.Vars(r)["filename"]
+
+	req := &apiNegativeRequest{}
+	if err := decodeOptionalBody(r, req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", "error parsing request: "+err.Error())
+		return
+	}
+
+	result, err := s.processor.MarkNegative(r.Context(), processor.ImageRequest{
+		Filename: filename,
+		Width:    req.Width,
+		Height:   req.Height,
+	})
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+
+	if labelResult, ok := result.(*processor.LabelResult); ok {
+		filename = labelResult.Filename
+		s.history.push(sessionID(w, r), labelResult.Revision)
+	}
+
+	s.writeLabeledImage(w, filename)
+}
+
+// apiUndoHandler reverts last save made in session (identified by cookie)
+func (s *server) apiUndoHandler(w http.ResponseWriter, r *http.Request) {
+	session := sessionID(w, r)
+	rev := s.history.peek(session)
+
+	result, err := s.processor.Undo(r.Context(), rev)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	s.history.drop(session, rev)
+
+	undoResult, ok := result.(*processor.UndoResult)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, "internal", fmt.Sprintf("unexpected response of processor: %T", result))
+		return
+	}
+	resp := apiUndoResponse{View: viewUnlabeled, Filename: undoResult.Filename, Objects: undoResult.Objects}
+	if undoResult.Labeled {
+		resp.View = viewLabeled
+	}
+	if resp.Objects == nil {
+		resp.Objects = []processor.Object{}
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// registerAPI adds json api handlers to router
+func (s *server) registerAPI(router *mux.Router) {
+	api := router.PathPrefix(apiPrefix).Subrouter()
+
+	api.HandleFunc("/labels", s.apiLabelsHandler).Methods(http.MethodGet)
+	api.HandleFunc("/undo", s.apiUndoHandler).Methods(http.MethodPost)
+	api.HandleFunc("/images/{view:unlabeled|labeled}", s.apiImagesHandler).Methods(http.MethodGet)
+	// filename is path relative to images directory and may contain slashes
+	api.HandleFunc("/images/{view:unlabeled|labeled}/{filename:.+}/annotation", s.apiAnnotationHandler).Methods(http.MethodPut)
+	api.HandleFunc("/images/unlabeled/{filename:.+}/skip", s.apiSkipHandler).Methods(http.MethodPost)
+	api.HandleFunc("/images/unlabeled/{filename:.+}/reject", s.apiRejectHandler).Methods(http.MethodPost)
+	api.HandleFunc("/images/unlabeled/{filename:.+}/negative", s.apiNegativeHandler).Methods(http.MethodPost)
+	api.HandleFunc("/images/{view:unlabeled|labeled}/{filename:.+}", s.apiImageHandler).Methods(http.MethodGet)
+
+	api.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "unknown api method")
+	})
+}
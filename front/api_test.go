@@ -0,0 +1,491 @@
+package front
+
+import (
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/porfirion/osp/imageindex"
+	"github.com/porfirion/osp/processor"
+)
+
+// setupAPIServer creates router of api of server created by setupTestServer
+func setupAPIServer(t *testing.T, options processor.Options) (router *mux.Router, cleanup func()) {
+	s, cleanup := setupTestServer(t, options)
+	router = mux.NewRouter()
+	s.registerAPI(router)
+
+	return router, cleanup
+}
+
+// setupTestServer creates server with unlabeled images 1.png, 2.png, 3.png and cam/4.png (10x10 each).
+// There are also broken.jpg that isn't an image and Thumbs.db that should be ignored. 2.png has suggestions in 2.json.
+func setupTestServer(t *testing.T, options processor.Options) (s *server, cleanup func()) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	unlabeled, labeled := path.Join(tempDir, "unlabeled"), path.Join(tempDir, "labeled")
+	_ = os.Mkdir(unlabeled, 0755)
+	_ = os.Mkdir(labeled, 0755)
+	_ = os.Mkdir(path.Join(unlabeled, "cam"), 0755)
+
+	for _, name := range []string{"1.png", "2.png", "3.png", "cam/4.png"} {
+		file, err := os.Create(path.Join(unlabeled, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = png.Encode(file, image.NewRGBA(image.Rect(0, 0, 10, 10)))
+		_ = file.Close()
+	}
+
+	_ = ioutil.WriteFile(path.Join(unlabeled, "broken.jpg"), []byte("download failed"), 0644)
+	_ = ioutil.WriteFile(path.Join(unlabeled, "Thumbs.db"), []byte{0}, 0644)
+	_ = ioutil.WriteFile(path.Join(unlabeled, "2.json"), []byte(`{"objects":[{"label":"car","left":1,"top":2,"right":3,"bottom":4,"confidence":0.8}]}`), 0644)
+
+	options.Extensions = processor.ImageExtensions(options.Extensions)
+	unlabeledIndex := imageindex.New(unlabeled, options.Extensions)
+	labeledIndex := imageindex.New(labeled, options.Extensions)
+	options.OnMove = func(m processor.Move) {
+		switch {
+		case m.Copied:
+			unlabeledIndex.Add(m.To)
+		case m.Rejected:
+			unlabeledIndex.Remove(m.From)
+		case m.ToLabeled:
+			unlabeledIndex.Remove(m.From)
+			labeledIndex.Add(m.To)
+		default:
+			labeledIndex.Remove(m.From)
+			unlabeledIndex.Add(m.To)
+		}
+	}
+
+	p, err := processor.NewImageProcessor(unlabeled, labeled, options)
+	if err != nil {
+		t.Fatal("error creating image processor")
+	}
+
+	if err := unlabeledIndex.Rescan(); err != nil {
+		t.Fatal(err)
+	}
+	if err := labeledIndex.Rescan(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = &server{
+		imgPath:        unlabeled,
+		labeledPath:    labeled,
+		unlabeledIndex: unlabeledIndex,
+		labeledIndex:   labeledIndex,
+		processor:      p,
+		history:        newHistory(),
+	}
+
+	return s, func() {
+		_ = p.Close()
+		_ = os.RemoveAll(tempDir)
+	}
+}
+
+func doAPIRequest(router http.Handler, method, url, body string, resp interface{}) int {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+
+	if resp != nil {
+		_ = json.Unmarshal(w.Body.Bytes(), resp)
+	}
+
+	return w.Code
+}
+
+// doSessionRequest makes request in session identified by cookie
+func doSessionRequest(router http.Handler, session, method, url, body string, resp interface{}) int {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session})
+	router.ServeHTTP(w, req)
+
+	if resp != nil {
+		_ = json.Unmarshal(w.Body.Bytes(), resp)
+	}
+
+	return w.Code
+}
+
+func Test_api_images(t *testing.T) {
+	router, cleanup := setupAPIServer(t, processor.Options{})
+	defer cleanup()
+
+	tests := []struct {
+		name   string
+		url    string
+		status int
+		want   []string
+	}{
+		{"all", "/api/v1/images/unlabeled", http.StatusOK, []string{"1.png", "2.png", "3.png", "cam/4.png"}},
+		{"page", "/api/v1/images/unlabeled?offset=1&limit=1", http.StatusOK, []string{"2.png"}},
+		{"after end", "/api/v1/images/unlabeled?offset=10", http.StatusOK, []string{}},
+		{"labeled", "/api/v1/images/labeled", http.StatusOK, []string{}},
+		{"bad limit", "/api/v1/images/unlabeled?limit=-1", http.StatusBadRequest, nil},
+		{"unknown view", "/api/v1/images/other", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &apiImagesResponse{}
+			if status := doAPIRequest(router, http.MethodGet, tt.url, "", resp); status != tt.status {
+				t.Fatalf("status = %d, want %d", status, tt.status)
+			}
+			if tt.want != nil && !reflect.DeepEqual(resp.Images, tt.want) {
+				t.Errorf("images = %v, want %v", resp.Images, tt.want)
+			}
+		})
+	}
+
+	resp := &apiImagesResponse{}
+	doAPIRequest(router, http.MethodGet, "/api/v1/images/unlabeled", "", resp)
+	if len(resp.Quarantine) != 1 || resp.Quarantine[0].Filename != "broken.jpg" {
+		t.Errorf("quarantine = %v, want only broken.jpg", resp.Quarantine)
+	}
+}
+
+func Test_api_annotation(t *testing.T) {
+	router, cleanup := setupAPIServer(t, processor.Options{})
+	defer cleanup()
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		status int
+		code   string
+	}{
+		{"bad json", http.MethodPut, "/api/v1/images/unlabeled/1.png/annotation", "{", http.StatusBadRequest, "bad_request"},
+		{"missing image", http.MethodPut, "/api/v1/images/unlabeled/5.png/annotation", `{"objects":[{"label":"car","left":1,"top":1,"right":5,"bottom":5}]}`, http.StatusNotFound, "not_found"},
+		{"no objects", http.MethodPut, "/api/v1/images/unlabeled/1.png/annotation", `{"objects":[]}`, http.StatusUnprocessableEntity, "no_objects"},
+		{"out of bounds", http.MethodPut, "/api/v1/images/unlabeled/1.png/annotation", `{"objects":[{"label":"car","left":1,"top":1,"right":50,"bottom":5}]}`, http.StatusUnprocessableEntity, "out_of_bounds"},
+		{"size mismatch", http.MethodPut, "/api/v1/images/unlabeled/1.png/annotation", `{"width":5,"height":5,"objects":[{"label":"car","left":1,"top":1,"right":5,"bottom":5}]}`, http.StatusUnprocessableEntity, "size_mismatch"},
+		{"not an image", http.MethodGet, "/api/v1/images/unlabeled/broken.jpg", "", http.StatusUnprocessableEntity, "not_image"},
+		{"not allowed extension", http.MethodGet, "/api/v1/images/unlabeled/Thumbs.db", "", http.StatusUnprocessableEntity, "unsupported_image"},
+		{"ok", http.MethodPut, "/api/v1/images/unlabeled/1.png/annotation", `{"objects":[{"label":"car","left":1,"top":1,"right":5,"bottom":5}]}`, http.StatusOK, ""},
+		{"not labeled anymore", http.MethodGet, "/api/v1/images/unlabeled/1.png", "", http.StatusNotFound, "not_found"},
+		{"labeled", http.MethodGet, "/api/v1/images/labeled/1.png", "", http.StatusOK, ""},
+		{"update", http.MethodPut, "/api/v1/images/labeled/1.png/annotation", `{"objects":[{"label":"bike","left":2,"top":2,"right":5,"bottom":5}]}`, http.StatusOK, ""},
+		{"subdirectory", http.MethodPut, "/api/v1/images/unlabeled/cam/4.png/annotation", `{"objects":[{"label":"car","left":1,"top":1,"right":5,"bottom":5}]}`, http.StatusOK, ""},
+		{"labeled subdirectory", http.MethodGet, "/api/v1/images/labeled/cam/4.png", "", http.StatusOK, ""},
+		{"skip", http.MethodPost, "/api/v1/images/unlabeled/2.png/skip", "", http.StatusOK, ""},
+		{"skip missing", http.MethodPost, "/api/v1/images/unlabeled/1.png/skip", "", http.StatusNotFound, "not_found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &apiErrorResponse{}
+			if status := doAPIRequest(router, tt.method, tt.url, tt.body, resp); status != tt.status {
+				t.Fatalf("status = %d, want %d (%v)", status, tt.status, resp.Error)
+			}
+			if resp.Error.Code != tt.code {
+				t.Errorf("error code = %q, want %q", resp.Error.Code, tt.code)
+			}
+		})
+	}
+
+	resp := &apiImageResponse{}
+	doAPIRequest(router, http.MethodGet, "/api/v1/images/labeled/1.png", "", resp)
+	want := []processor.Object{{Label: "bike", Left: 2, Top: 2, Right: 5, Bottom: 5}}
+	if !reflect.DeepEqual(resp.Objects, want) || resp.Width != 10 || resp.Height != 10 {
+		t.Errorf("labeled image = %v, want objects %v of 10x10", resp, want)
+	}
+
+	skip := &apiSkipResponse{}
+	doAPIRequest(router, http.MethodPost, "/api/v1/images/unlabeled/2.png/skip", "", skip)
+	if skip.Next != "3.png" {
+		t.Errorf("next image = %q, want 3.png", skip.Next)
+	}
+}
+
+func Test_api_suggestions(t *testing.T) {
+	router, cleanup := setupAPIServer(t, processor.Options{})
+	defer cleanup()
+
+	resp := &apiImageResponse{}
+	if status := doAPIRequest(router, http.MethodGet, "/api/v1/images/unlabeled/2.png", "", resp); status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", status, http.StatusOK)
+	}
+	want := []processor.Suggestion{{Object: processor.Object{Label: "car", Left: 1, Top: 2, Right: 3, Bottom: 4}, Confidence: 0.8}}
+	if !reflect.DeepEqual(resp.Suggestions, want) {
+		t.Errorf("suggestions = %v, want %v", resp.Suggestions, want)
+	}
+
+	resp = &apiImageResponse{}
+	doAPIRequest(router, http.MethodGet, "/api/v1/images/unlabeled/1.png", "", resp)
+	if resp.Suggestions != nil {
+		t.Errorf("suggestions = %v, want none", resp.Suggestions)
+	}
+}
+
+func Test_api_labeler_failed(t *testing.T) {
+	labeler := &processor.CommandLabeler{Command: []string{"osp-missing-labeler"}}
+	router, cleanup := setupAPIServer(t, processor.Options{Labeler: labeler})
+	defer cleanup()
+
+	// labeler is called in background, image is returned without waiting for it
+	image := &apiImageResponse{}
+	if status := doAPIRequest(router, http.MethodGet, "/api/v1/images/unlabeled/1.png", "", image); status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", status, http.StatusOK)
+	}
+	if !image.SuggestionsPending {
+		t.Error("suggestions should be pending")
+	}
+
+	var status int
+	resp := &apiErrorResponse{}
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		if status = doAPIRequest(router, http.MethodGet, "/api/v1/images/unlabeled/1.png", "", resp); status != http.StatusOK {
+			break
+		}
+	}
+	if status != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d", status, http.StatusBadGateway)
+	}
+	if resp.Error.Code != "labeler_failed" {
+		t.Errorf("error code = %q, want labeler_failed", resp.Error.Code)
+	}
+
+	// labeler isn't asked if there is suggestions file
+	if status := doAPIRequest(router, http.MethodGet, "/api/v1/images/unlabeled/2.png", "", nil); status != http.StatusOK {
+		t.Errorf("status = %d, want %d", status, http.StatusOK)
+	}
+}
+
+func Test_api_queue(t *testing.T) {
+	rejected, err := ioutil.TempDir("", "rejected")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+	defer os.RemoveAll(rejected)
+
+	router, cleanup := setupAPIServer(t, processor.Options{RejectedPath: rejected})
+	defer cleanup()
+
+	tests := []struct {
+		name   string
+		url    string
+		body   string
+		status int
+		code   string
+		next   string
+	}{
+		{"skip", "/api/v1/images/unlabeled/1.png/skip", "", http.StatusOK, "", "2.png"},
+		{"reject", "/api/v1/images/unlabeled/2.png/reject", `{"reason":"blurred"}`, http.StatusOK, "", "3.png"},
+		{"reject again", "/api/v1/images/unlabeled/2.png/reject", `{"reason":"blurred"}`, http.StatusNotFound, "not_found", ""},
+		{"reject bad json", "/api/v1/images/unlabeled/3.png/reject", "{", http.StatusBadRequest, "bad_request", ""},
+		{"skipped are offered last", "/api/v1/images/unlabeled/3.png/skip", "", http.StatusOK, "", "cam/4.png"},
+		{"negative", "/api/v1/images/unlabeled/cam/4.png/negative", `{"width":10,"height":10}`, http.StatusOK, "", ""},
+		{"negative size mismatch", "/api/v1/images/unlabeled/3.png/negative", `{"width":5,"height":5}`, http.StatusUnprocessableEntity, "size_mismatch", ""},
+		{"negative without body", "/api/v1/images/unlabeled/3.png/negative", "", http.StatusOK, "", ""},
+		{"reject without body", "/api/v1/images/unlabeled/1.png/reject", "", http.StatusOK, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &struct {
+				apiErrorResponse
+				apiSkipResponse
+			}{}
+			if status := doAPIRequest(router, http.MethodPost, tt.url, tt.body, resp); status != tt.status {
+				t.Fatalf("status = %d, want %d (%v)", status, tt.status, resp.Error)
+			}
+			if resp.Error.Code != tt.code {
+				t.Errorf("error code = %q, want %q", resp.Error.Code, tt.code)
+			}
+			if resp.Next != tt.next {
+				t.Errorf("next image = %q, want %q", resp.Next, tt.next)
+			}
+		})
+	}
+
+	if _, err := os.Stat(path.Join(rejected, "2.png")); err != nil {
+		t.Errorf("rejected image not moved: %v", err)
+	}
+
+	resp := &apiImageResponse{}
+	if status := doAPIRequest(router, http.MethodGet, "/api/v1/images/labeled/cam/4.png", "", resp); status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", status, http.StatusOK)
+	}
+	if len(resp.Objects) != 0 {
+		t.Errorf("negative image objects = %v, want none", resp.Objects)
+	}
+}
+
+func Test_imageURL(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{"1.png", "/img/1.png"},
+		{"cam/4.png", "/img/cam/4.png"},
+		{"camp dan beard #1?.jpg", "/img/camp%20dan%20beard%20%231%3F.jpg"},
+		{"100%/a.jpg", "/img/100%25/a.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			if got := imageURL("/img", tt.filename); got != tt.want {
+				t.Errorf("imageURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_api_reject_disabled(t *testing.T) {
+	router, cleanup := setupAPIServer(t, processor.Options{})
+	defer cleanup()
+
+	resp := &apiErrorResponse{}
+	if status := doAPIRequest(router, http.MethodPost, "/api/v1/images/unlabeled/1.png/reject", `{"reason":"blurred"}`, resp); status != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", status, http.StatusBadRequest)
+	}
+	if resp.Error.Code != "reject_disabled" {
+		t.Errorf("error code = %q, want reject_disabled", resp.Error.Code)
+	}
+}
+
+func Test_api_undo(t *testing.T) {
+	router, cleanup := setupAPIServer(t, processor.Options{})
+	defer cleanup()
+
+	objects := []processor.Object{{Label: "car", Left: 1, Top: 1, Right: 5, Bottom: 5}}
+	body := `{"objects":[{"label":"car","left":1,"top":1,"right":5,"bottom":5}]}`
+	if status := doSessionRequest(router, "first", http.MethodPut, "/api/v1/images/unlabeled/1.png/annotation", body, nil); status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", status, http.StatusOK)
+	}
+
+	errResp := &apiErrorResponse{}
+	if status := doSessionRequest(router, "second", http.MethodPost, "/api/v1/undo", "", errResp); status != http.StatusConflict || errResp.Error.Code != "nothing_to_undo" {
+		t.Errorf("undo in other session = %d %q, want %d nothing_to_undo", status, errResp.Error.Code, http.StatusConflict)
+	}
+
+	resp := &apiUndoResponse{}
+	if status := doSessionRequest(router, "first", http.MethodPost, "/api/v1/undo", "", resp); status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", status, http.StatusOK)
+	}
+	want := &apiUndoResponse{View: viewUnlabeled, Filename: "1.png", Objects: objects}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("undo response = %v, want %v", resp, want)
+	}
+
+	if status := doAPIRequest(router, http.MethodGet, "/api/v1/images/unlabeled/1.png", "", nil); status != http.StatusOK {
+		t.Errorf("image should be unlabeled again, status = %d", status)
+	}
+
+	errResp = &apiErrorResponse{}
+	if status := doSessionRequest(router, "first", http.MethodPost, "/api/v1/undo", "", errResp); status != http.StatusConflict || errResp.Error.Code != "nothing_to_undo" {
+		t.Errorf("second undo = %d %q, want %d nothing_to_undo", status, errResp.Error.Code, http.StatusConflict)
+	}
+}
+
+// unexpectedUndoProcessor answers undo with response of wrong type
+type unexpectedUndoProcessor struct {
+	processor.Processor
+}
+
+func (unexpectedUndoProcessor) Undo(ctx context.Context, rev *processor.Revision) (interface{}, error) {
+	return true, nil
+}
+
+// flakyUndoProcessor fails first undo as if processor was busy
+type flakyUndoProcessor struct {
+	processor.Processor
+	failed bool
+}
+
+func (p *flakyUndoProcessor) Undo(ctx context.Context, rev *processor.Revision) (interface{}, error) {
+	if !p.failed {
+		p.failed = true
+		return nil, processor.SendTimeoutError
+	}
+	return p.Processor.Undo(ctx, rev)
+}
+
+func Test_api_undo_failed(t *testing.T) {
+	s, cleanup := setupTestServer(t, processor.Options{})
+	defer cleanup()
+	s.processor = &flakyUndoProcessor{Processor: s.processor}
+	router := mux.NewRouter()
+	s.registerAPI(router)
+
+	body := `{"objects":[{"label":"car","left":1,"top":1,"right":5,"bottom":5}]}`
+	if status := doSessionRequest(router, "s", http.MethodPut, "/api/v1/images/unlabeled/1.png/annotation", body, nil); status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", status, http.StatusOK)
+	}
+
+	// save isn't forgotten when undo fails
+	if status := doSessionRequest(router, "s", http.MethodPost, "/api/v1/undo", "", nil); status == http.StatusOK {
+		t.Fatalf("first undo should fail")
+	}
+	resp := &apiUndoResponse{}
+	if status := doSessionRequest(router, "s", http.MethodPost, "/api/v1/undo", "", resp); status != http.StatusOK || resp.Filename != "1.png" {
+		t.Fatalf("undo = %d %v, want 1.png undone", status, resp)
+	}
+
+	if status := doSessionRequest(router, "s", http.MethodPost, "/api/v1/undo", "", nil); status != http.StatusConflict {
+		t.Errorf("undone save should be forgotten, status = %d", status)
+	}
+}
+
+func Test_api_undo_unexpectedResponse(t *testing.T) {
+	s := &server{processor: unexpectedUndoProcessor{}, history: newHistory()}
+	router := mux.NewRouter()
+	s.registerAPI(router)
+
+	resp := &apiErrorResponse{}
+	if status := doAPIRequest(router, http.MethodPost, "/api/v1/undo", "", resp); status != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", status, http.StatusInternalServerError)
+	}
+	if resp.Error.Code != "internal" {
+		t.Errorf("error code = %q, want internal", resp.Error.Code)
+	}
+}
+
+func Test_api_labels(t *testing.T) {
+	labels := []processor.Label{{Name: "car", Color: "#ff0000"}, {Name: "bike"}}
+	router, cleanup := setupAPIServer(t, processor.Options{Labels: labels})
+	defer cleanup()
+
+	var got []processor.Label
+	if status := doAPIRequest(router, http.MethodGet, "/api/v1/labels", "", &got); status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", status, http.StatusOK)
+	}
+	if !reflect.DeepEqual(got, labels) {
+		t.Errorf("labels = %v, want %v", got, labels)
+	}
+
+	resp := &apiErrorResponse{}
+	body := `{"objects":[{"label":"truck","left":1,"top":1,"right":5,"bottom":5}]}`
+	if status := doAPIRequest(router, http.MethodPut, "/api/v1/images/unlabeled/1.png/annotation", body, resp); status != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want %d", status, http.StatusUnprocessableEntity)
+	}
+	if resp.Error.Code != "unknown_label" {
+		t.Errorf("error code = %q, want unknown_label", resp.Error.Code)
+	}
+
+	image := &apiImageResponse{}
+	body = `{"objects":[{"label":"Car ","left":1,"top":1,"right":5,"bottom":5}]}`
+	if status := doAPIRequest(router, http.MethodPut, "/api/v1/images/unlabeled/1.png/annotation", body, image); status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", status, http.StatusOK)
+	}
+	if len(image.Objects) != 1 || image.Objects[0].Label != "car" {
+		t.Errorf("label should be normalized to car, got %v", image.Objects)
+	}
+}
@@ -0,0 +1,177 @@
+package front
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/porfirion/osp/processor"
+)
+
+const sessionCookieName = "osp-session"
+
+// historyLimit is how many saves of one session can be undone
+const historyLimit = 20
+
+// maxSessions limits number of sessions with history. Least recently used session is forgotten when new one
+// makes it's first save.
+const maxSessions = 1000
+
+// history keeps saves made in each session, so they can be undone in reverse order.
+// It lives in memory only, so nothing can be undone after restart.
+type history struct {
+	mu       sync.Mutex
+	sessions map[string]*sessionHistory
+}
+
+type sessionHistory struct {
+	// used is time of last access to history
+	used      time.Time
+	revisions []*processor.Revision
+	// restored is result of last undo, it's objects are shown in editor once
+	restored *processor.UndoResult
+}
+
+func newHistory() *history {
+	return &history{sessions: make(map[string]*sessionHistory)}
+}
+
+// session returns history of session or nil if session has no history. Should be called under lock.
+func (h *history) session(id string) *sessionHistory {
+	sh, ok := h.sessions[id]
+	if !ok {
+		return nil
+	}
+
+	sh.used = time.Now()
+	return sh
+}
+
+// newSession returns history of session creating it if needed. Should be called under lock.
+// It's called only when save is recorded, so requests that don't save anything can't evict other sessions.
+func (h *history) newSession(id string) *sessionHistory {
+	if sh := h.session(id); sh != nil {
+		return sh
+	}
+
+	if len(h.sessions) >= maxSessions {
+		var oldest string
+		for other, sh := range h.sessions {
+			if oldest == "" || sh.used.Before(h.sessions[oldest].used) {
+				oldest = other
+			}
+		}
+		delete(h.sessions, oldest)
+	}
+
+	sh := &sessionHistory{used: time.Now()}
+	h.sessions[id] = sh
+	return sh
+}
+
+// push remembers save made in session
+func (h *history) push(id string, rev *processor.Revision) {
+	if rev == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sh := h.newSession(id)
+	sh.revisions = append(sh.revisions, rev)
+	if len(sh.revisions) > historyLimit {
+		sh.revisions = sh.revisions[len(sh.revisions)-historyLimit:]
+	}
+}
+
+// peek returns last save of session (nil if there is none). It stays in history until it's dropped after
+// successful undo, so save can be undone again if undo fails.
+func (h *history) peek(id string) *processor.Revision {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sh := h.session(id)
+	if sh == nil || len(sh.revisions) == 0 {
+		return nil
+	}
+
+	return sh.revisions[len(sh.revisions)-1]
+}
+
+// drop forgets undone save of session
+func (h *history) drop(id string, rev *processor.Revision) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sh := h.session(id)
+	if sh == nil {
+		return
+	}
+
+	for ind, r := range sh.revisions {
+		if r == rev {
+			sh.revisions = append(sh.revisions[:ind], sh.revisions[ind+1:]...)
+			return
+		}
+	}
+}
+
+// canUndo reports whether session has saves to undo
+func (h *history) canUndo(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sh := h.session(id)
+	return sh != nil && len(sh.revisions) > 0
+}
+
+// setRestored remembers result of undo to show it's objects in editor
+func (h *history) setRestored(id string, res *processor.UndoResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// undone save was recorded in session, so it has history unless it was evicted meanwhile
+	if sh := h.session(id); sh != nil {
+		sh.restored = res
+	}
+}
+
+// takeRestored returns objects of undone save if they belong to image and forgets them
+func (h *history) takeRestored(id string, filename string, labeled bool) ([]processor.Object, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sh := h.session(id)
+	if sh == nil || sh.restored == nil || sh.restored.Filename != filename || sh.restored.Labeled != labeled {
+		return nil, false
+	}
+
+	objects := sh.restored.Objects
+	sh.restored = nil
+	return objects, true
+}
+
+// sessionID returns id of session from cookie. New session is started if there is no cookie.
+func sessionID(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(sessionCookieName); err == nil && c.Value != "" {
+		return c.Value
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		logger.Printf("error generating session id: %v\n", err)
+	}
+	id := hex.EncodeToString(buf)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+	})
+
+	return id
+}
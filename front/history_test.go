@@ -0,0 +1,77 @@
+package front
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/porfirion/osp/processor"
+)
+
+func Test_history(t *testing.T) {
+	h := newHistory()
+
+	if rev := h.peek("s"); rev != nil {
+		t.Fatalf("empty history returned %v", rev)
+	}
+
+	for i := 0; i < historyLimit+5; i++ {
+		h.push("s", &processor.Revision{Filename: fmt.Sprintf("%d.png", i)})
+	}
+	h.push("s", nil)
+
+	for i := historyLimit + 4; i >= 5; i-- {
+		rev := h.peek("s")
+		h.drop("s", rev)
+		if want := fmt.Sprintf("%d.png", i); rev == nil || rev.Filename != want {
+			t.Fatalf("popped %v, want %s", rev, want)
+		}
+	}
+	if h.canUndo("s") {
+		t.Errorf("only last %d saves should be kept", historyLimit)
+	}
+
+	h.setRestored("s", &processor.UndoResult{Filename: "1.png", Objects: []processor.Object{{Label: "car"}}})
+	if _, ok := h.takeRestored("s", "1.png", true); ok {
+		t.Errorf("restored objects of unlabeled image shouldn't be shown in labeled view")
+	}
+	if objects, ok := h.takeRestored("s", "1.png", false); !ok || len(objects) != 1 {
+		t.Errorf("restored objects = %v, %v, want one object", objects, ok)
+	}
+	if _, ok := h.takeRestored("s", "1.png", false); ok {
+		t.Errorf("restored objects should be shown once")
+	}
+}
+
+func Test_history_sessions(t *testing.T) {
+	h := newHistory()
+
+	// reads don't create history
+	h.peek("reader")
+	h.canUndo("reader")
+	h.setRestored("reader", &processor.UndoResult{Filename: "1.png"})
+	if len(h.sessions) != 0 {
+		t.Fatalf("sessions = %d, want none", len(h.sessions))
+	}
+
+	start := time.Now()
+	for i := 0; i < maxSessions; i++ {
+		id := fmt.Sprintf("s%d", i)
+		h.push(id, &processor.Revision{Filename: "1.png"})
+		h.sessions[id].used = start.Add(time.Duration(i-maxSessions) * time.Second)
+	}
+
+	// used session isn't evicted, the least recently used one is
+	h.canUndo("s0")
+	h.push("new", &processor.Revision{Filename: "1.png"})
+
+	if len(h.sessions) != maxSessions {
+		t.Errorf("sessions = %d, want %d", len(h.sessions), maxSessions)
+	}
+	if !h.canUndo("s0") || !h.canUndo("new") {
+		t.Errorf("used and new sessions should be kept")
+	}
+	if h.canUndo("s1") {
+		t.Errorf("least recently used session should be evicted")
+	}
+}
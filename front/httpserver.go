@@ -1,21 +1,32 @@
 package front
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/schema"
+	"github.com/porfirion/osp/imageindex"
 	"github.com/porfirion/osp/processor"
+	"github.com/porfirion/osp/safepath"
+	"github.com/porfirion/osp/thumbnail"
 	"html/template"
-	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
-	"path"
+	"path/filepath"
 	"strings"
 )
 
 type Server interface {
-	Start()
+	// Start begins listening on server address and serves requests in background
+	Start() error
+	// Errors returns chan that receives error if server stops serving unexpectedly
+	Errors() <-chan error
+	// Shutdown stops server gracefully waiting for active requests to complete
+	Shutdown(ctx context.Context) error
 }
 
 var logger = log.New(os.Stdout, "HTTP: ", 0)
@@ -26,10 +37,21 @@ var indexTemplate = template.Must(template.New("index").Parse(indexTemplateSourc
 const processErrorCookieName = "process-error"
 const previewImagesLimit = 10
 
+// views of images
+const (
+	viewUnlabeled = "unlabeled"
+	viewLabeled   = "labeled"
+)
+
 type processRequest struct {
+	View          string
 	Filename      string
 	Width, Height int
 
+	Objects []processObject
+}
+
+type processObject struct {
 	Label  string
 	Left   int
 	Top    int
@@ -37,13 +59,41 @@ type processRequest struct {
 	Bottom int
 }
 
+type unlabelRequest struct {
+	Filename string
+}
+
+// queueRequest is request to skip, reject or mark as negative unlabeled image
+type queueRequest struct {
+	Filename string
+	Width    int
+	Height   int
+	Reason   string
+}
+
 type indexModel struct {
+	View         string
+	ImgPrefix    string
+	ThumbPrefix  string
 	Filename     string
+	Objects      []processor.Object
+	Labels       []processor.Label
 	Errors       []string
 	Previews     []string
 	PreviewLeft  int
 	PreviewRight int
 	TotalFiles   int
+	Quarantine   []imageindex.Quarantined
+	// Skipped contains skipped images among previews
+	Skipped map[string]bool
+	// CanUndo is true if there are saves made in this session that can be undone
+	CanUndo bool
+	// Restored is true if Objects are taken from undone save
+	Restored bool
+	// Suggestions are proposed boxes of unlabeled image
+	Suggestions []processor.Suggestion
+	// SuggestionsPending is true while labeler proposes boxes, page fetches them from API when it's done
+	SuggestionsPending bool
 }
 
 func (m *indexModel) addError(err string) {
@@ -74,28 +124,89 @@ func getProcessError(w http.ResponseWriter, r *http.Request) (string, error) {
 	}
 }
 
+// indexURL builds link to index page with specified view and file selected
+func indexURL(view, filename string) string {
+	q := url.Values{}
+	if view != "" && view != viewUnlabeled {
+		q.Set("view", view)
+	}
+	if filename != "" {
+		q.Set("filename", filename)
+	}
+
+	if len(q) == 0 {
+		return "/"
+	}
+
+	return "/?" + q.Encode()
+}
+
 type server struct {
-	addr       string
-	imgPath    string
-	processor  processor.Processor
-	httpServer *http.Server
-	router     *mux.Router
+	addr           string
+	imgPath        string
+	labeledPath    string
+	unlabeledIndex *imageindex.Index
+	labeledIndex   *imageindex.Index
+	unlabeledThumb *thumbnail.Cache
+	labeledThumb   *thumbnail.Cache
+	processor      processor.Processor
+	history        *history
+	httpServer     *http.Server
+	router         *mux.Router
+	errors         chan error
 }
 
-func findCurrentIndex(selectedFile string, files []string) int {
-	if selectedFile == "" || len(files) == 0 {
+// findCurrentIndex returns position of selectedFile in sorted files or -1 if it's not found
+func findCurrentIndex(selectedFile string, files imageindex.Snapshot) int {
+	if selectedFile == "" {
 		return -1
 	}
 
-	for ind, f := range files {
-		if f == selectedFile {
-			return ind
-		}
+	if ind, ok := files.Position(selectedFile); ok {
+		return ind
 	}
 
 	return -1
 }
 
+// isSkipped reports whether unlabeled image was skipped by user
+func (s *server) isSkipped(filename string) bool {
+	return s.processor.IsSkipped(filename)
+}
+
+// firstNotSkipped returns index of first not skipped file or 0 if all of them were skipped
+func (s *server) firstNotSkipped(files imageindex.Snapshot) int {
+	for i := 0; i < files.Len(); i++ {
+		if !s.isSkipped(files.At(i)) {
+			return i
+		}
+	}
+	return 0
+}
+
+// nextUnlabeled returns unlabeled image following current one in order (wrapping around).
+// Skipped images are returned only if there are no other ones. Empty string means there are no more images.
+func (s *server) nextUnlabeled(current string) string {
+	files := s.unlabeledIndex.Snapshot()
+	start, _ := files.Position(current)
+
+	next := ""
+	for i := 0; i < files.Len(); i++ {
+		f := files.At((start + i) % files.Len())
+		if f == current {
+			continue
+		}
+		if !s.isSkipped(f) {
+			return f
+		}
+		if next == "" {
+			next = f
+		}
+	}
+
+	return next
+}
+
 // takePreviews takes some filenames for previews from all available files, but not more than previewImagesLimit
 // Additionally returns left and right indexes of taken files (index starting from 1)
 func takePreviews(previewImagesLimit int, files []string, currentIndex int) (previews []string, l int, r int) {
@@ -130,17 +241,76 @@ func takePreviews(previewImagesLimit int, files []string, currentIndex int) (pre
 	return res, left + 1, right
 }
 
+// snapshotPreviews takes previews from snapshot. Only files around current one are copied from it.
+func snapshotPreviews(previewImagesLimit int, files imageindex.Snapshot, currentIndex int) (previews []string, l int, r int) {
+	from := currentIndex - previewImagesLimit
+	if from < 0 {
+		from = 0
+	}
+
+	previews, l, r = takePreviews(previewImagesLimit, files.Slice(from, currentIndex+previewImagesLimit), currentIndex-from)
+	if previews == nil {
+		return nil, 0, 0
+	}
+
+	return previews, l + from, r + from
+}
+
+// viewDir returns directory with images of specified view
+func (s *server) viewDir(view string) string {
+	if view == viewLabeled {
+		return s.labeledPath
+	}
+
+	return s.imgPath
+}
+
+// viewIndex returns index of images of specified view
+func (s *server) viewIndex(view string) *imageindex.Index {
+	if view == viewLabeled {
+		return s.labeledIndex
+	}
+
+	return s.unlabeledIndex
+}
+
+// quarantineReason returns reason why filename is in quarantine
+func quarantineReason(filename string, quarantine []imageindex.Quarantined) (string, bool) {
+	for _, q := range quarantine {
+		if q.Filename == filename {
+			return q.Reason, true
+		}
+	}
+
+	return "", false
+}
+
 func (s *server) indexHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Println("index request")
 
-	model := &indexModel{}
+	model := &indexModel{
+		View:        viewUnlabeled,
+		ImgPrefix:   "/img",
+		ThumbPrefix: "/thumb",
+		Labels:      s.processor.Labels(),
+	}
+
+	if r.URL.Query().Get("view") == viewLabeled {
+		model.View = viewLabeled
+		model.ImgPrefix = "/labeled-img"
+		model.ThumbPrefix = "/labeled-thumb"
+	}
+	dir := s.viewDir(model.View)
+	index := s.viewIndex(model.View)
 
 	// if file specified in params, lets find it
 	if filenames, ok := r.URL.Query()["filename"]; ok && len(filenames) > 0 {
 		filename := filenames[0]
-		filepath := path.Join(s.imgPath, filename)
-		if info, err := os.Stat(filepath); err == nil && !info.IsDir() {
-			// file exists
+		if filepath, err := safepath.Resolve(dir, filename); err != nil {
+			model.addError(err.Error())
+		} else if info, err := os.Stat(filepath); err == nil && info.Mode().IsRegular() &&
+			!safepath.Hidden(filename) && processor.HasImageExtension(filename, s.processor.Extensions()) {
+			// image exists
 			model.Filename = filename
 		} else {
 			// file doesn't exist
@@ -148,38 +318,82 @@ func (s *server) indexHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Let's find previews in imgPath
-	foundFiles, err := ioutil.ReadDir(s.imgPath)
-	if err != nil {
-		// should show that we have problems with directory
-		model.addError(fmt.Sprintf("error searching files: %v", err))
-	} else if len(foundFiles) > 0 {
-		files := make([]string, 0, len(foundFiles))
-		for _, f := range foundFiles {
-			if !f.IsDir() {
-				files = append(files, f.Name())
+	// Let's find previews in index of current view
+	if model.Filename != "" {
+		_, indexed := index.Position(model.Filename)
+		_, quarantined := quarantineReason(model.Filename, index.Quarantine())
+		if !indexed && !quarantined {
+			// image appeared after last rescan
+			index.Add(model.Filename)
+		}
+	}
+
+	files, quarantine := index.Snapshot(), index.Quarantine()
+	model.Quarantine = quarantine
+
+	if model.Filename != "" && findCurrentIndex(model.Filename, files) < 0 {
+		// unreadable files are listed in quarantine instead of editor
+		if reason, ok := quarantineReason(model.Filename, quarantine); ok {
+			model.addError(fmt.Sprintf(`file "%s" is in quarantine: %s`, model.Filename, reason))
+		} else {
+			model.addError(fmt.Sprintf(`file "%s" is not an image`, model.Filename))
+		}
+		model.Filename = ""
+	}
+
+	if files.Len() > 0 {
+		var currentInd = -1
+		if model.Filename == "" {
+			// first file will be current (skipped unlabeled files are offered last)
+			currentInd = 0
+			if model.View == viewUnlabeled {
+				currentInd = s.firstNotSkipped(files)
 			}
+			model.Filename = files.At(currentInd)
+		} else {
+			currentInd = findCurrentIndex(model.Filename, files)
 		}
 
-		if len(files) > 0 {
-			var currentInd = -1
-			if model.Filename == "" {
-				// first file will be current
-				model.Filename = files[0]
-				currentInd = 0
-			} else {
-				currentInd = findCurrentIndex(model.Filename, files)
+		model.Previews, model.PreviewLeft, model.PreviewRight = snapshotPreviews(previewImagesLimit, files, currentInd)
+		if model.View == viewUnlabeled {
+			model.Skipped = make(map[string]bool)
+			for _, f := range model.Previews {
+				if s.isSkipped(f) {
+					model.Skipped[f] = true
+				}
 			}
+		}
+		model.TotalFiles = files.Len()
+	} else {
+		// no files found (directory is empty or there were only some directories)
+	}
 
-			model.Previews, model.PreviewLeft, model.PreviewRight = takePreviews(previewImagesLimit, files, currentInd)
-			model.TotalFiles = len(files)
+	if model.View == viewLabeled && model.Filename != "" {
+		if annotation, err := processor.ReadAnnotation(s.labeledPath, model.Filename); err == nil {
+			// editor shows image rotated by browser
+			model.Objects = annotation.Oriented().Objects
 		} else {
-			// no files found (assuming there were some directories)
+			model.addError(fmt.Sprintf("error reading annotation: %v", err))
 		}
-	} else {
-		//imgPath is empty.. nothing to do
 	}
 
+	if model.View == viewUnlabeled && model.Filename != "" {
+		if suggestions, err := s.processor.Suggestions(model.Filename); err == nil {
+			model.Suggestions = suggestions
+		} else if errors.Is(err, processor.SuggestionsPendingError) {
+			model.SuggestionsPending = true
+		} else {
+			model.addError(fmt.Sprintf("error reading suggestions: %v", err))
+		}
+	}
+
+	session := sessionID(w, r)
+	if objects, ok := s.history.takeRestored(session, model.Filename, model.View == viewLabeled); ok {
+		model.Objects = objects
+		model.Restored = true
+	}
+	model.CanUndo = s.history.canUndo(session)
+
 	if v, err := getProcessError(w, r); err == nil {
 		model.addError(v)
 	}
@@ -215,39 +429,237 @@ func (s *server) processHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	req.Label = strings.Trim(req.Label, " \n")
-	if req.Label == "" {
-		logger.Printf("label is empty")
-		addProcessErrorAndRedirect(w, r, "Label is empty", "/?filename="+req.Filename)
+	currentURL := indexURL(req.View, req.Filename)
+
+	if len(req.Objects) == 0 {
+		logger.Printf("no areas selected")
+		addProcessErrorAndRedirect(w, r, "No areas selected", currentURL)
 		return
 	}
 
-	if req.Right == req.Left || req.Bottom == req.Top {
-		logger.Printf("area has zero size")
-		addProcessErrorAndRedirect(w, r, "Area has zero size", "/?filename="+req.Filename)
-		return
+	objects := make([]processor.Object, 0, len(req.Objects))
+	for _, o := range req.Objects {
+		label := strings.Trim(o.Label, " \n")
+		if label == "" {
+			logger.Printf("label is empty")
+			addProcessErrorAndRedirect(w, r, "Label is empty", currentURL)
+			return
+		}
+
+		objects = append(objects, processor.Object{
+			Label:  label,
+			Left:   o.Left,
+			Top:    o.Top,
+			Right:  o.Right,
+			Bottom: o.Bottom,
+		})
 	}
 
 	logger.Printf("processing file %v\n", req)
 
-	resp, err := s.processor.ProcessImage(req.Filename, req.Width, req.Height, req.Label, req.Left, req.Top, req.Right, req.Bottom)
+	var (
+		resp interface{}
+		err  error
+	)
+	imageRequest := processor.ImageRequest{
+		Filename: req.Filename,
+		Width:    req.Width,
+		Height:   req.Height,
+		Objects:  objects,
+	}
+	if req.View == viewLabeled {
+		resp, err = s.processor.UpdateImage(r.Context(), imageRequest)
+	} else {
+		resp, err = s.processor.ProcessImage(r.Context(), imageRequest)
+	}
+	if err != nil {
+		addProcessErrorAndRedirect(w, r, fmt.Sprintf("error sending request to processor: %v", err), currentURL)
+		return
+	}
+
+	logger.Printf("processor response: %#v\n", resp)
+
+	if labelResult, ok := resp.(*processor.LabelResult); ok {
+		s.history.push(sessionID(w, r), labelResult.Revision)
+	}
+
+	if req.View == viewLabeled {
+		// stay on edited image
+		http.Redirect(w, r, currentURL, 302)
+	} else {
+		http.Redirect(w, r, "/", 302)
+	}
+}
+
+// unlabelHandler moves labeled image back to unlabeled directory
+func (s *server) unlabelHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Printf("unlabel request\n")
+
+	if err := r.ParseForm(); err != nil {
+		logger.Printf("error parsing request")
+		addProcessErrorAndRedirect(w, r, "error parsing request", r.Referer())
+		return
+	}
+
+	req := &unlabelRequest{}
+
+	decoder := schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+	if err := decoder.Decode(req, r.PostForm); err != nil {
+		logger.Printf("error parsing form: %v", err)
+		addProcessErrorAndRedirect(w, r, "error parsing response", r.Referer())
+		return
+	}
+
+	req.Filename = strings.Trim(req.Filename, " \n")
+	if req.Filename == "" {
+		logger.Print("filename not specified")
+		addProcessErrorAndRedirect(w, r, "Filename not specified", r.Referer())
+		return
+	}
+
+	resp, err := s.processor.UnlabelImage(r.Context(), req.Filename)
 	if err != nil {
-		addProcessErrorAndRedirect(w, r, fmt.Sprintf("error sending request to processor: %v", err), "/?filename="+req.Filename)
+		addProcessErrorAndRedirect(w, r, fmt.Sprintf("error sending request to processor: %v", err), indexURL(viewLabeled, req.Filename))
 		return
 	}
 
 	logger.Printf("processor response: %#v\n", resp)
 
-	http.Redirect(w, r, "/", 302)
+	http.Redirect(w, r, indexURL(viewUnlabeled, req.Filename), 302)
 }
 
-func (s *server) Start() {
+// queueHandler skips, rejects or marks as negative unlabeled image and redirects to next one
+func (s *server) queueHandler(w http.ResponseWriter, r *http.Request) {
+	action := mux.Vars(r)["action"]
+	logger.Printf("%s request\n", action)
+
+	if err := r.ParseForm(); err != nil {
+		logger.Printf("error parsing request")
+		addProcessErrorAndRedirect(w, r, "error parsing request", r.Referer())
+		return
+	}
+
+	req := &queueRequest{}
+
+	decoder := schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+	if err := decoder.Decode(req, r.PostForm); err != nil {
+		logger.Printf("error parsing form: %v", err)
+		addProcessErrorAndRedirect(w, r, "error parsing response", r.Referer())
+		return
+	}
+
+	req.Filename = strings.Trim(req.Filename, " \n")
+	if req.Filename == "" {
+		logger.Print("filename not specified")
+		addProcessErrorAndRedirect(w, r, "Filename not specified", r.Referer())
+		return
+	}
+
+	var (
+		resp interface{}
+		err  error
+	)
+	switch action {
+	case "skip":
+		resp, err = s.processor.SkipImage(r.Context(), req.Filename)
+	case "reject":
+		resp, err = s.processor.RejectImage(r.Context(), req.Filename, req.Reason)
+	case "negative":
+		resp, err = s.processor.MarkNegative(r.Context(), processor.ImageRequest{
+			Filename: req.Filename,
+			Width:    req.Width,
+			Height:   req.Height,
+		})
+	}
+	if err != nil {
+		addProcessErrorAndRedirect(w, r, fmt.Sprintf("error sending request to processor: %v", err), indexURL(viewUnlabeled, req.Filename))
+		return
+	}
+
+	logger.Printf("processor response: %#v\n", resp)
+
+	if labelResult, ok := resp.(*processor.LabelResult); ok {
+		s.history.push(sessionID(w, r), labelResult.Revision)
+	}
+
+	http.Redirect(w, r, indexURL(viewUnlabeled, s.nextUnlabeled(req.Filename)), 302)
+}
+
+// undoHandler reverts last save of session and returns to image with boxes of undone save
+func (s *server) undoHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Printf("undo request\n")
+
+	session := sessionID(w, r)
+	rev := s.history.peek(session)
+	if rev == nil {
+		addProcessErrorAndRedirect(w, r, processor.NothingToUndoError.Error(), r.Referer())
+		return
+	}
+
+	resp, err := s.processor.Undo(r.Context(), rev)
+	if err != nil {
+		addProcessErrorAndRedirect(w, r, fmt.Sprintf("error sending request to processor: %v", err), r.Referer())
+		return
+	}
+	s.history.drop(session, rev)
+
+	logger.Printf("processor response: %#v\n", resp)
+
+	undoResult, ok := resp.(*processor.UndoResult)
+	if !ok {
+		logger.Printf("unexpected response of processor to undo: %#v\n", resp)
+		http.Error(w, "unexpected response of processor", http.StatusInternalServerError)
+		return
+	}
+	s.history.setRestored(session, undoResult)
+
+	view := viewUnlabeled
+	if undoResult.Labeled {
+		view = viewLabeled
+	}
+	http.Redirect(w, r, indexURL(view, undoResult.Filename), 302)
+}
+
+// thumbHandler serves thumbnails of images from cache. Path of request is path of image relative to cache root
+func thumbHandler(cache *thumbnail.Cache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		thumbPath, err := cache.Get(strings.TrimPrefix(r.URL.Path, "/"))
+
+		var unsafePath *safepath.UnsafePathError
+		switch {
+		case err == nil:
+			w.Header().Set("Content-Type", "image/jpeg")
+			http.ServeFile(w, r, thumbPath)
+		case errors.As(err, &unsafePath):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, thumbnail.MissingImageError):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, processor.UnsupportedImageError):
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		default:
+			logger.Printf("error making thumbnail: %v\n", err)
+			http.Error(w, "error making thumbnail", http.StatusInternalServerError)
+		}
+	})
+}
+
+func (s *server) Start() error {
 	s.router = mux.NewRouter()
 
-	s.router.PathPrefix("/img/").Handler(http.StripPrefix("/img", http.FileServer(http.Dir(s.imgPath))))
+	s.router.PathPrefix("/img/").Handler(http.StripPrefix("/img", http.FileServer(safepath.FileSystem{Root: s.imgPath})))
+	s.router.PathPrefix("/labeled-img/").Handler(http.StripPrefix("/labeled-img", http.FileServer(safepath.FileSystem{Root: s.labeledPath})))
+	s.router.PathPrefix("/thumb/").Handler(http.StripPrefix("/thumb", thumbHandler(s.unlabeledThumb)))
+	s.router.PathPrefix("/labeled-thumb/").Handler(http.StripPrefix("/labeled-thumb", thumbHandler(s.labeledThumb)))
+
+	s.registerAPI(s.router)
 
 	s.router.HandleFunc("/", s.indexHandler)
 	s.router.HandleFunc("/process", s.processHandler)
+	s.router.HandleFunc("/unlabel", s.unlabelHandler).Methods(http.MethodPost)
+	s.router.HandleFunc("/{action:skip|reject|negative}", s.queueHandler).Methods(http.MethodPost)
+	s.router.HandleFunc("/undo", s.undoHandler).Methods(http.MethodPost)
 
 	logger.Printf("starting web server on %s", s.addr)
 
@@ -256,19 +668,55 @@ func (s *server) Start() {
 		Addr:    s.addr,
 	}
 
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("error starting web server: %w", err)
+	}
+
 	go func() {
-		if err := s.httpServer.ListenAndServe(); err != nil {
-			logger.Fatal("error starting web server", err)
+		if err := s.httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Printf("web server stopped: %v\n", err)
+			s.errors <- err
 		}
 	}()
+
+	return nil
+}
+
+func (s *server) Errors() <-chan error {
+	return s.errors
 }
 
-// StartServer starts new http server on specified host and port
-func NewServer(host, port string, imgPath string, processor processor.Processor) (Server, error) {
+func (s *server) Shutdown(ctx context.Context) error {
+	if s.httpServer == nil {
+		// wasn't started
+		return nil
+	}
+
+	logger.Println("shutting down web server")
+
+	return s.httpServer.Shutdown(ctx)
+}
+
+// StartServer starts new http server on specified host and port.
+// Images are served from roots of indexes, indexes should be updated by processor when it moves files.
+// Thumbnails of previews are cached in thumbnailPath. Processor is required.
+func NewServer(host, port string, unlabeledIndex, labeledIndex *imageindex.Index, thumbnailPath string, processor processor.Processor) (Server, error) {
+	if processor == nil {
+		return nil, errors.New("processor is required")
+	}
+
 	srv := &server{
-		processor: processor,
-		imgPath:   imgPath,
-		addr:      host + ":" + port,
+		processor:      processor,
+		imgPath:        unlabeledIndex.Root(),
+		labeledPath:    labeledIndex.Root(),
+		unlabeledIndex: unlabeledIndex,
+		labeledIndex:   labeledIndex,
+		unlabeledThumb: thumbnail.New(filepath.Join(thumbnailPath, viewUnlabeled), unlabeledIndex.Root(), thumbnail.DefaultSize),
+		labeledThumb:   thumbnail.New(filepath.Join(thumbnailPath, viewLabeled), labeledIndex.Root(), thumbnail.DefaultSize),
+		addr:           host + ":" + port,
+		history:        newHistory(),
+		errors:         make(chan error, 1),
 	}
 
 	return srv, nil
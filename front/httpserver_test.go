@@ -1,8 +1,21 @@
 package front
 
 import (
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/porfirion/osp/imageindex"
+	"github.com/porfirion/osp/processor"
 )
 
 func Test_findCurrentIndex(t *testing.T) {
@@ -21,7 +34,7 @@ func Test_findCurrentIndex(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := findCurrentIndex(tt.args.selectedFile, tt.args.files); got != tt.want {
+			if got := findCurrentIndex(tt.args.selectedFile, imageindex.NewSnapshot(tt.args.files)); got != tt.want {
 				t.Errorf("findCurrentIndex() = %v, want %v", got, tt.want)
 			}
 		})
@@ -74,4 +87,129 @@ func Test_takePreviews(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func Test_snapshotPreviews(t *testing.T) {
+	files := make([]string, 0, 30)
+	for i := 0; i < 30; i++ {
+		files = append(files, fmt.Sprintf("%02d.png", i))
+	}
+	snapshot := imageindex.NewSnapshot(files)
+
+	for current := -1; current <= len(files); current++ {
+		wantPreviews, wantL, wantR := takePreviews(previewImagesLimit, files, current)
+		gotPreviews, gotL, gotR := snapshotPreviews(previewImagesLimit, snapshot, current)
+		if !reflect.DeepEqual(gotPreviews, wantPreviews) || gotL != wantL || gotR != wantR {
+			t.Errorf("snapshotPreviews(%d) = %v %d %d, want %v %d %d", current, gotPreviews, gotL, gotR, wantPreviews, wantL, wantR)
+		}
+	}
+}
+
+func Test_indexURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		view     string
+		filename string
+		want     string
+	}{
+		{"empty", "", "", "/"},
+		{"unlabeled view is default", viewUnlabeled, "", "/"},
+		{"unlabeled file", viewUnlabeled, "1.png", "/?filename=1.png"},
+		{"labeled file", viewLabeled, "1.png", "/?filename=1.png&view=labeled"},
+		{"escaping", "", "a b&c.png", "/?filename=a+b%26c.png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := indexURL(tt.view, tt.filename); got != tt.want {
+				t.Errorf("indexURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_server_indexHandler_add(t *testing.T) {
+	s, cleanup := setupTestServer(t, processor.Options{})
+	defer cleanup()
+
+	// images appeared after last rescan
+	for _, name := range []string{"5.png", ".hidden.png", "broken.jpg"} {
+		file, err := os.Create(path.Join(s.imgPath, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = png.Encode(file, image.NewRGBA(image.Rect(0, 0, 10, 10)))
+		_ = file.Close()
+	}
+
+	tests := []struct {
+		filename string
+		indexed  bool
+	}{
+		{"5.png", true},
+		{".hidden.png", false},
+		{"cam", false},
+		{"2.json", false},
+		// quarantined file isn't sniffed again until rescan
+		{"broken.jpg", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.indexHandler(w, httptest.NewRequest(http.MethodGet, indexURL(viewUnlabeled, tt.filename), nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+
+			if _, ok := s.unlabeledIndex.Position(tt.filename); ok != tt.indexed {
+				t.Errorf("indexed = %v, want %v", ok, tt.indexed)
+			}
+		})
+	}
+
+	if len(s.unlabeledIndex.Quarantine()) != 1 {
+		t.Errorf("quarantine = %v, want broken.jpg only", s.unlabeledIndex.Quarantine())
+	}
+}
+
+func Test_server_StartShutdown(t *testing.T) {
+	if _, err := NewServer("127.0.0.1", "0", imageindex.New("", nil), imageindex.New("", nil), "", nil); err == nil {
+		t.Errorf("server without processor shouldn't be created")
+	}
+
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+	defer os.RemoveAll(tempDir)
+
+	unlabeled, labeled := path.Join(tempDir, "unlabeled"), path.Join(tempDir, "labeled")
+	_ = os.Mkdir(unlabeled, 0755)
+	_ = os.Mkdir(labeled, 0755)
+
+	p, err := processor.NewImageProcessor(unlabeled, labeled, processor.Options{})
+	if err != nil {
+		t.Fatal("error creating image processor")
+	}
+	defer p.Close()
+
+	srv, err := NewServer("127.0.0.1", "0", imageindex.New(unlabeled, nil), imageindex.New(labeled, nil), "", p)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := srv.Start(); err != nil {
+		t.Fatalf("unexpected error starting server: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error shutting down server: %v", err)
+	}
+
+	select {
+	case err := <-srv.Errors():
+		t.Errorf("server shouldn't report errors after shutdown, got %v", err)
+	default:
+	}
+}
@@ -19,6 +19,8 @@ var indexTemplateSource = `<!DOCTYPE html>
         .img-wrapper img {
             max-width: 600px;
             max-height: 600px;
+            /* boxes are drawn on image rotated by exif orientation (it's also reflected by naturalWidth/Height) */
+            image-orientation: from-image;
         }
 
         .clearfix:after {
@@ -75,6 +77,23 @@ var indexTemplateSource = `<!DOCTYPE html>
             overflow: hidden;
         }
 
+        .preview_skipped img {
+            opacity: 0.4;
+        }
+
+        .quarantine {
+            margin-bottom: 1rem;
+        }
+
+        .queue-actions form {
+            display: inline-block;
+            margin-right: 1rem;
+        }
+
+        .quarantine__reason {
+            color: grey;
+        }
+
         #canvas {
             cursor: crosshair;
             position: absolute;
@@ -87,57 +106,260 @@ var indexTemplateSource = `<!DOCTYPE html>
             font-size: 12px;
             color: #999;
         }
+
+        .view-tabs {
+            margin: 1rem 0;
+        }
+
+        .label-picker {
+            margin-top: .5rem;
+        }
+
+        .label-picker__btn {
+            margin: 0 .25rem .25rem 0;
+        }
+
+        .objects-list {
+            margin-bottom: 1rem;
+        }
+
+        .objects-list__item {
+            cursor: pointer;
+            font-size: 12px;
+        }
     </style>
     <script>
         var filename;
-        var rect;
-        var label;
+        // rects holds all drawn areas in client (scaled) coordinates
+        var rects = [];
+        // index of currently selected rect or -1
+        var selected = -1;
+        // rect that is being drawn right now
+        var drawing = null;
 
         function restoreData() {
-            filename = document.getElementsByName("filename")[0].value;
+            // the same filename can be present in both views, so they are stored separately
+            filename = document.getElementsByName("view")[0].value + ':' + document.getElementsByName("filename")[0].value;
 
             var prevFilename = sessionStorage.getItem("filename");
             if (prevFilename === filename) {
                 // we already edited this image. Let's restore it's data
-                var storedLabel = sessionStorage.getItem('label');
-                if (typeof storedLabel !== 'undefined' && storedLabel !== null) {
-                    document.getElementsByName('label')[0].value = storedLabel;
-                }
-                var storedRect = sessionStorage.getItem('rect');
-                if (typeof storedRect !== 'undefined' && storedRect !== null) {
+                var storedRects = sessionStorage.getItem('rects');
+                if (typeof storedRects !== 'undefined' && storedRects !== null) {
                     try {
-                        storedRect = JSON.parse(storedRect);
-                        rect = storedRect;
+                        storedRects = JSON.parse(storedRects);
+                        if (Array.isArray(storedRects)) {
+                            rects = storedRects;
+                        }
                     } catch (ex) {
-                        console.error('Error parsing stored rect', ex)
+                        console.error('Error parsing stored rects', ex)
                     }
                 }
+            } else if (Array.isArray(initialObjects)) {
+                // image was already labeled
+                rects = initialObjects.map(scaleObject);
+            } else if (Array.isArray(suggestions)) {
+                // proposals are editable like drawn areas, processor finds out which of them were accepted
+                rects = suggestions.map(suggestedRect);
             }
 
-            if (typeof rect === 'undefined' || rect === null) {
-                // it's a new image. Initialize data
-                rect = {
-                    left: 0,
-                    top: 0,
-                    right: 0,
-                    bottom: 0
-                };
-            }
+            selected = rects.length - 1;
+
             // update form
             storeData();
         }
 
-        function storeData() {
-            var label = document.getElementsByName('label')[0].value;
+        function suggestedRect(o) {
+            var rect = scaleObject(o);
+            rect.suggested = true;
+            rect.confidence = o.confidence;
+            return rect;
+        }
 
-            sessionStorage.setItem('filename', filename);
-            sessionStorage.setItem('rect', JSON.stringify(rect));
-            sessionStorage.setItem('label', label);
+        // fetchSuggestions asks API for boxes that labeler proposes in background. They are shown when it answers
+        // unless some areas are already drawn.
+        function fetchSuggestions() {
+            var status = document.getElementById('suggestions-status');
+            var name = document.getElementsByName("filename")[0].value;
+            var xhr = new XMLHttpRequest();
+            xhr.open('GET', '/api/v1/images/unlabeled/' + name.split('/').map(encodeURIComponent).join('/'));
+            xhr.onload = function () {
+                var resp;
+                try {
+                    resp = JSON.parse(xhr.responseText);
+                } catch (ex) {
+                    status.textContent = 'Error reading suggestions: ' + xhr.status;
+                    return;
+                }
+                if (xhr.status !== 200) {
+                    status.textContent = 'Error reading suggestions: ' + (resp.error ? resp.error.message : xhr.status);
+                    return;
+                }
+                if (resp.suggestions_pending) {
+                    setTimeout(fetchSuggestions, 2000);
+                    return;
+                }
+
+                status.textContent = '';
+                suggestions = resp.suggestions;
+                if (rects.length === 0 && Array.isArray(suggestions)) {
+                    rects = suggestions.map(suggestedRect);
+                    selectRect(rects.length - 1);
+                }
+            };
+            xhr.onerror = function () {
+                status.textContent = 'Error reading suggestions';
+            };
+            xhr.send();
+        }
+
+        // scaleObject converts object in natural coordinates of image into rect in client (scaled) ones
+        function scaleObject(o) {
+            var img = document.getElementById('img');
+            var scw = img.clientWidth / img.naturalWidth;
+            var sch = img.clientHeight / img.naturalHeight;
+
+            return {
+                label: o.label,
+                left: o.left * scw,
+                top: o.top * sch,
+                right: o.right * scw,
+                bottom: o.bottom * sch
+            };
+        }
+
+        function normalizeRect(rect) {
+            return {
+                label: rect.label,
+                left: Math.min(rect.left, rect.right),
+                top: Math.min(rect.top, rect.bottom),
+                right: Math.max(rect.left, rect.right),
+                bottom: Math.max(rect.top, rect.bottom)
+            };
+        }
+
+        function findRectAt(x, y) {
+            // last drawn rect is on top
+            for (var i = rects.length - 1; i >= 0; i--) {
+                var r = rects[i];
+                if (r.left <= x && x <= r.right && r.top <= y && y <= r.bottom) {
+                    return i;
+                }
+            }
+            return -1;
+        }
+
+        function selectRect(ind) {
+            selected = ind;
+            var labelInput = document.getElementById('label-input');
+            labelInput.value = selected >= 0 ? rects[selected].label : '';
+            storeData();
+            requestAnimationFrame(draw);
+        }
+
+        function deleteRect(ind) {
+            if (ind < 0 || ind >= rects.length) {
+                return;
+            }
+            rects.splice(ind, 1);
+            selectRect(rects.length - 1);
+        }
+
+        function onLabelChange() {
+            if (selected >= 0) {
+                rects[selected].label = document.getElementById('label-input').value;
+            }
+            storeData();
+            requestAnimationFrame(draw);
+        }
+
+        function findLabel(name) {
+            for (var i = 0; i < labels.length; i++) {
+                if (labels[i].name === name) {
+                    return labels[i];
+                }
+            }
+            return null;
+        }
+
+        function labelColor(name) {
+            var l = findLabel(name);
+            return l !== null && l.color ? l.color : null;
+        }
+
+        function setLabel(name) {
+            document.getElementById('label-input').value = name;
+            onLabelChange();
+        }
+
+        // prepareLabels assigns free digits as shortcuts to labels without key and renders label picker
+        function prepareLabels() {
+            if (!Array.isArray(labels)) {
+                labels = [];
+            }
+
+            var used = {};
+            labels.forEach(function (l) {
+                if (l.key) {
+                    used[l.key] = true;
+                }
+            });
+
+            var digits = '1234567890';
+            var di = 0;
+            labels.forEach(function (l) {
+                if (l.key) {
+                    return;
+                }
+                while (di < digits.length && used[digits[di]]) {
+                    di++;
+                }
+                if (di < digits.length) {
+                    l.key = digits[di];
+                    used[l.key] = true;
+                }
+            });
 
-            var l = Math.min(rect.left, rect.right);
-            var r = Math.max(rect.left, rect.right);
-            var t = Math.min(rect.top, rect.bottom);
-            var b = Math.max(rect.top, rect.bottom);
+            var picker = document.getElementById('label-picker');
+            labels.forEach(function (l) {
+                var btn = document.createElement('button');
+                btn.type = 'button';
+                btn.className = 'btn btn-sm btn-outline-dark label-picker__btn';
+                btn.title = l.description || '';
+                if (l.color) {
+                    btn.style.borderLeft = '10px solid ' + l.color;
+                }
+                btn.textContent = l.name + ' ';
+                if (l.key) {
+                    var kbd = document.createElement('kbd');
+                    kbd.textContent = l.key;
+                    btn.appendChild(kbd);
+                }
+                btn.addEventListener('click', function () {
+                    setLabel(l.name);
+                });
+                btn.dataset.label = l.name;
+                picker.appendChild(btn);
+            });
+
+            if (labels.length > 0) {
+                // only configured labels are allowed, so there is nothing to type
+                document.getElementById('label-input').readOnly = true;
+                picker.classList.remove('d-none');
+            }
+        }
+
+        function addHidden(container, name, value) {
+            var inp = document.createElement('input');
+            inp.type = 'hidden';
+            inp.name = name;
+            inp.value = value;
+            container.appendChild(inp);
+        }
+
+        function storeData() {
+            sessionStorage.setItem('filename', filename);
+            sessionStorage.setItem('rects', JSON.stringify(rects));
 
             // our image can be scaled. Let's find scale coefficients
             // (in general width and height are scale by the same coeff, but let's play it safe)
@@ -145,43 +367,87 @@ var indexTemplateSource = `<!DOCTYPE html>
             var scw = img.naturalWidth / img.clientWidth;
             var sch = img.naturalHeight / img.clientHeight;
 
-            l = Math.round(l * scw);
-            r = Math.round(r * scw);
-            t = Math.round(t * sch);
-            b = Math.round(b * sch);
-
             document.getElementsByName('width')[0].value = img.naturalWidth;
             document.getElementsByName('height')[0].value = img.naturalHeight;
 
-            document.getElementsByName('left')[0].value = l;
-            document.getElementsByName('top')[0].value = t;
-            document.getElementsByName('right')[0].value = r;
-            document.getElementsByName('bottom')[0].value = b;
-
-            document.getElementById('area-tip').innerHTML = ` + "`" + `Selected area left: ${l} top: ${t} right: ${r} bottom: ${b}` + "`" + `;
+            var container = document.getElementById('objects');
+            container.innerHTML = '';
+
+            var list = document.getElementById('objects-list');
+            list.innerHTML = '';
+
+            var ok = rects.length > 0;
+            var labelOk = true;
+
+            rects.forEach(function (rect, ind) {
+                var l = Math.round(rect.left * scw);
+                var r = Math.round(rect.right * scw);
+                var t = Math.round(rect.top * sch);
+                var b = Math.round(rect.bottom * sch);
+                var label = typeof rect.label === 'string' ? rect.label.trim() : '';
+
+                addHidden(container, 'objects.' + ind + '.label', label);
+                addHidden(container, 'objects.' + ind + '.left', l);
+                addHidden(container, 'objects.' + ind + '.top', t);
+                addHidden(container, 'objects.' + ind + '.right', r);
+                addHidden(container, 'objects.' + ind + '.bottom', b);
+
+                var item = document.createElement('li');
+                item.className = 'list-group-item objects-list__item' + (ind === selected ? ' active' : '');
+                item.innerHTML = ` + "`" + `<span class="objects-list__caption"></span>
+                    <button type="button" class="btn btn-sm btn-outline-danger float-right">delete</button>` + "`" + `;
+                item.querySelector('.objects-list__caption').textContent =
+                    ` + "`" + `${label || '(no label)'}: left ${l} top ${t} right ${r} bottom ${b}` + "`" + ` + suggestionCaption(rect);
+                item.addEventListener('click', function () {
+                    selectRect(ind);
+                });
+                item.querySelector('button').addEventListener('click', function (ev) {
+                    ev.stopPropagation();
+                    deleteRect(ind);
+                });
+                list.appendChild(item);
+
+                if (label === '' || (labels.length > 0 && findLabel(label) === null)) {
+                    ok = false;
+                    if (ind === selected) {
+                        labelOk = false;
+                    }
+                }
+            });
 
-            var ok = true;
-            if (l < r && t < b) {
-                document.getElementById('area-tip').classList.remove('area-tip_warn');
+            var tip = document.getElementById('area-tip');
+            if (rects.length > 0) {
+                tip.innerHTML = ` + "`" + `Selected areas: ${rects.length}. Click on area to select it, press Delete to remove selected one.` + "`" + `;
+                tip.classList.remove('area-tip_warn');
             } else {
-                document.getElementById('area-tip').innerHTML += ` + "`" + `<br/><span class="badge badge-danger">SELECTION HAS ZERO SIZE!</span>` + "`" + `;
-                document.getElementById('area-tip').classList.add('area-tip_warn');
-                ok = false;
+                tip.innerHTML = ` + "`" + `<span class="badge badge-danger">NO AREAS SELECTED!</span>` + "`" + `;
+                tip.classList.add('area-tip_warn');
             }
 
-            if (typeof label !== 'undefined' && label !== null && label.trim() !== '') {
-                // empty label
-                document.getElementsByName('label')[0].classList.remove("is-invalid");
+            var labelInput = document.getElementById('label-input');
+            labelInput.disabled = selected < 0;
+            document.querySelectorAll('.label-picker__btn').forEach(function (btn) {
+                btn.disabled = selected < 0;
+                if (selected >= 0 && rects[selected].label === btn.dataset.label) {
+                    btn.classList.add('active');
+                } else {
+                    btn.classList.remove('active');
+                }
+            });
+            if (labelOk) {
+                labelInput.classList.remove("is-invalid");
             } else {
-                document.getElementsByName('label')[0].classList.add("is-invalid");
-                ok = false;
+                labelInput.classList.add("is-invalid");
             }
 
-            if (ok) {
-                document.getElementById('submit').disabled = false;
-            } else {
-                document.getElementById('submit').disabled = true;
+            document.getElementById('submit').disabled = !ok;
+        }
+
+        function suggestionCaption(rect) {
+            if (!rect.suggested) {
+                return '';
             }
+            return typeof rect.confidence === 'number' ? ` + "`" + ` (suggested, confidence ${rect.confidence.toFixed(2)})` + "`" + ` : ' (suggested)';
         }
 
         function draw() {
@@ -192,10 +458,27 @@ var indexTemplateSource = `<!DOCTYPE html>
             ctx.fillStyle = "rgba(0, 255, 0, 0.3)";
             ctx.fillRect(0, 0, c.width, c.height);
 
-            ctx.strokeStyle = 'lime';
-            ctx.lineWidth = '1px';
-            ctx.clearRect(rect.left, rect.top, rect.right - rect.left, rect.bottom - rect.top);
-            ctx.strokeRect(rect.left, rect.top, rect.right - rect.left, rect.bottom - rect.top);
+            var all = rects.slice();
+            if (drawing !== null) {
+                all.push(normalizeRect(drawing));
+            }
+
+            all.forEach(function (rect) {
+                ctx.clearRect(rect.left, rect.top, rect.right - rect.left, rect.bottom - rect.top);
+            });
+
+            all.forEach(function (rect, ind) {
+                ctx.strokeStyle = labelColor(rect.label) || (ind === selected ? 'red' : 'lime');
+                ctx.lineWidth = ind === selected ? 3 : 1;
+                // suggestions are dashed
+                ctx.setLineDash(rect.suggested ? [6, 3] : []);
+                ctx.strokeRect(rect.left, rect.top, rect.right - rect.left, rect.bottom - rect.top);
+                if (rect.label) {
+                    ctx.fillStyle = ctx.strokeStyle;
+                    ctx.font = '12px sans-serif';
+                    ctx.fillText(rect.label, rect.left + 2, rect.top + 12);
+                }
+            });
         }
 
         function resizeCanvas() {
@@ -208,59 +491,99 @@ var indexTemplateSource = `<!DOCTYPE html>
             c.height = img.clientHeight;
         }
 
+        function finishDrawing(ev) {
+            if (drawing === null) {
+                return;
+            }
+            drawing.right = ev.offsetX;
+            drawing.bottom = ev.offsetY;
+
+            var rect = normalizeRect(drawing);
+            drawing = null;
+
+            if (rect.left === rect.right || rect.top === rect.bottom) {
+                // it was just a click - select area under cursor
+                selectRect(findRectAt(rect.left, rect.top));
+                return;
+            }
+
+            // new area inherits label of previously selected one
+            rect.label = selected >= 0 ? rects[selected].label : document.getElementById('label-input').value;
+            rects.push(rect);
+            selectRect(rects.length - 1);
+        }
+
         function onLoad() {
+            prepareLabels();
             restoreData();
 
-            var isDrawing = false;
-
             var c = document.getElementById('canvas');
             c.addEventListener('mousedown', function (ev) {
-                rect.left = ev.offsetX;
-                rect.top = ev.offsetY;
-                rect.right = rect.left;
-                rect.bottom = rect.top;
-                isDrawing = true;
+                drawing = {
+                    left: ev.offsetX,
+                    top: ev.offsetY,
+                    right: ev.offsetX,
+                    bottom: ev.offsetY
+                };
                 requestAnimationFrame(draw);
             });
             c.addEventListener('mousemove', function (ev) {
-                if (isDrawing) {
-                    rect.right = ev.offsetX;
-                    rect.bottom = ev.offsetY;
+                if (drawing !== null) {
+                    drawing.right = ev.offsetX;
+                    drawing.bottom = ev.offsetY;
                     requestAnimationFrame(draw);
                 }
             });
-            c.addEventListener('mouseup', function (ev) {
-                if (isDrawing) {
-                    isDrawing = false;
-                    rect.right = ev.offsetX;
-                    rect.bottom = ev.offsetY;
-                    storeData();
-                    requestAnimationFrame(draw);
+            c.addEventListener('mouseup', finishDrawing);
+            c.addEventListener('mouseout', finishDrawing);
+
+            document.addEventListener('keydown', function (ev) {
+                if (ev.target.tagName === 'INPUT') {
+                    return;
+                }
+                if (ev.key === 'Delete' || ev.key === 'Backspace') {
+                    ev.preventDefault();
+                    deleteRect(selected);
+                    return;
+                }
+                for (var i = 0; i < labels.length; i++) {
+                    if (labels[i].key === ev.key && selected >= 0) {
+                        ev.preventDefault();
+                        setLabel(labels[i].name);
+                        return;
+                    }
                 }
-            });
-            c.addEventListener('mouseout', function (ev) {
-                isDrawing = false;
-                storeData();
-                requestAnimationFrame(draw);
             });
 
-            document.getElementsByName('label')[0].addEventListener('change', storeData);
+            document.getElementById('label-input').addEventListener('input', onLabelChange);
 
             resizeCanvas();
             requestAnimationFrame(draw);
+
+            if (suggestionsPending) {
+                fetchSuggestions();
+            }
         }
     </script>
 </head>
 <body onload="onLoad()">
 <div class="container">
+    <ul class="nav nav-tabs view-tabs">
+        <li class="nav-item">
+            <a class="nav-link {{if eq .View "unlabeled"}}active{{end}}" href="/">Unlabeled</a>
+        </li>
+        <li class="nav-item">
+            <a class="nav-link {{if eq .View "labeled"}}active{{end}}" href="/?view=labeled">Labeled</a>
+        </li>
+    </ul>
     {{if .Previews}}
         <p>Showing {{.PreviewLeft}} - {{.PreviewRight}} of total {{.TotalFiles}} images</p>
         <div class="previews">
             {{range .Previews}}
-                <div class="preview {{if eq $.Filename .}}preview_current{{end}}">
-                    <a href="?filename={{.}}" class="preview__link">
+                <div class="preview {{if eq $.Filename .}}preview_current{{end}} {{if index $.Skipped .}}preview_skipped{{end}}">
+                    <a href="?{{if eq $.View "labeled"}}view=labeled&{{end}}filename={{.}}" class="preview__link">
                         <div class="preview__img-wrapper">
-                            <img src="/img/{{.}}" alt="{{.}}">
+                            <img src="{{$.ThumbPrefix}}/{{.}}" alt="{{.}}">
                         </div>
                         <div class="preview__caption">{{.}}</div>
                     </a>
@@ -270,6 +593,16 @@ var indexTemplateSource = `<!DOCTYPE html>
     {{else}}
         <p class="alert alert-warning" role="alert">No files found for previews.</p>
     {{end}}
+    {{if .Quarantine}}
+        <details class="quarantine">
+            <summary>Quarantine: {{len .Quarantine}} unreadable files</summary>
+            <ul>
+                {{range .Quarantine}}
+                    <li>{{.Filename}} <span class="quarantine__reason">{{.Reason}}</span></li>
+                {{end}}
+            </ul>
+        </details>
+    {{end}}
     {{if .Errors}}
         <div>
             {{range .Errors}}
@@ -277,35 +610,73 @@ var indexTemplateSource = `<!DOCTYPE html>
             {{end}}
         </div>
     {{end}}
+    {{if .CanUndo}}
+        <form action="/undo" method="POST" class="form-group">
+            <input type="submit" class="btn btn-outline-secondary" value="undo last save"/>
+        </form>
+    {{end}}
+    {{if .Restored}}
+        <p class="alert alert-info" role="alert">Boxes of undone save are restored. They aren't saved yet.</p>
+    {{end}}
     {{if .Filename}}
+        <script>
+            var initialObjects = {{.Objects}};
+            var suggestions = {{.Suggestions}};
+            var suggestionsPending = {{.SuggestionsPending}};
+            var labels = {{.Labels}};
+        </script>
         <form action="/process" method="POST">
             <h3>{{.Filename}}</h3>
             <div class="form-group">
                 <div id="wrapper" class="img-wrapper clearfix">
-                    <img id="img" src="/img/{{.Filename}}" onload="resizeCanvas()"/>
+                    <img id="img" src="{{.ImgPrefix}}/{{.Filename}}" onload="resizeCanvas()"/>
                     <canvas id="canvas"></canvas>
                 </div>
                 <p id="area-tip" class="area-tip"></p>
+                <p id="suggestions-status" class="text-muted">{{if .SuggestionsPending}}Labeler is proposing boxes...{{end}}</p>
             </div>
             <div class="form-group">
-                <label for="label-input">Area label</label>
-                <input id="label-input" type="text" class="form-control" name="label"
-                       placeholder="enter area label here"
-                       required/>
+                <label for="label-input">Selected area label</label>
+                <input id="label-input" type="text" class="form-control"
+                       placeholder="enter area label here"/>
+                <div id="label-picker" class="label-picker d-none"></div>
             </div>
+            <ul id="objects-list" class="list-group objects-list"></ul>
             <div class="form-group">
+                <input type="hidden" name="view" value="{{.View}}"/>
                 <input type="hidden" name="filename" value="{{.Filename}}"/>
                 <input type="hidden" name="width" value="0"/>
                 <input type="hidden" name="height" value="0"/>
-                <input type="hidden" name="left" value="0"/>
-                <input type="hidden" name="top" value="0"/>
-                <input type="hidden" name="right" value="0"/>
-                <input type="hidden" name="bottom" value="0"/>
+                <div id="objects"></div>
             </div>
             <div class="form-group">
                 <input id="submit" type="submit" class="btn btn-primary" value="save"/>
             </div>
         </form>
+        {{if eq .View "labeled"}}
+            <form action="/unlabel" method="POST"
+                  onsubmit="return confirm('Move image back to unlabeled and remove its annotation?')">
+                <input type="hidden" name="filename" value="{{.Filename}}"/>
+                <input type="submit" class="btn btn-outline-secondary" value="move back to unlabeled"/>
+            </form>
+        {{else}}
+            <div class="queue-actions form-group">
+                <form action="/skip" method="POST">
+                    <input type="hidden" name="filename" value="{{.Filename}}"/>
+                    <input type="submit" class="btn btn-outline-secondary" value="skip"/>
+                </form>
+                <form action="/negative" method="POST"
+                      onsubmit="return confirm('Save image as one without objects?')">
+                    <input type="hidden" name="filename" value="{{.Filename}}"/>
+                    <input type="submit" class="btn btn-outline-secondary" value="no objects"/>
+                </form>
+                <form action="/reject" method="POST" class="form-inline">
+                    <input type="hidden" name="filename" value="{{.Filename}}"/>
+                    <input type="text" name="reason" class="form-control mr-2" placeholder="reason of rejection" required/>
+                    <input type="submit" class="btn btn-outline-danger" value="reject"/>
+                </form>
+            </div>
+        {{end}}
     {{else}}
         <div class="alert alert-danger">No image found to edit. Images directory is empty?</div>
     {{end}}
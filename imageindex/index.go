@@ -0,0 +1,322 @@
+// Package imageindex keeps sorted list of images of a directory tree in memory,
+// so pages and neighbours of image are found without reading directory on every request.
+package imageindex
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/porfirion/osp/processor"
+)
+
+// DefaultRescanInterval is used when rescan interval isn't set
+const DefaultRescanInterval = time.Minute
+
+var logger = log.New(os.Stdout, "ImageIndex: ", 0)
+
+// Quarantined is file that looks like image by extension but can't be read
+type Quarantined struct {
+	Filename string `json:"filename"`
+	Reason   string `json:"reason"`
+}
+
+// sniffResult is cached result of processor.SniffImage. File is sniffed again only if it's size or mtime changes
+type sniffResult struct {
+	size    int64
+	modTime time.Time
+	reason  string
+}
+
+// change is Add or Remove of file. Added file is sniffed before change is applied, so lock isn't held during i/o.
+// Changes made while rescan is in progress are applied again to it's result.
+type change struct {
+	filename string
+	add      bool
+	// result of sniffing added file, exists is false if file wasn't found
+	result sniffResult
+	exists bool
+}
+
+// Index is sorted list of images under Root. Images are identified by slash separated paths relative to Root.
+// Index is refreshed by periodic rescans and by Add/Remove calls of those who move files (processor).
+type Index struct {
+	root       string
+	extensions []string
+
+	mu sync.RWMutex
+	// files and quarantine are never modified in place, so their snapshots can be used without lock.
+	// Files are kept in persistent tree: single file is added or removed in O(log n).
+	files      Snapshot
+	quarantine []Quarantined
+	sniffed    map[string]sniffResult
+	// scanning is true while rescan is in progress. Changes made meanwhile are kept in pending
+	scanning bool
+	pending  []change
+
+	started  bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// New creates empty index of images under root. Only files with one of extensions are indexed.
+// Call Rescan or Start to fill it.
+func New(root string, extensions []string) *Index {
+	return &Index{
+		root:       root,
+		extensions: extensions,
+		sniffed:    make(map[string]sniffResult),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Root returns directory of index
+func (i *Index) Root() string {
+	return i.root
+}
+
+// Start scans root and then rescans it every interval (DefaultRescanInterval if interval isn't positive)
+// until Close is called
+func (i *Index) Start(interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultRescanInterval
+	}
+
+	if err := i.Rescan(); err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	i.started = true
+	i.mu.Unlock()
+
+	go func() {
+		defer close(i.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := i.Rescan(); err != nil {
+					logger.Printf("error rescanning %s: %v\n", i.root, err)
+				}
+			case <-i.stop:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close stops periodic rescans. It's safe to call it even if index wasn't started
+func (i *Index) Close() {
+	i.stopOnce.Do(func() {
+		close(i.stop)
+	})
+
+	i.mu.RLock()
+	started := i.started
+	i.mu.RUnlock()
+
+	if started {
+		// rescan in progress is finished before stopping
+		<-i.done
+	}
+}
+
+// Rescan walks whole root and replaces content of index. Only new and changed files are sniffed.
+func (i *Index) Rescan() error {
+	i.mu.Lock()
+	if i.scanning {
+		i.mu.Unlock()
+		return nil
+	}
+	i.scanning = true
+	// cache is read by scan without lock, so changes made meanwhile are recorded into new map
+	cache := i.sniffed
+	i.sniffed = make(map[string]sniffResult)
+	i.mu.Unlock()
+
+	files, quarantine, sniffed, err := i.scan(cache)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.scanning = false
+	pending := i.pending
+	i.pending = nil
+
+	if err == nil {
+		// files are listed sorted
+		i.files, i.quarantine, i.sniffed = Snapshot{root: buildTree(files)}, quarantine, sniffed
+	} else {
+		// content is kept, changes made meanwhile are applied to it again
+		i.sniffed = cache
+	}
+
+	// files could be moved by processor while we were walking
+	for _, c := range pending {
+		i.apply(c)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error scanning %s: %w", i.root, err)
+	}
+
+	return nil
+}
+
+// scan lists images under root using cached sniff results of unchanged files
+func (i *Index) scan(cache map[string]sniffResult) (files []string, quarantine []Quarantined, sniffed map[string]sniffResult, err error) {
+	found, err := processor.ListFiles(i.root)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	files = make([]string, 0, len(found))
+	quarantine = make([]Quarantined, 0)
+	sniffed = make(map[string]sniffResult, len(found))
+
+	for _, name := range found {
+		if !processor.HasImageExtension(name, i.extensions) {
+			continue
+		}
+
+		result, ok := i.sniff(name, cache)
+		if !ok {
+			// file was removed while we were walking
+			continue
+		}
+
+		sniffed[name] = result
+		if result.reason != "" {
+			quarantine = append(quarantine, Quarantined{Filename: name, Reason: result.reason})
+		} else {
+			files = append(files, name)
+		}
+	}
+
+	return files, quarantine, sniffed, nil
+}
+
+// sniff checks content of file if it isn't in cache or was changed since it was cached
+func (i *Index) sniff(name string, cache map[string]sniffResult) (sniffResult, bool) {
+	filePath := filepath.Join(i.root, filepath.FromSlash(name))
+
+	info, err := os.Stat(filePath)
+	if err != nil || info.IsDir() {
+		return sniffResult{}, false
+	}
+
+	if cached, ok := cache[name]; ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached, true
+	}
+
+	result := sniffResult{size: info.Size(), modTime: info.ModTime()}
+	if err := processor.SniffImage(filePath); err != nil {
+		result.reason = err.Error()
+	}
+
+	return result, true
+}
+
+// Add puts file into index (or into quarantine if it can't be read). Files with other extensions are ignored.
+// File is read before index is locked, so readers aren't blocked by slow disk.
+func (i *Index) Add(filename string) {
+	if !processor.HasImageExtension(filename, i.extensions) {
+		return
+	}
+
+	result, exists := i.sniff(filename, nil)
+	i.update(change{filename: filename, add: true, result: result, exists: exists})
+}
+
+// Remove deletes file from index and quarantine
+func (i *Index) Remove(filename string) {
+	i.update(change{filename: filename, add: false})
+}
+
+func (i *Index) update(c change) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.scanning {
+		i.pending = append(i.pending, c)
+	}
+
+	i.apply(c)
+}
+
+// apply adds or removes file. Should be called with lock held
+func (i *Index) apply(c change) {
+	i.remove(c.filename)
+
+	if !c.add || !c.exists {
+		return
+	}
+
+	i.sniffed[c.filename] = c.result
+	if c.result.reason != "" {
+		quarantine := make([]Quarantined, 0, len(i.quarantine)+1)
+		quarantine = append(quarantine, i.quarantine...)
+		i.quarantine = append(quarantine, Quarantined{Filename: c.filename, Reason: c.result.reason})
+		return
+	}
+
+	i.files = Snapshot{root: insertNode(i.files.root, c.filename)}
+}
+
+// remove deletes file from index. Should be called with lock held
+func (i *Index) remove(filename string) {
+	delete(i.sniffed, filename)
+
+	i.files = Snapshot{root: removeNode(i.files.root, filename)}
+
+	for pos, q := range i.quarantine {
+		if q.Filename == filename {
+			quarantine := make([]Quarantined, 0, len(i.quarantine)-1)
+			quarantine = append(quarantine, i.quarantine[:pos]...)
+			i.quarantine = append(quarantine, i.quarantine[pos+1:]...)
+			break
+		}
+	}
+}
+
+// Snapshot returns sorted list of indexed images. It isn't changed by later updates.
+func (i *Index) Snapshot() Snapshot {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.files
+}
+
+// Files returns sorted list of all indexed images. It copies whole list, use Snapshot to take pages.
+func (i *Index) Files() []string {
+	return i.Snapshot().All()
+}
+
+// Quarantine returns snapshot of files that can't be read as images. It must not be modified.
+func (i *Index) Quarantine() []Quarantined {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.quarantine
+}
+
+// Count returns number of indexed images
+func (i *Index) Count() int {
+	return i.Snapshot().Len()
+}
+
+// Position returns index of file in sorted list of images
+func (i *Index) Position(filename string) (int, bool) {
+	return i.Snapshot().Position(filename)
+}
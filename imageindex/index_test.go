@@ -0,0 +1,127 @@
+package imageindex
+
+import (
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/porfirion/osp/processor"
+)
+
+// writeImage creates 1x1 png image (or broken one) with all parent directories
+func writeImage(t *testing.T, root, name string, valid bool) {
+	filePath := path.Join(root, name)
+	if err := os.MkdirAll(path.Dir(filePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if valid {
+		_ = png.Encode(file, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+	} else {
+		_, _ = file.Write([]byte("not an image"))
+	}
+}
+
+func TestIndex(t *testing.T) {
+	root, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+	defer os.RemoveAll(root)
+
+	writeImage(t, root, "b.png", true)
+	writeImage(t, root, "a.png", true)
+	writeImage(t, root, "a/c.png", true)
+	writeImage(t, root, "broken.png", false)
+	writeImage(t, root, "a.xml", false)
+
+	index := New(root, processor.DefaultExtensions)
+	if err := index.Start(time.Hour); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer index.Close()
+
+	if want := []string{"a.png", "a/c.png", "b.png"}; !reflect.DeepEqual(index.Files(), want) {
+		t.Errorf("Files() = %v, want %v", index.Files(), want)
+	}
+	if q := index.Quarantine(); len(q) != 1 || q[0].Filename != "broken.png" {
+		t.Errorf("Quarantine() = %v, want only broken.png", q)
+	}
+
+	if pos, ok := index.Position("b.png"); !ok || pos != 2 {
+		t.Errorf("Position(b.png) = %d, %v, want 2, true", pos, ok)
+	}
+	if _, ok := index.Position("c.png"); ok {
+		t.Errorf("c.png shouldn't be found")
+	}
+
+	// snapshots aren't changed by later updates
+	snapshot := index.Files()
+
+	writeImage(t, root, "ab.png", true)
+	index.Add("ab.png")
+	index.Remove("a.png")
+	index.Remove("broken.png")
+
+	if want := []string{"a/c.png", "ab.png", "b.png"}; !reflect.DeepEqual(index.Files(), want) {
+		t.Errorf("Files() after update = %v, want %v", index.Files(), want)
+	}
+	if len(index.Quarantine()) != 0 {
+		t.Errorf("Quarantine() after remove = %v, want empty", index.Quarantine())
+	}
+	if want := []string{"a.png", "a/c.png", "b.png"}; !reflect.DeepEqual(snapshot, want) {
+		t.Errorf("snapshot was changed: %v", snapshot)
+	}
+
+	// file became readable
+	writeImage(t, root, "broken.png", true)
+	if err := index.Rescan(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if want := []string{"a.png", "a/c.png", "ab.png", "b.png", "broken.png"}; !reflect.DeepEqual(index.Files(), want) {
+		t.Errorf("Files() after rescan = %v, want %v", index.Files(), want)
+	}
+	if index.Count() != 5 {
+		t.Errorf("Count() = %d, want 5", index.Count())
+	}
+}
+
+func TestIndex_Add(t *testing.T) {
+	root, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+	defer os.RemoveAll(root)
+
+	index := New(root, processor.DefaultExtensions)
+
+	writeImage(t, root, "a.png", true)
+	writeImage(t, root, "b.png", false)
+
+	index.Add("a.png")
+	index.Add("a.png")
+	index.Add("b.png")
+	index.Add("missing.png")
+	index.Add("a.xml")
+
+	if want := []string{"a.png"}; !reflect.DeepEqual(index.Files(), want) {
+		t.Errorf("Files() = %v, want %v", index.Files(), want)
+	}
+	if q := index.Quarantine(); len(q) != 1 || q[0].Filename != "b.png" {
+		t.Errorf("Quarantine() = %v, want only b.png", q)
+	}
+
+	// closing not started index doesn't block
+	index.Close()
+}
@@ -0,0 +1,229 @@
+package imageindex
+
+import (
+	"sort"
+)
+
+// node of persistent AVL tree of filenames. Nodes are never changed after they are built: insert and remove copy
+// path from root to changed node (O(log n) nodes), so older roots stay valid snapshots.
+type node struct {
+	name        string
+	left, right *node
+	height      int
+	// size is number of nodes in subtree, it's used to find positions
+	size int
+}
+
+func height(n *node) int {
+	if n == nil {
+		return 0
+	}
+	return n.height
+}
+
+func size(n *node) int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+func newNode(name string, left, right *node) *node {
+	h := height(left)
+	if height(right) > h {
+		h = height(right)
+	}
+
+	return &node{name: name, left: left, right: right, height: h + 1, size: size(left) + size(right) + 1}
+}
+
+// balance builds node from subtrees whose heights differ by at most 2, rotating them if needed
+func balance(name string, left, right *node) *node {
+	switch {
+	case height(left) > height(right)+1:
+		if height(left.left) >= height(left.right) {
+			return newNode(left.name, left.left, newNode(name, left.right, right))
+		}
+		lr := left.right
+		return newNode(lr.name, newNode(left.name, left.left, lr.left), newNode(name, lr.right, right))
+	case height(right) > height(left)+1:
+		if height(right.right) >= height(right.left) {
+			return newNode(right.name, newNode(name, left, right.left), right.right)
+		}
+		rl := right.left
+		return newNode(rl.name, newNode(name, left, rl.left), newNode(right.name, rl.right, right.right))
+	}
+
+	return newNode(name, left, right)
+}
+
+// insertNode returns tree with name added. Tree itself is returned if it already contains name.
+func insertNode(n *node, name string) *node {
+	if n == nil {
+		return newNode(name, nil, nil)
+	}
+
+	switch {
+	case name < n.name:
+		if left := insertNode(n.left, name); left != n.left {
+			return balance(n.name, left, n.right)
+		}
+	case name > n.name:
+		if right := insertNode(n.right, name); right != n.right {
+			return balance(n.name, n.left, right)
+		}
+	}
+
+	return n
+}
+
+// removeNode returns tree without name. Tree itself is returned if it doesn't contain name.
+func removeNode(n *node, name string) *node {
+	if n == nil {
+		return nil
+	}
+
+	switch {
+	case name < n.name:
+		if left := removeNode(n.left, name); left != n.left {
+			return balance(n.name, left, n.right)
+		}
+		return n
+	case name > n.name:
+		if right := removeNode(n.right, name); right != n.right {
+			return balance(n.name, n.left, right)
+		}
+		return n
+	}
+
+	if n.left == nil {
+		return n.right
+	}
+	if n.right == nil {
+		return n.left
+	}
+
+	// removed node is replaced by the smallest one of right subtree
+	next := n.right
+	for next.left != nil {
+		next = next.left
+	}
+
+	return balance(next.name, n.left, removeNode(n.right, next.name))
+}
+
+// buildTree builds balanced tree from sorted names without duplicates
+func buildTree(names []string) *node {
+	if len(names) == 0 {
+		return nil
+	}
+
+	mid := len(names) / 2
+	return newNode(names[mid], buildTree(names[:mid]), buildTree(names[mid+1:]))
+}
+
+// appendRange appends names at positions [from, to) of tree to dst in sorted order
+func appendRange(dst []string, n *node, from, to int) []string {
+	if n == nil || from >= to {
+		return dst
+	}
+
+	leftSize := size(n.left)
+	if from < leftSize {
+		dst = appendRange(dst, n.left, from, to)
+	}
+	if from <= leftSize && leftSize < to {
+		dst = append(dst, n.name)
+	}
+	if to > leftSize+1 {
+		dst = appendRange(dst, n.right, from-leftSize-1, to-leftSize-1)
+	}
+
+	return dst
+}
+
+// Snapshot is sorted list of images at some moment. It isn't changed by later updates of index, so it can be used
+// without lock. Lookups by name or position take O(log n).
+type Snapshot struct {
+	root *node
+}
+
+// NewSnapshot creates snapshot of files. They are sorted, duplicates are dropped.
+func NewSnapshot(files []string) Snapshot {
+	sorted := append([]string(nil), files...)
+	sort.Strings(sorted)
+
+	unique := sorted[:0]
+	for ind, f := range sorted {
+		if ind == 0 || f != sorted[ind-1] {
+			unique = append(unique, f)
+		}
+	}
+
+	return Snapshot{root: buildTree(unique)}
+}
+
+// Len returns number of files
+func (s Snapshot) Len() int {
+	return size(s.root)
+}
+
+// At returns file at position in sorted order or empty string if position is out of range
+func (s Snapshot) At(pos int) string {
+	if pos < 0 || pos >= s.Len() {
+		return ""
+	}
+
+	n := s.root
+	for {
+		leftSize := size(n.left)
+		switch {
+		case pos < leftSize:
+			n = n.left
+		case pos > leftSize:
+			pos -= leftSize + 1
+			n = n.right
+		default:
+			return n.name
+		}
+	}
+}
+
+// Position returns position of file in sorted order. If file isn't found, position where it would be inserted
+// is returned, like sort.SearchStrings does.
+func (s Snapshot) Position(filename string) (int, bool) {
+	pos := 0
+	for n := s.root; n != nil; {
+		switch {
+		case filename < n.name:
+			n = n.left
+		case filename > n.name:
+			pos += size(n.left) + 1
+			n = n.right
+		default:
+			return pos + size(n.left), true
+		}
+	}
+
+	return pos, false
+}
+
+// Slice returns files at positions [from, to), bounds are clipped to list
+func (s Snapshot) Slice(from, to int) []string {
+	if from < 0 {
+		from = 0
+	}
+	if to > s.Len() {
+		to = s.Len()
+	}
+	if from >= to {
+		return []string{}
+	}
+
+	return appendRange(make([]string, 0, to-from), s.root, from, to)
+}
+
+// All returns all files. It copies whole list, use Slice for pages.
+func (s Snapshot) All() []string {
+	return s.Slice(0, s.Len())
+}
@@ -0,0 +1,108 @@
+package imageindex
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// checkTree verifies order, sizes and balance of tree
+func checkTree(t *testing.T, n *node) {
+	if n == nil {
+		return
+	}
+
+	if n.left != nil && n.left.name >= n.name || n.right != nil && n.right.name <= n.name {
+		t.Fatalf("node %s is out of order", n.name)
+	}
+	if n.size != size(n.left)+size(n.right)+1 {
+		t.Fatalf("size of %s = %d, want %d", n.name, n.size, size(n.left)+size(n.right)+1)
+	}
+	if diff := height(n.left) - height(n.right); diff < -1 || diff > 1 {
+		t.Fatalf("node %s isn't balanced: %d", n.name, diff)
+	}
+
+	checkTree(t, n.left)
+	checkTree(t, n.right)
+}
+
+func TestSnapshot(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	root := (*node)(nil)
+	present := make(map[string]bool)
+	var snapshot Snapshot
+	var snapshotFiles []string
+
+	for step := 0; step < 2000; step++ {
+		name := fmt.Sprintf("%03d.png", rnd.Intn(300))
+		if rnd.Intn(3) == 0 {
+			root = removeNode(root, name)
+			delete(present, name)
+		} else {
+			root = insertNode(root, name)
+			present[name] = true
+		}
+		checkTree(t, root)
+
+		if step == 1000 {
+			snapshot = Snapshot{root: root}
+			snapshotFiles = snapshot.All()
+		}
+	}
+
+	want := make([]string, 0, len(present))
+	for name := range present {
+		want = append(want, name)
+	}
+	sort.Strings(want)
+
+	s := Snapshot{root: root}
+	if got := s.All(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("All() = %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(NewSnapshot(append(want, want[0])).All(), want) {
+		t.Errorf("NewSnapshot() should sort files and drop duplicates")
+	}
+
+	for pos, name := range want {
+		if got := s.At(pos); got != name {
+			t.Errorf("At(%d) = %s, want %s", pos, got, name)
+		}
+		if got, ok := s.Position(name); !ok || got != pos {
+			t.Errorf("Position(%s) = %d, %v, want %d, true", name, got, ok, pos)
+		}
+	}
+
+	if pos, ok := s.Position("000.pn"); ok || pos != sort.SearchStrings(want, "000.pn") {
+		t.Errorf("Position() of missing file = %d, %v, want insertion position", pos, ok)
+	}
+	if s.At(-1) != "" || s.At(s.Len()) != "" {
+		t.Errorf("At() out of range should be empty")
+	}
+
+	for _, r := range [][2]int{{0, 5}, {10, 20}, {s.Len() - 3, s.Len() + 10}, {-5, 2}, {s.Len() + 1, s.Len() + 2}} {
+		from, to := r[0], r[1]
+		wantSlice := want[clip(from, len(want)):clip(to, len(want))]
+		if got := s.Slice(from, to); !reflect.DeepEqual(got, wantSlice) {
+			t.Errorf("Slice(%d, %d) = %v, want %v", from, to, got, wantSlice)
+		}
+	}
+
+	// snapshot taken earlier isn't changed by later updates
+	if !reflect.DeepEqual(snapshot.All(), snapshotFiles) {
+		t.Errorf("snapshot was changed")
+	}
+}
+
+func clip(v, max int) int {
+	if v < 0 {
+		return 0
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
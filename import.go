@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/porfirion/osp/importer"
+	"github.com/porfirion/osp/processor"
+)
+
+// runImport places images of existing dataset with their annotations into labeled (or unlabeled) path
+func runImport(config ospConfig, args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	format := flags.String("format", "voc", "input format: voc, coco, yolo, labelme")
+	input := flags.String("input", "", "directory of xml files (voc), json document (coco), directory of labels (yolo) or json files (labelme)")
+	images := flags.String("images", "", "directory of images (default depends on format)")
+	target := flags.String("to", "labeled", "where to place images: labeled or unlabeled (as pre-annotations)")
+	dryRun := flags.Bool("dry-run", false, "only report problems, don't write anything")
+	_ = flags.Parse(args)
+
+	if *input == "" {
+		logger.Fatalf("input is not specified")
+	}
+
+	options := importer.Options{
+		ImagesPath: *images,
+		Labels:     config.Labels,
+		MinBoxSize: config.MinBoxSize,
+		ClampBoxes: config.ClampBoxes,
+		Extensions: config.ImageExtensions,
+		DryRun:     *dryRun,
+	}
+
+	switch *target {
+	case "labeled":
+		options.TargetPath = config.LabeledPath
+		// formats of pre-annotations are chosen by processor when image is labeled
+		writers, err := processor.NewAnnotationWriters(config.AnnotationFormats, config.Labels)
+		if err != nil {
+			logger.Fatalf("error creating annotation writers: %v", err)
+		}
+		options.Writers = writers
+	case "unlabeled":
+		options.TargetPath = config.UnlabeledPath
+	default:
+		logger.Fatalf("unknown import target %q", *target)
+	}
+
+	var (
+		report *importer.Report
+		err    error
+	)
+	switch *format {
+	case "voc":
+		report, err = importer.ImportVOC(*input, options)
+	case "coco":
+		report, err = importer.ImportCOCO(*input, options)
+	case "yolo":
+		report, err = importer.ImportYOLO(*input, options)
+	case "labelme":
+		report, err = importer.ImportLabelMe(*input, options)
+	default:
+		logger.Fatalf("unknown import format %q", *format)
+	}
+	if err != nil {
+		logger.Fatalf("error importing %s: %v", *format, err)
+	}
+
+	for _, p := range report.Problems {
+		logger.Printf("skipped %v", p)
+	}
+
+	if *dryRun {
+		logger.Printf("dry run: %d images can be imported, %d problems", len(report.Imported), len(report.Problems))
+	} else {
+		logger.Printf("imported %d images into %s, %d problems", len(report.Imported), options.TargetPath, len(report.Problems))
+	}
+}
@@ -0,0 +1,94 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/porfirion/osp/processor"
+)
+
+// cocoAnnotations converts COCO document into annotations. Images without annotations become negative examples.
+// Image is reported and dropped if any of it's annotations is broken.
+func cocoAnnotations(doc *processor.COCODocument, report *Report) []*processor.Annotation {
+	categories := make(map[int]string, len(doc.Categories))
+	for _, c := range doc.Categories {
+		categories[c.ID] = c.Name
+	}
+
+	images := make(map[int]*processor.Annotation, len(doc.Images))
+	broken := make(map[int]bool)
+	for _, img := range doc.Images {
+		images[img.ID] = &processor.Annotation{
+			Filename: filepath.ToSlash(img.FileName),
+			Width:    img.Width,
+			Height:   img.Height,
+			Objects:  make([]processor.Object, 0),
+		}
+	}
+
+	for _, ann := range doc.Annotations {
+		a, ok := images[ann.ImageID]
+		if !ok {
+			report.addProblem(fmt.Sprintf("image id %d", ann.ImageID), fmt.Errorf("%w: annotation %d refers to unknown image", MalformedAnnotationError, ann.ID))
+			continue
+		}
+
+		label, ok := categories[ann.CategoryID]
+		if !ok {
+			report.addProblem(a.Filename, fmt.Errorf("%w: category id %d", UnknownClassError, ann.CategoryID))
+			broken[ann.ImageID] = true
+			continue
+		}
+
+		if len(ann.BBox) != 4 {
+			report.addProblem(a.Filename, fmt.Errorf("%w: annotation %d has bbox of %d values", MalformedAnnotationError, ann.ID, len(ann.BBox)))
+			broken[ann.ImageID] = true
+			continue
+		}
+
+		a.Objects = append(a.Objects, ann.Object(label))
+	}
+
+	annotations := make([]*processor.Annotation, 0, len(images))
+	for id, a := range images {
+		if !broken[id] {
+			annotations = append(annotations, a)
+		}
+	}
+
+	sort.Slice(annotations, func(i, j int) bool {
+		return annotations[i].Filename < annotations[j].Filename
+	})
+
+	return annotations
+}
+
+// ImportCOCO imports COCO json document. Filenames of images are relative to directory of document by default.
+func ImportCOCO(cocoPath string, options Options) (*Report, error) {
+	if options.ImagesPath == "" {
+		options.ImagesPath = filepath.Dir(cocoPath)
+	}
+
+	if err := checkTarget(options); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(cocoPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening coco document: %w", err)
+	}
+	defer file.Close()
+
+	doc := &processor.COCODocument{}
+	if err := json.NewDecoder(file).Decode(doc); err != nil {
+		return nil, fmt.Errorf("error parsing coco document: %w", err)
+	}
+
+	report := &Report{}
+	importAnnotations(cocoAnnotations(doc, report), options, report)
+
+	return report, nil
+}
@@ -0,0 +1,90 @@
+package importer
+
+import (
+	"image"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/porfirion/osp/processor"
+)
+
+func Test_cocoAnnotations(t *testing.T) {
+	doc := &processor.COCODocument{
+		Images: []processor.COCOImage{
+			{ID: 1, FileName: "b.jpg", Width: 100, Height: 50},
+			{ID: 2, FileName: "a.jpg", Width: 100, Height: 50},
+			{ID: 3, FileName: "negative.jpg", Width: 100, Height: 50},
+			{ID: 4, FileName: "unknown.jpg", Width: 100, Height: 50},
+		},
+		Categories: []processor.COCOCategory{{ID: 1, Name: "cat"}, {ID: 2, Name: "dog"}},
+		Annotations: []processor.COCOAnnotation{
+			{ID: 1, ImageID: 1, CategoryID: 2, BBox: []float64{10, 10, 20, 20}},
+			{ID: 2, ImageID: 2, CategoryID: 1, BBox: []float64{0.4, 1.6, 9.8, 10}},
+			{ID: 3, ImageID: 1, CategoryID: 1, BBox: []float64{0, 0, 100, 50}},
+			{ID: 4, ImageID: 4, CategoryID: 7, BBox: []float64{0, 0, 1, 1}},
+			{ID: 5, ImageID: 9, CategoryID: 1, BBox: []float64{0, 0, 1, 1}},
+		},
+	}
+
+	report := &Report{}
+	got := cocoAnnotations(doc, report)
+
+	want := []*processor.Annotation{
+		{Filename: "a.jpg", Width: 100, Height: 50, Objects: []processor.Object{obj("cat", 0, 2, 10, 12)}},
+		{Filename: "b.jpg", Width: 100, Height: 50, Objects: []processor.Object{obj("dog", 10, 10, 30, 30), obj("cat", 0, 0, 100, 50)}},
+		{Filename: "negative.jpg", Width: 100, Height: 50, Objects: []processor.Object{}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("annotations = %v, want %v", got, want)
+	}
+
+	checkProblems(t, report, map[string]error{
+		"unknown.jpg": UnknownClassError,
+		"image id 9":  MalformedAnnotationError,
+	})
+}
+
+func TestImportCOCO(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	source, target := path.Join(dir, "source"), path.Join(dir, "unlabeled")
+	_ = os.Mkdir(target, 0755)
+	writeFiles(t, source, map[string]interface{}{
+		"instances.json": `{
+			"images": [{"id": 1, "file_name": "images/a.png", "width": 20, "height": 20}, {"id": 2, "file_name": "images/b.png"}],
+			"categories": [{"id": 1, "name": "car"}],
+			"annotations": [{"id": 1, "image_id": 1, "category_id": 1, "bbox": [1, 2, 3, 4], "area": 12.0, "iscrowd": 1, "segmentation": {"counts": "ab1", "size": [20, 20]}}, {"id": 2, "image_id": 2, "category_id": 1, "bbox": [1, 2, 30, 4]}]
+		}`,
+		"images/a.png": image.Point{X: 20, Y: 20},
+		"images/b.png": image.Point{X: 20, Y: 20},
+	})
+
+	report, err := ImportCOCO(path.Join(source, "instances.json"), Options{TargetPath: target})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if want := []string{"images/a.png"}; !reflect.DeepEqual(report.Imported, want) {
+		t.Errorf("imported = %v, want %v", report.Imported, want)
+	}
+	checkProblems(t, report, map[string]error{"images/b.png": processor.OutOfBoundsError})
+
+	a, err := processor.ReadAnnotation(target, "images/a.png")
+	if err != nil {
+		t.Fatalf("error reading imported annotation: %v", err)
+	}
+	if want := []processor.Object{obj("car", 1, 2, 4, 6)}; !reflect.DeepEqual(a.Objects, want) {
+		t.Errorf("imported objects = %v, want %v", a.Objects, want)
+	}
+
+	if _, err := ImportCOCO(path.Join(source, "missing.json"), Options{TargetPath: target}); err == nil {
+		t.Errorf("missing document should fail, got %v", err)
+	}
+}
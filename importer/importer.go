@@ -0,0 +1,265 @@
+// Package importer places images of existing datasets (Pascal VOC, COCO, YOLO, LabelMe) into osp directories
+// together with generated Pascal VOC annotations
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/porfirion/osp/processor"
+	"github.com/porfirion/osp/safepath"
+)
+
+var MissingImageError = errors.New("image not found")
+var UnknownClassError = errors.New("unknown class")
+var MalformedAnnotationError = errors.New("malformed annotation")
+var DuplicateImageError = errors.New("image is annotated more than once")
+var AlreadyExistsError = errors.New("image already exists in target path")
+
+// Options of import
+type Options struct {
+	// ImagesPath is where images of source dataset are. Filenames in annotations are relative to it.
+	// Directory of annotations is used if it's empty.
+	ImagesPath string
+	// TargetPath is labeled path. Annotations imported into unlabeled path become pre-annotations of images.
+	TargetPath string
+
+	// Labels are allowed labels (any label is allowed if it's empty). Labels are matched case insensitive.
+	Labels []processor.Label
+	// MinBoxSize and ClampBoxes are applied to boxes like in processor
+	MinBoxSize int
+	ClampBoxes bool
+	// Extensions of images (processor.DefaultExtensions if empty)
+	Extensions []string
+	// Writers are formats of annotations written next to imported images, like processor writes them.
+	// Pascal VOC is always written, so it's the only format if Writers is empty.
+	Writers []processor.AnnotationWriter
+
+	// DryRun only checks dataset and reports problems, nothing is written
+	DryRun bool
+
+	// labelMeSources are LabelMe documents of images that are written next to them
+	labelMeSources map[string][]byte
+}
+
+// Problem is mismatch found in source dataset. Image with problem isn't imported.
+type Problem struct {
+	// Filename of image or annotation file if image is unknown
+	Filename string
+	Err      error
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %v", p.Filename, p.Err)
+}
+
+// Report is result of import
+type Report struct {
+	// Imported are filenames of imported images (or images that would be imported in dry run)
+	Imported []string
+	Problems []Problem
+}
+
+func (r *Report) addProblem(filename string, err error) {
+	r.Problems = append(r.Problems, Problem{Filename: filename, Err: err})
+}
+
+// checkTarget checks that target path is existing directory
+func checkTarget(options Options) error {
+	info, err := os.Stat(options.TargetPath)
+	if err != nil {
+		return fmt.Errorf("error checking target path: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("target path %s is not a directory", options.TargetPath)
+	}
+
+	return nil
+}
+
+// importAnnotations copies images of annotations from options.ImagesPath into options.TargetPath and writes
+// annotations next to them. Annotation is written after image, so crash leaves only image without annotation,
+// which is moved back to unlabeled path by processor recovery.
+func importAnnotations(annotations []*processor.Annotation, options Options, report *Report) {
+	processorOptions := processor.Options{
+		Labels:     options.Labels,
+		MinBoxSize: options.MinBoxSize,
+		ClampBoxes: options.ClampBoxes,
+	}
+
+	if !options.DryRun {
+		if err := processor.WriteYOLOClasses(options.TargetPath, options.Writers); err != nil {
+			report.addProblem(processor.YOLOClassesFilename, err)
+			return
+		}
+	}
+
+	seen := make(map[string]bool, len(annotations))
+
+	for _, a := range annotations {
+		if seen[a.Filename] {
+			report.addProblem(a.Filename, DuplicateImageError)
+			continue
+		}
+		seen[a.Filename] = true
+
+		if err := importAnnotation(a, options, processorOptions); err != nil {
+			report.addProblem(a.Filename, err)
+			continue
+		}
+
+		report.Imported = append(report.Imported, a.Filename)
+	}
+}
+
+func importAnnotation(a *processor.Annotation, options Options, processorOptions processor.Options) error {
+	srcPath, err := safepath.Resolve(options.ImagesPath, a.Filename)
+	if err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(srcPath); err != nil || info.IsDir() {
+		return MissingImageError
+	}
+
+	if !processor.HasImageExtension(a.Filename, processor.ImageExtensions(options.Extensions)) {
+		return processor.UnsupportedImageError
+	}
+
+	checked, err := processor.CheckAnnotation(srcPath, a, processorOptions)
+	if err != nil {
+		return err
+	}
+
+	dstPath, err := safepath.Resolve(options.TargetPath, a.Filename)
+	if err != nil {
+		return err
+	}
+
+	xmlPath, err := safepath.Resolve(options.TargetPath, processor.AnnotationFilename(a.Filename))
+	if err != nil {
+		return err
+	}
+
+	if exists(dstPath) || exists(xmlPath) {
+		return AlreadyExistsError
+	}
+
+	source, hasLabelMe := options.labelMeSources[a.Filename]
+	jsonPath, err := labelMePath(options, a.Filename)
+	if err != nil {
+		return err
+	}
+	if hasLabelMe && exists(jsonPath) {
+		return AlreadyExistsError
+	}
+
+	extraPaths, err := extraAnnotationPaths(options, a.Filename)
+	if err != nil {
+		return err
+	}
+	for _, extraPath := range extraPaths {
+		if exists(extraPath) {
+			return AlreadyExistsError
+		}
+	}
+
+	if options.DryRun {
+		return nil
+	}
+
+	if err := copyImage(srcPath, dstPath); err != nil {
+		return err
+	}
+
+	removeAll := func() {
+		_ = os.Remove(dstPath)
+		if hasLabelMe {
+			_ = os.Remove(jsonPath)
+		}
+		for _, extraPath := range extraPaths {
+			_ = os.Remove(extraPath)
+		}
+	}
+
+	if hasLabelMe {
+		if err := writeLabelMe(jsonPath, checked, source); err != nil {
+			removeAll()
+			return err
+		}
+	}
+
+	// LabelMe written from source above is updated by its writer, so shapes other than boxes are kept
+	if err := processor.WriteExtraAnnotations(options.TargetPath, checked, options.Writers); err != nil {
+		removeAll()
+		return err
+	}
+
+	if err := processor.WriteAnnotation(options.TargetPath, checked); err != nil {
+		removeAll()
+		return err
+	}
+
+	return nil
+}
+
+// extraAnnotationPaths returns locations of annotations of image in target path written by options.Writers
+// in formats other than Pascal VOC
+func extraAnnotationPaths(options Options, filename string) ([]string, error) {
+	writers := processor.ExtraWriters(options.Writers)
+	paths := make([]string, 0, len(writers))
+	for _, w := range writers {
+		filePath, err := processor.ExtraAnnotationPath(options.TargetPath, w, filename)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, filePath)
+	}
+
+	return paths, nil
+}
+
+// copyImage copies image into target path creating sub directories. Existing file is never replaced.
+func copyImage(srcPath, dstPath string) (err error) {
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("error opening image: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return AlreadyExistsError
+	} else if err != nil {
+		return fmt.Errorf("error creating image: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			_ = dst.Close()
+			_ = os.Remove(dstPath)
+		}
+	}()
+
+	if _, err = io.Copy(dst, src); err != nil {
+		return fmt.Errorf("error copying image: %w", err)
+	}
+
+	if err = dst.Close(); err != nil {
+		return fmt.Errorf("error flushing image: %w", err)
+	}
+
+	return nil
+}
+
+func exists(filePath string) bool {
+	_, err := os.Stat(filePath)
+	return err == nil
+}
@@ -0,0 +1,226 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/porfirion/osp/processor"
+)
+
+const testXML = `<?xml version="1.0"?>
+<annotation>
+    <filename>%s</filename>
+    <size><width>%d</width><height>20</height><depth>3</depth></size>
+    <object><name>%s</name><bndbox><xmin>1</xmin><ymin>2</ymin><xmax>5</xmax><ymax>6</ymax></bndbox></object>
+</annotation>`
+
+func obj(label string, left, top, right, bottom int) processor.Object {
+	return processor.Object{Label: label, Left: left, Top: top, Right: right, Bottom: bottom}
+}
+
+// writeFiles creates files in dir, values of kind image.Point are written as png images of that size
+func writeFiles(t *testing.T, dir string, files map[string]interface{}) {
+	for name, content := range files {
+		filePath := path.Join(dir, name)
+		if err := os.MkdirAll(path.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		switch c := content.(type) {
+		case image.Point:
+			file, err := os.Create(filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = png.Encode(file, image.NewRGBA(image.Rect(0, 0, c.X, c.Y)))
+			_ = file.Close()
+		case string:
+			if err := ioutil.WriteFile(filePath, []byte(c), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// problems returns errors of report problems by filename
+func problems(report *Report) map[string]error {
+	res := make(map[string]error, len(report.Problems))
+	for _, p := range report.Problems {
+		res[p.Filename] = p.Err
+	}
+	return res
+}
+
+// checkProblems compares problems of report with expected ones (nil error matches any problem)
+func checkProblems(t *testing.T, report *Report, want map[string]error) {
+	t.Helper()
+
+	got := problems(report)
+	if len(got) != len(want) {
+		t.Errorf("problems = %v, want %d of them", report.Problems, len(want))
+	}
+	for name, err := range want {
+		if _, ok := got[name]; !ok {
+			t.Errorf("no problem reported for %s", name)
+		} else if err != nil && !errors.Is(got[name], err) {
+			t.Errorf("problem of %s = %v, want %v", name, got[name], err)
+		}
+	}
+}
+
+func TestImportVOC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	source, target := path.Join(dir, "source"), path.Join(dir, "labeled")
+	writeFiles(t, source, map[string]interface{}{
+		"a.xml":         fmt.Sprintf(testXML, "a.png", 20, "car"),
+		"a.png":         image.Point{X: 20, Y: 20},
+		"cam/b.xml":     fmt.Sprintf(testXML, "b.png", 20, "Person"),
+		"cam/b.png":     image.Point{X: 20, Y: 20},
+		"missing.xml":   fmt.Sprintf(testXML, "missing.png", 20, "car"),
+		"unknown.xml":   fmt.Sprintf(testXML, "unknown.png", 20, "tent"),
+		"unknown.png":   image.Point{X: 20, Y: 20},
+		"mismatch.xml":  fmt.Sprintf(testXML, "mismatch.png", 30, "car"),
+		"mismatch.png":  image.Point{X: 20, Y: 20},
+		"broken.xml":    "<annotation>",
+		"existing.xml":  fmt.Sprintf(testXML, "existing.png", 20, "car"),
+		"existing.png":  image.Point{X: 20, Y: 20},
+		"not-image.xml": fmt.Sprintf(testXML, "not-image.txt", 20, "car"),
+		"not-image.txt": "text",
+	})
+	writeFiles(t, target, map[string]interface{}{
+		"existing.png": image.Point{X: 20, Y: 20},
+	})
+
+	options := Options{
+		TargetPath: target,
+		Labels:     []processor.Label{{Name: "car"}, {Name: "person"}},
+		DryRun:     true,
+	}
+
+	report, err := ImportVOC(source, options)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	want := []string{"a.png", "cam/b.png"}
+	if !reflect.DeepEqual(report.Imported, want) {
+		t.Errorf("imported = %v, want %v", report.Imported, want)
+	}
+	checkProblems(t, report, map[string]error{
+		"broken.xml":    MalformedAnnotationError,
+		"existing.png":  AlreadyExistsError,
+		"mismatch.png":  processor.SizeMismatchError,
+		"missing.png":   MissingImageError,
+		"not-image.txt": processor.UnsupportedImageError,
+		"unknown.png":   nil,
+	})
+	var unknownLabel *processor.UnknownLabelError
+	if p := problems(report)["unknown.png"]; !errors.As(p, &unknownLabel) {
+		t.Errorf("problem of unknown.png = %v, want UnknownLabelError", p)
+	}
+
+	if files, _ := processor.ListFiles(target); len(files) != 1 {
+		t.Errorf("dry run shouldn't write anything, got %v", files)
+	}
+
+	options.DryRun = false
+	if report, err = ImportVOC(source, options); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(report.Imported, want) {
+		t.Errorf("imported = %v, want %v", report.Imported, want)
+	}
+
+	files, _ := processor.ListFiles(target)
+	sort.Strings(files)
+	if wantFiles := []string{"a.png", "a.xml", "cam/b.png", "cam/b.xml", "existing.png"}; !reflect.DeepEqual(files, wantFiles) {
+		t.Errorf("target files = %v, want %v", files, wantFiles)
+	}
+
+	a, err := processor.ReadAnnotation(target, "cam/b.png")
+	if err != nil {
+		t.Fatalf("error reading imported annotation: %v", err)
+	}
+	if wantObjects := []processor.Object{obj("person", 1, 2, 5, 6)}; !reflect.DeepEqual(a.Objects, wantObjects) || a.Width != 20 || a.Height != 20 {
+		t.Errorf("imported annotation = %v, want %v of 20x20", a, wantObjects)
+	}
+
+	// imported images are not imported again
+	if report, err = ImportVOC(source, options); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(report.Imported) != 0 || !errors.Is(problems(report)["a.png"], AlreadyExistsError) {
+		t.Errorf("second import = %v, want nothing imported", report)
+	}
+}
+
+func TestImportVOC_writers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	source, target := path.Join(dir, "source"), path.Join(dir, "labeled")
+	writeFiles(t, source, map[string]interface{}{
+		"a.xml":     fmt.Sprintf(testXML, "a.png", 20, "car"),
+		"a.png":     image.Point{X: 20, Y: 20},
+		"empty.xml": `<annotation><filename>empty.png</filename><object><name>car</name><bndbox><xmin>3</xmin><ymin>3</ymin><xmax>3</xmax><ymax>7</ymax></bndbox></object></annotation>`,
+		"empty.png": image.Point{X: 20, Y: 20},
+	})
+	writeFiles(t, target, map[string]interface{}{
+		"existing.txt": "0 0.5 0.5 0.1 0.1",
+	})
+	writeFiles(t, source, map[string]interface{}{
+		"existing.xml": fmt.Sprintf(testXML, "existing.png", 20, "car"),
+		"existing.png": image.Point{X: 20, Y: 20},
+	})
+
+	labels := []processor.Label{{Name: "car"}}
+	writers, err := processor.NewAnnotationWriters([]string{processor.FormatVOC, processor.FormatYOLO, processor.FormatCOCO}, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// MinBoxSize isn't set, zero width box is rejected like in processor
+	report, err := ImportVOC(source, Options{TargetPath: target, Labels: labels, Writers: writers})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if want := []string{"a.png"}; !reflect.DeepEqual(report.Imported, want) {
+		t.Errorf("imported = %v, want %v", report.Imported, want)
+	}
+	checkProblems(t, report, map[string]error{
+		"empty.png":    processor.BoxTooSmallError,
+		"existing.png": AlreadyExistsError,
+	})
+
+	files, _ := processor.ListFiles(target)
+	sort.Strings(files)
+	wantFiles := []string{"a.coco.json", "a.png", "a.txt", "a.xml", processor.YOLOClassesFilename, "existing.txt"}
+	if !reflect.DeepEqual(files, wantFiles) {
+		t.Errorf("target files = %v, want %v", files, wantFiles)
+	}
+
+	yolo, err := ioutil.ReadFile(path.Join(target, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "0 0.150000 0.200000 0.200000 0.200000\n"; string(yolo) != want {
+		t.Errorf("yolo annotation = %q, want %q", yolo, want)
+	}
+}
@@ -0,0 +1,101 @@
+package importer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/porfirion/osp/processor"
+	"github.com/porfirion/osp/safepath"
+)
+
+// readLabelMe parses all LabelMe json files from annotationsPath including sub directories.
+// Image of annotation is looked for in the same sub directory of images path. Content of files is returned by image
+// filename, so shapes other than rectangles can be kept.
+func readLabelMe(annotationsPath string, report *Report) ([]*processor.Annotation, map[string][]byte, error) {
+	files, err := processor.ListFiles(annotationsPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading annotations path: %w", err)
+	}
+
+	annotations := make([]*processor.Annotation, 0, len(files))
+	sources := make(map[string][]byte, len(files))
+	for _, name := range files {
+		if strings.ToLower(path.Ext(name)) != ".json" {
+			continue
+		}
+
+		jsonPath := filepath.Join(annotationsPath, filepath.FromSlash(name))
+		a, err := processor.ReadLabelMeFile(jsonPath)
+		if err != nil {
+			report.addProblem(name, fmt.Errorf("%w: %v", MalformedAnnotationError, err))
+			continue
+		}
+
+		if a.Filename == "" {
+			report.addProblem(name, fmt.Errorf("%w: imagePath is empty", MalformedAnnotationError))
+			continue
+		}
+
+		// imagePath is relative to json file, like "../images/1.jpg"
+		a.Filename = path.Join(path.Dir(name), path.Base(filepath.ToSlash(a.Filename)))
+
+		data, err := ioutil.ReadFile(jsonPath)
+		if err != nil {
+			report.addProblem(name, err)
+			continue
+		}
+
+		annotations = append(annotations, a)
+		sources[a.Filename] = data
+	}
+
+	return annotations, sources, nil
+}
+
+// ImportLabelMe imports LabelMe json files from annotationsPath. LabelMe file is placed next to imported image
+// too, so polygons and other shapes osp doesn't edit aren't lost.
+func ImportLabelMe(annotationsPath string, options Options) (*Report, error) {
+	if options.ImagesPath == "" {
+		options.ImagesPath = annotationsPath
+	}
+
+	if err := checkTarget(options); err != nil {
+		return nil, err
+	}
+
+	report := &Report{}
+
+	annotations, sources, err := readLabelMe(annotationsPath, report)
+	if err != nil {
+		return nil, err
+	}
+
+	options.labelMeSources = sources
+	importAnnotations(annotations, options, report)
+
+	return report, nil
+}
+
+// writeLabelMe writes LabelMe file of imported image: checked rectangles replace rectangles of source document
+func writeLabelMe(jsonPath string, a *processor.Annotation, source []byte) error {
+	data, err := processor.LabelMeWriter{}.Update(source, a)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(jsonPath, data, 0644); err != nil {
+		_ = os.Remove(jsonPath)
+		return fmt.Errorf("error writing labelme file: %w", err)
+	}
+
+	return nil
+}
+
+// labelMePath returns location of LabelMe file of image in target path
+func labelMePath(options Options, filename string) (string, error) {
+	return safepath.Resolve(options.TargetPath, processor.LabelMeWriter{}.Filename(filename))
+}
@@ -0,0 +1,165 @@
+package importer
+
+import (
+	"context"
+	"image"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/porfirion/osp/processor"
+)
+
+const testLabelMe = `{
+  "version": "5.2.1",
+  "flags": {},
+  "shapes": [
+    {"label": "car", "points": [[5, 6], [1.4, 2]], "group_id": null, "shape_type": "rectangle", "flags": {}},
+    {"label": "road", "points": [[0, 0], [10, 0], [10, 10]], "group_id": 1, "shape_type": "polygon", "flags": {}}
+  ],
+  "imagePath": "%s",
+  "imageData": null,
+  "imageHeight": 20,
+  "imageWidth": 20
+}`
+
+func TestImportLabelMe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	annotations, images, target := path.Join(dir, "annotations"), path.Join(dir, "images"), path.Join(dir, "labeled")
+	_ = os.Mkdir(target, 0755)
+	writeFiles(t, annotations, map[string]interface{}{
+		"a.json":       strings.Replace(testLabelMe, "%s", "../images/a.png", 1),
+		"cam/c.json":   strings.Replace(testLabelMe, "%s", "c.png", 1),
+		"missing.json": strings.Replace(testLabelMe, "%s", "missing.png", 1),
+		"other.json":   `{"images": []}`,
+	})
+	writeFiles(t, images, map[string]interface{}{
+		"a.png":     image.Point{X: 20, Y: 20},
+		"cam/c.png": image.Point{X: 20, Y: 20},
+	})
+
+	report, err := ImportLabelMe(annotations, Options{ImagesPath: images, TargetPath: target})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if want := []string{"a.png", "cam/c.png"}; !reflect.DeepEqual(report.Imported, want) {
+		t.Errorf("imported = %v, want %v", report.Imported, want)
+	}
+	checkProblems(t, report, map[string]error{
+		"missing.png": MissingImageError,
+		"other.json":  MalformedAnnotationError,
+	})
+
+	a, err := processor.ReadAnnotation(target, "cam/c.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []processor.Object{obj("car", 1, 2, 5, 6)}; !reflect.DeepEqual(a.Objects, want) {
+		t.Errorf("objects = %v, want %v", a.Objects, want)
+	}
+
+	// LabelMe file is placed next to image with all shapes
+	data, err := ioutil.ReadFile(path.Join(target, "cam", "c.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"imagePath": "c.png"`, `"shape_type": "polygon"`, `"version": "5.2.1"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("labelme file %s doesn't contain %s", data, want)
+		}
+	}
+
+	// the same images can't be imported again
+	report, err = ImportLabelMe(annotations, Options{ImagesPath: images, TargetPath: target, DryRun: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(report.Imported) != 0 {
+		t.Errorf("imported = %v, want nothing", report.Imported)
+	}
+}
+
+// Test_labelMe_roundTrip checks that polygon of imported LabelMe file survives unlabel, relabel and undo in processor
+func Test_labelMe_roundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	annotations, unlabeled, labeled := path.Join(dir, "annotations"), path.Join(dir, "unlabeled"), path.Join(dir, "labeled")
+	_ = os.Mkdir(unlabeled, 0755)
+	_ = os.Mkdir(labeled, 0755)
+	writeFiles(t, annotations, map[string]interface{}{
+		"a.json": strings.Replace(testLabelMe, "%s", "a.png", 1),
+		"a.png":  image.Point{X: 20, Y: 20},
+	})
+
+	writers := []processor.AnnotationWriter{processor.VOCWriter{}, processor.LabelMeWriter{}}
+	report, err := ImportLabelMe(annotations, Options{TargetPath: labeled, Writers: writers})
+	if err != nil || len(report.Imported) != 1 {
+		t.Fatalf("import = %v, %v, want a.png imported", report, err)
+	}
+
+	p, err := processor.NewImageProcessor(unlabeled, labeled, processor.Options{Writers: writers})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+	defer p.Close()
+
+	jsonPath := path.Join(labeled, "a.json")
+	checkLabelMe := func(step string, want []processor.Object) {
+		t.Helper()
+
+		data, err := ioutil.ReadFile(jsonPath)
+		if err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		if !strings.Contains(string(data), `"shape_type": "polygon"`) {
+			t.Errorf("%s: polygon was lost: %s", step, data)
+		}
+
+		a, err := processor.ReadLabelMeFile(jsonPath)
+		if err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		if len(a.Objects) != len(want) || (len(want) > 0 && !reflect.DeepEqual(a.Objects, want)) {
+			t.Errorf("%s: rectangles = %v, want %v", step, a.Objects, want)
+		}
+	}
+
+	checkLabelMe("import", []processor.Object{obj("car", 1, 2, 5, 6)})
+
+	if _, err := p.UnlabelImage(context.Background(), "a.png"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	checkLabelMe("unlabel", nil)
+
+	result, err := p.ProcessImage(context.Background(), processor.ImageRequest{Filename: "a.png", Width: 20, Height: 20, Objects: []processor.Object{obj("car", 2, 2, 8, 8)}})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	checkLabelMe("label", []processor.Object{obj("car", 2, 2, 8, 8)})
+
+	labelResult, ok := result.(*processor.LabelResult)
+	if !ok {
+		t.Fatalf("unexpected result %T", result)
+	}
+	if _, err := p.Undo(context.Background(), labelResult.Revision); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	checkLabelMe("undo", nil)
+
+	if _, err := os.Stat(path.Join(unlabeled, "a.png")); err != nil {
+		t.Errorf("image should be unlabeled after undo: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package importer
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/porfirion/osp/processor"
+)
+
+// readVOC parses all Pascal VOC xml files from annotationsPath including sub directories.
+// Image of annotation is looked for in the same sub directory of images path.
+func readVOC(annotationsPath string, report *Report) ([]*processor.Annotation, error) {
+	files, err := processor.ListFiles(annotationsPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading annotations path: %w", err)
+	}
+
+	annotations := make([]*processor.Annotation, 0, len(files))
+	for _, name := range files {
+		if strings.ToLower(path.Ext(name)) != ".xml" {
+			continue
+		}
+
+		a, err := processor.ReadAnnotation(annotationsPath, name)
+		if err != nil {
+			report.addProblem(name, fmt.Errorf("%w: %v", MalformedAnnotationError, err))
+			continue
+		}
+
+		if a.Filename == "" {
+			report.addProblem(name, fmt.Errorf("%w: filename is empty", MalformedAnnotationError))
+			continue
+		}
+
+		annotations = append(annotations, a)
+	}
+
+	return annotations, nil
+}
+
+// ImportVOC imports Pascal VOC xml files from annotationsPath
+func ImportVOC(annotationsPath string, options Options) (*Report, error) {
+	if options.ImagesPath == "" {
+		options.ImagesPath = annotationsPath
+	}
+
+	if err := checkTarget(options); err != nil {
+		return nil, err
+	}
+
+	report := &Report{}
+
+	annotations, err := readVOC(annotationsPath, report)
+	if err != nil {
+		return nil, err
+	}
+
+	importAnnotations(annotations, options, report)
+
+	return report, nil
+}
@@ -0,0 +1,184 @@
+package importer
+
+import (
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/porfirion/osp/processor"
+)
+
+// readYOLOClasses reads class names from classes.txt of labels path, line number is class id
+func readYOLOClasses(labelsPath string) ([]string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(labelsPath, processor.YOLOClassesFilename))
+	if err != nil {
+		return nil, fmt.Errorf("error reading classes: %w", err)
+	}
+
+	classes := strings.Split(strings.TrimRight(string(data), "\r\n"), "\n")
+	for ind := range classes {
+		classes[ind] = strings.TrimSpace(classes[ind])
+	}
+
+	return classes, nil
+}
+
+// parseYOLOLabels converts lines like "class x_center y_center width height" with coordinates normalized by image
+// size into objects
+func parseYOLOLabels(data []byte, classes []string, width, height int) ([]processor.Object, error) {
+	objects := make([]processor.Object, 0)
+
+	for ind, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("%w: line %d has %d fields", MalformedAnnotationError, ind+1, len(fields))
+		}
+
+		class, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: bad class %q", MalformedAnnotationError, ind+1, fields[0])
+		}
+		if class < 0 || class >= len(classes) {
+			return nil, fmt.Errorf("%w: class id %d", UnknownClassError, class)
+		}
+
+		var box [4]float64
+		for i := range box {
+			if box[i], err = strconv.ParseFloat(fields[i+1], 64); err != nil {
+				return nil, fmt.Errorf("%w: line %d: bad coordinate %q", MalformedAnnotationError, ind+1, fields[i+1])
+			}
+		}
+
+		w, h := float64(width), float64(height)
+		x, y, bw, bh := box[0]*w, box[1]*h, box[2]*w, box[3]*h
+		objects = append(objects, processor.Object{
+			Label:  classes[class],
+			Left:   int(math.Round(x - bw/2)),
+			Top:    int(math.Round(y - bh/2)),
+			Right:  int(math.Round(x + bw/2)),
+			Bottom: int(math.Round(y + bh/2)),
+		})
+	}
+
+	return objects, nil
+}
+
+// imageSize decodes image header
+func imageSize(imagePath string) (width, height int, err error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error opening image: %w", err)
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w (%v)", processor.UnsupportedImageError, err)
+	}
+
+	return config.Width, config.Height, nil
+}
+
+// readYOLO reads labels of images from images path. Label file of image has the same path relative to labelsPath
+// and extension "txt". Images without label file are negative examples.
+func readYOLO(labelsPath string, options Options, report *Report) ([]*processor.Annotation, error) {
+	classes, err := readYOLOClasses(labelsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	labelFiles, err := processor.ListFiles(labelsPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading labels path: %w", err)
+	}
+
+	imageFiles, err := processor.ListFiles(options.ImagesPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading images path: %w", err)
+	}
+
+	// label files without images are reported
+	orphans := make(map[string]bool)
+	for _, name := range labelFiles {
+		if strings.ToLower(path.Ext(name)) == ".txt" && name != processor.YOLOClassesFilename {
+			orphans[name] = true
+		}
+	}
+
+	extensions := processor.ImageExtensions(options.Extensions)
+	annotations := make([]*processor.Annotation, 0, len(imageFiles))
+	for _, name := range imageFiles {
+		if !processor.HasImageExtension(name, extensions) {
+			continue
+		}
+
+		labelName := strings.TrimSuffix(name, path.Ext(name)) + ".txt"
+		delete(orphans, labelName)
+
+		// labels of such image can't be told from list of classes
+		if processor.YOLOClassesConflict(name) {
+			report.addProblem(name, processor.YOLOClassesConflictError)
+			continue
+		}
+
+		width, height, err := imageSize(filepath.Join(options.ImagesPath, filepath.FromSlash(name)))
+		if err != nil {
+			report.addProblem(name, err)
+			continue
+		}
+
+		a := &processor.Annotation{Filename: name, Width: width, Height: height, Objects: make([]processor.Object, 0)}
+
+		data, err := ioutil.ReadFile(filepath.Join(labelsPath, filepath.FromSlash(labelName)))
+		if err != nil && !os.IsNotExist(err) {
+			report.addProblem(name, fmt.Errorf("error reading labels: %w", err))
+			continue
+		} else if err == nil {
+			if a.Objects, err = parseYOLOLabels(data, classes, width, height); err != nil {
+				report.addProblem(name, err)
+				continue
+			}
+		}
+
+		annotations = append(annotations, a)
+	}
+
+	for _, name := range labelFiles {
+		if orphans[name] {
+			report.addProblem(name, MissingImageError)
+		}
+	}
+
+	return annotations, nil
+}
+
+// ImportYOLO imports YOLO dataset from labelsPath. Images are looked for in labelsPath by default.
+func ImportYOLO(labelsPath string, options Options) (*Report, error) {
+	if options.ImagesPath == "" {
+		options.ImagesPath = labelsPath
+	}
+
+	if err := checkTarget(options); err != nil {
+		return nil, err
+	}
+
+	report := &Report{}
+
+	annotations, err := readYOLO(labelsPath, options, report)
+	if err != nil {
+		return nil, err
+	}
+
+	importAnnotations(annotations, options, report)
+
+	return report, nil
+}
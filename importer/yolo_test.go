@@ -0,0 +1,104 @@
+package importer
+
+import (
+	"errors"
+	"image"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/porfirion/osp/processor"
+)
+
+func Test_parseYOLOLabels(t *testing.T) {
+	classes := []string{"cat", "dog"}
+
+	tests := []struct {
+		name string
+		data string
+		want []processor.Object
+		err  error
+	}{
+		{"ok", "1 0.200000 0.400000 0.200000 0.400000\n0 0.5 0.5 1 1\n", []processor.Object{obj("dog", 10, 10, 30, 30), obj("cat", 0, 0, 100, 50)}, nil},
+		{"empty", "\n", []processor.Object{}, nil},
+		{"confidence column", "0 0.5 0.5 1 1 0.93", []processor.Object{obj("cat", 0, 0, 100, 50)}, nil},
+		{"unknown class", "2 0.5 0.5 1 1", nil, UnknownClassError},
+		{"short line", "0 0.5 0.5 1", nil, MalformedAnnotationError},
+		{"bad class", "cat 0.5 0.5 1 1", nil, MalformedAnnotationError},
+		{"bad coordinate", "0 0.5 half 1 1", nil, MalformedAnnotationError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYOLOLabels([]byte(tt.data), classes, 100, 50)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("that should be error %v but got %v", tt.err, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseYOLOLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportYOLO(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	labels, images, target := path.Join(dir, "labels"), path.Join(dir, "images"), path.Join(dir, "labeled")
+	_ = os.Mkdir(target, 0755)
+	writeFiles(t, labels, map[string]interface{}{
+		"classes.txt":   "car\nperson\n",
+		"a.txt":         "1 0.5 0.5 0.5 0.5\n",
+		"cam/c.txt":     "0 0.25 0.25 0.5 0.5\n",
+		"orphan.txt":    "0 0.5 0.5 1 1\n",
+		"unknown.txt":   "5 0.5 0.5 1 1\n",
+		"unrelated.csv": "1,2,3",
+	})
+	writeFiles(t, images, map[string]interface{}{
+		"a.png":        image.Point{X: 20, Y: 20},
+		"negative.png": image.Point{X: 20, Y: 20},
+		"cam/c.png":    image.Point{X: 20, Y: 20},
+		"unknown.png":  image.Point{X: 20, Y: 20},
+		"broken.png":   "not an image",
+		"Classes.png":  image.Point{X: 20, Y: 20},
+	})
+
+	report, err := ImportYOLO(labels, Options{ImagesPath: images, TargetPath: target})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if want := []string{"a.png", "cam/c.png", "negative.png"}; !reflect.DeepEqual(report.Imported, want) {
+		t.Errorf("imported = %v, want %v", report.Imported, want)
+	}
+	checkProblems(t, report, map[string]error{
+		"broken.png":  processor.UnsupportedImageError,
+		"Classes.png": processor.YOLOClassesConflictError,
+		"orphan.txt":  MissingImageError,
+		"unknown.png": UnknownClassError,
+	})
+
+	wantObjects := map[string][]processor.Object{
+		"a.png":        {obj("person", 5, 5, 15, 15)},
+		"cam/c.png":    {obj("car", 0, 0, 10, 10)},
+		"negative.png": {},
+	}
+	for name, want := range wantObjects {
+		a, err := processor.ReadAnnotation(target, name)
+		if err != nil {
+			t.Fatalf("error reading imported annotation: %v", err)
+		}
+		if !reflect.DeepEqual(a.Objects, want) {
+			t.Errorf("objects of %s = %v, want %v", name, a.Objects, want)
+		}
+	}
+
+	if _, err := ImportYOLO(images, Options{TargetPath: target}); err == nil {
+		t.Errorf("import without classes.txt should fail")
+	}
+}
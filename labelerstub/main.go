@@ -0,0 +1,92 @@
+// Command labelerstub is labeler for testing pre-labeling without real detector. It proposes single box in the middle
+// of each image. By default it answers one request read from stdin (for LabelerCommand), with -http it serves
+// requests on address (for LabelerURL):
+//
+//	go build -o osp-labeler-stub ./labelerstub
+//	osp-labeler-stub -label car < request.json
+//	osp-labeler-stub -http localhost:8081
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/porfirion/osp/processor"
+)
+
+// defaultLabel is used if label isn't set and any label is allowed
+const defaultLabel = "object"
+
+// stub answers requests with box covering middle half of image
+type stub struct {
+	// label of proposed box, the first allowed label is used if it's empty
+	label      string
+	confidence float64
+}
+
+func (s stub) propose(req processor.LabelerRequest) processor.SuggestionsDocument {
+	label := s.label
+	if label == "" && len(req.Labels) > 0 {
+		label = req.Labels[0]
+	} else if label == "" {
+		label = defaultLabel
+	}
+
+	objects := make([]processor.Suggestion, 0, 1)
+	if req.Width >= 4 && req.Height >= 4 {
+		objects = append(objects, processor.Suggestion{
+			Object: processor.Object{
+				Label:  label,
+				Left:   req.Width / 4,
+				Top:    req.Height / 4,
+				Right:  req.Width * 3 / 4,
+				Bottom: req.Height * 3 / 4,
+			},
+			Confidence: s.confidence,
+		})
+	}
+
+	return processor.SuggestionsDocument{Objects: objects}
+}
+
+func (s stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req processor.LabelerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.propose(req)); err != nil {
+		log.Printf("error writing response: %v", err)
+	}
+}
+
+func main() {
+	var s stub
+	flag.StringVar(&s.label, "label", "", "label of proposed box (first allowed label if empty)")
+	flag.Float64Var(&s.confidence, "confidence", 0.5, "confidence of proposed box")
+	addr := flag.String("http", "", "serve requests on address instead of answering single request from stdin")
+	flag.Parse()
+
+	if *addr != "" {
+		log.Fatal(http.ListenAndServe(*addr, s))
+	}
+
+	var req processor.LabelerRequest
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		log.Fatalf("error reading request: %v", err)
+	}
+
+	if err := json.NewEncoder(os.Stdout).Encode(s.propose(req)); err != nil {
+		log.Fatalf("error writing response: %v", err)
+	}
+}
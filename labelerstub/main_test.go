@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/porfirion/osp/processor"
+)
+
+// stubEnv makes test binary act as stub executable, so CommandLabeler can be tested without building it
+const stubEnv = "OSP_LABELER_STUB"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(stubEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+func Test_stub_propose(t *testing.T) {
+	box := processor.Object{Left: 25, Top: 10, Right: 75, Bottom: 30}
+
+	tests := []struct {
+		name  string
+		stub  stub
+		req   processor.LabelerRequest
+		label string
+	}{
+		{"label flag", stub{label: "car"}, processor.LabelerRequest{Width: 100, Height: 40, Labels: []string{"tent"}}, "car"},
+		{"first allowed label", stub{}, processor.LabelerRequest{Width: 100, Height: 40, Labels: []string{"tent"}}, "tent"},
+		{"any label", stub{}, processor.LabelerRequest{Width: 100, Height: 40}, defaultLabel},
+		{"tiny image", stub{}, processor.LabelerRequest{Width: 2, Height: 2}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.stub.propose(tt.req).Objects
+			if tt.label == "" {
+				if len(got) != 0 {
+					t.Errorf("propose() = %v, want nothing", got)
+				}
+				return
+			}
+
+			want := box
+			want.Label = tt.label
+			if len(got) != 1 || got[0].Object != want {
+				t.Errorf("propose() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func Test_labelers(t *testing.T) {
+	server := httptest.NewServer(stub{label: "car", confidence: 0.5})
+	defer server.Close()
+
+	httpLabeler, err := processor.NewHTTPLabeler(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Setenv(stubEnv, "1")
+	defer os.Unsetenv(stubEnv)
+
+	labelers := map[string]processor.Labeler{
+		"http":    httpLabeler,
+		"command": &processor.CommandLabeler{Command: []string{executable, "-label", "car", "-confidence", "0.5"}},
+	}
+
+	want := []processor.Suggestion{{Object: processor.Object{Label: "car", Left: 25, Top: 10, Right: 75, Bottom: 30}, Confidence: 0.5}}
+	for name, labeler := range labelers {
+		t.Run(name, func(t *testing.T) {
+			got, err := labeler.Label(context.Background(), processor.LabelerRequest{Filename: "1.jpg", Width: 100, Height: 40})
+			if err != nil {
+				t.Fatalf("Label() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Label() = %v, want %v", got, want)
+			}
+		})
+	}
+}
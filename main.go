@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/BurntSushi/toml"
 
 	"github.com/porfirion/osp/front"
+	"github.com/porfirion/osp/imageindex"
 	"github.com/porfirion/osp/processor"
 )
 
@@ -16,10 +21,60 @@ type ospConfig struct {
 	Port          string
 	UnlabeledPath string
 	LabeledPath   string
+
+	// MinBoxSize is minimal width and height of bounding box in pixels
+	MinBoxSize int
+	// ClampBoxes cuts bounding boxes by image borders instead of rejecting them
+	ClampBoxes bool
+
+	// Labels is the set of allowed labels. Any label is allowed if it's empty
+	Labels []processor.Label
+	// ImageExtensions are extensions of files shown for labeling (processor.DefaultExtensions if empty)
+	ImageExtensions []string
+
+	// timeouts of communication with processor, like "1s" or "500ms"
+	SendTimeout     duration
+	ResponseTimeout duration
+
+	// ConflictPolicy tells what to do if labeled image with the same name exists: fail, overwrite, suffix or merge
+	ConflictPolicy processor.ConflictPolicy
+	// OrientationMode tells how images with EXIF orientation are stored: normalize or rotate
+	OrientationMode processor.OrientationMode
+	// RejectedPath is where rejected images are moved with reason of rejection (rejecting is disabled if it's empty)
+	RejectedPath string
+	// AnnotationFormats are formats written on each save: voc (required), coco, labelme, yolo
+	AnnotationFormats []string
+
+	// LabelerCommand is executable with arguments that proposes boxes for opened image: request is written to it's
+	// stdin as JSON, answer is read from stdout
+	LabelerCommand []string
+	// LabelerURL is http endpoint on localhost that proposes boxes, it's used if LabelerCommand is empty
+	LabelerURL string
+	// LabelerTimeout limits single call of labeler, like "30s"
+	LabelerTimeout duration
+
+	// ThumbnailPath is directory for cached thumbnails of previews (temporary directory is used if it's empty)
+	ThumbnailPath string
+
+	// RescanInterval is how often image directories are reread to notice files changed by others, like "1m"
+	RescanInterval duration
+}
+
+// duration allows to specify time.Duration in config as string
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalText(text []byte) (err error) {
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
 }
 
 var logger *log.Logger = log.New(os.Stdout, "OSP: ", 0)
 
+// shutdownTimeout limits waiting for active requests on shutdown
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	var config ospConfig
 	if _, err := toml.DecodeFile("config.toml", &config); err != nil {
@@ -28,25 +83,138 @@ func main() {
 
 	logger.Printf("config: %v", config)
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			runExport(config, os.Args[2:])
+		case "import":
+			runImport(config, os.Args[2:])
+		default:
+			logger.Fatalf("unknown command %q", os.Args[1])
+		}
+		return
+	}
+
+	serve(config)
+}
+
+// serve starts web server and processor and waits for interruption
+func serve(config ospConfig) {
+	extensions := processor.ImageExtensions(config.ImageExtensions)
+	unlabeledIndex := imageindex.New(config.UnlabeledPath, extensions)
+	labeledIndex := imageindex.New(config.LabeledPath, extensions)
+
+	writers, err := processor.NewAnnotationWriters(config.AnnotationFormats, config.Labels)
+	if err != nil {
+		logger.Fatalf("error creating annotation writers: %v\n", err)
+	}
+
+	labeler, err := newLabeler(config)
+	if err != nil {
+		logger.Fatalf("error creating labeler: %v\n", err)
+	}
+
 	var p processor.Processor
-	p, err := processor.NewImageProcessor(config.UnlabeledPath, config.LabeledPath)
+	p, err = processor.NewImageProcessor(config.UnlabeledPath, config.LabeledPath, processor.Options{
+		MinBoxSize: config.MinBoxSize,
+		ClampBoxes: config.ClampBoxes,
+		Labels:     config.Labels,
+		Extensions: extensions,
+
+		SendTimeout:     config.SendTimeout.Duration,
+		ResponseTimeout: config.ResponseTimeout.Duration,
+
+		ConflictPolicy:  config.ConflictPolicy,
+		OrientationMode: config.OrientationMode,
+		RejectedPath:    config.RejectedPath,
+		Writers:         writers,
+
+		Labeler:        labeler,
+		LabelerTimeout: config.LabelerTimeout.Duration,
+
+		OnMove: func(m processor.Move) {
+			switch {
+			case m.Copied:
+				unlabeledIndex.Add(m.To)
+			case m.Rejected:
+				unlabeledIndex.Remove(m.From)
+			case m.ToLabeled:
+				unlabeledIndex.Remove(m.From)
+				labeledIndex.Add(m.To)
+			default:
+				labeledIndex.Remove(m.From)
+				unlabeledIndex.Add(m.To)
+			}
+		},
+	})
 	if err != nil {
 		logger.Fatalf("error creating ImageProcessor %v\n", err)
 	}
 
-	srv, err := front.NewServer(config.Host, config.Port, config.UnlabeledPath, p)
+	// indexes are filled after processor recovery has finished
+	for _, index := range []*imageindex.Index{unlabeledIndex, labeledIndex} {
+		if err := index.Start(config.RescanInterval.Duration); err != nil {
+			logger.Fatalf("error indexing images: %v\n", err)
+		}
+	}
+
+	thumbnailPath := config.ThumbnailPath
+	if thumbnailPath == "" {
+		thumbnailPath = filepath.Join(os.TempDir(), "osp-thumbnails")
+	}
+
+	srv, err := front.NewServer(config.Host, config.Port, unlabeledIndex, labeledIndex, thumbnailPath, p)
 	if err != nil {
 		logger.Fatalf("error creating server: %v\n", err)
 	}
 
-	srv.Start()
+	if err := srv.Start(); err != nil {
+		logger.Fatalf("error starting server: %v\n", err)
+	}
 
 	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt, os.Kill)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	exitCode := 0
 
 	select {
-	case <-interrupt:
+	case sig := <-interrupt:
+		logger.Printf("received %v, shutting down", sig)
+	case err := <-srv.Errors():
+		logger.Printf("server failed: %v", err)
+		exitCode = 1
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// server goes first: it waits for active requests, which may still wait for processor
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Printf("error shutting down server: %v", err)
+		exitCode = 1
+	}
+
+	if err := p.Close(); err != nil {
+		logger.Printf("error closing processor: %v", err)
+		exitCode = 1
+	}
+
+	unlabeledIndex.Close()
+	labeledIndex.Close()
+
 	logger.Printf("FINISHED")
+
+	os.Exit(exitCode)
+}
+
+// newLabeler creates labeler configured in config (nil if there is none)
+func newLabeler(config ospConfig) (processor.Labeler, error) {
+	switch {
+	case len(config.LabelerCommand) > 0:
+		return &processor.CommandLabeler{Command: config.LabelerCommand}, nil
+	case config.LabelerURL != "":
+		return processor.NewHTTPLabeler(config.LabelerURL)
+	default:
+		return nil, nil
+	}
 }
@@ -0,0 +1,155 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var UnlabeledExistsError = errors.New("unlabeled image with the same name already exists")
+
+// ConflictPolicy tells what to do when labeled image with the same name already exists
+type ConflictPolicy string
+
+const (
+	// ConflictFail rejects command with AlreadyLabeledError
+	ConflictFail ConflictPolicy = "fail"
+	// ConflictOverwrite replaces existing image and annotation
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictSuffix saves image under the first free name like "image_1.jpg"
+	ConflictSuffix ConflictPolicy = "suffix"
+	// ConflictMerge replaces existing image and adds new objects to existing annotation.
+	// Existing objects that don't fit replacing image are dropped
+	ConflictMerge ConflictPolicy = "merge"
+)
+
+// maxSuffix limits search of free name for ConflictSuffix
+const maxSuffix = 10000
+
+// AlreadyLabeledError is returned when labeled image or annotation with the same name already exists
+type AlreadyLabeledError struct {
+	Filename string
+}
+
+func (e *AlreadyLabeledError) Error() string {
+	return fmt.Sprintf("image %q is already labeled", e.Filename)
+}
+
+// LabelResult is returned by ProcessImage, MarkNegative and UpdateImage
+type LabelResult struct {
+	// Filename of labeled image. It differs from requested one if image was renamed because of conflict
+	Filename string
+	// Revision allows to undo the save
+	Revision *Revision
+}
+
+// labelTarget is where labeled image and it's annotation are written
+type labelTarget struct {
+	filename string
+	paths    imagePaths
+
+	// existing annotation which objects should be kept (ConflictMerge)
+	merge *Annotation
+	// content of replaced annotation to restore it on failure
+	previous []byte
+}
+
+func (p *processorImpl) labelTarget(filename string, paths imagePaths) (*labelTarget, error) {
+	target := &labelTarget{filename: filename, paths: paths}
+
+	if !fileExists(paths.labeled) && !fileExists(paths.annotation) {
+		return target, nil
+	}
+
+	switch p.options.ConflictPolicy {
+	case ConflictOverwrite, ConflictMerge:
+		previous, err := ioutil.ReadFile(paths.annotation)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error reading existing annotation: %w", err)
+		}
+		target.previous = previous
+
+		if p.options.ConflictPolicy == ConflictMerge && previous != nil {
+			if target.merge, err = ReadAnnotationFile(paths.annotation); err != nil {
+				return nil, err
+			}
+		}
+
+		return target, nil
+	case ConflictSuffix:
+		ext := filepath.Ext(filename)
+		stem := strings.TrimSuffix(filename, ext)
+
+		for i := 1; i <= maxSuffix; i++ {
+			name := fmt.Sprintf("%s_%d%s", stem, i, ext)
+
+			namePaths, err := p.resolvePaths(name)
+			if err != nil {
+				return nil, err
+			}
+
+			if !fileExists(namePaths.labeled) && !fileExists(namePaths.annotation) {
+				target.filename, target.paths = name, namePaths
+				return target, nil
+			}
+		}
+
+		return nil, fmt.Errorf("%w: no free name found", &AlreadyLabeledError{Filename: filename})
+	default:
+		return nil, &AlreadyLabeledError{Filename: filename}
+	}
+}
+
+// mergeObjects appends objects to existing ones skipping exact duplicates
+func mergeObjects(existing []pascalvocObject, objects []pascalvocObject) []pascalvocObject {
+	res := append([]pascalvocObject{}, existing...)
+
+	for _, o := range objects {
+		duplicate := false
+		for _, e := range existing {
+			if e == o {
+				duplicate = true
+				break
+			}
+		}
+
+		if !duplicate {
+			res = append(res, o)
+		}
+	}
+
+	return res
+}
+
+// validConflictPolicy reports whether policy is known
+func validConflictPolicy(policy ConflictPolicy) bool {
+	switch policy {
+	case ConflictFail, ConflictOverwrite, ConflictSuffix, ConflictMerge:
+		return true
+	}
+
+	return false
+}
+
+// mergedObjects returns objects of replaced annotation checked like new ones against image of width x height,
+// which replaces the annotated one. Objects that don't fit are dropped: image they were drawn on is gone.
+func (p *processorImpl) mergedObjects(target *labelTarget, width, height int) []pascalvocObject {
+	objects := make([]pascalvocObject, 0, len(target.merge.Objects))
+	for _, o := range target.merge.Objects {
+		var err error
+		if o.Label, err = normalizeLabel(o.Label, p.options.Labels); err == nil {
+			o, err = validateObject(o, width, height, p.options.MinBoxSize, p.options.ClampBoxes)
+		}
+		if err != nil {
+			logger.Printf("dropping merged object of %s: %v\n", target.filename, err)
+			continue
+		}
+
+		objects = append(objects, newPascalvocObject(o))
+	}
+
+	return objects
+}
@@ -0,0 +1,199 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func Test_processorImpl_labelImage_conflicts(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	existing := []Object{{"car", 1, 1, 5, 5}}
+	added := []Object{{"bike", 2, 2, 6, 6}}
+
+	tests := []struct {
+		name     string
+		policy   ConflictPolicy
+		filename string
+		objects  []Object
+		err      error
+	}{
+		{"default", "", "", nil, &AlreadyLabeledError{}},
+		{"fail", ConflictFail, "", nil, &AlreadyLabeledError{}},
+		{"overwrite", ConflictOverwrite, "", added, nil},
+		{"suffix", ConflictSuffix, "_2", added, nil},
+		{"merge", ConflictMerge, "", append(append([]Object{}, existing...), added...), nil},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+			ext := path.Ext(inputFilename)
+			stem := inputFilename[:len(inputFilename)-len(ext)]
+
+			p, err := NewImageProcessor(unlabeled, labeled, Options{ConflictPolicy: tst.policy})
+			if err != nil {
+				t.Fatalf("error creating new image processor: %v", err)
+			}
+			defer p.Close()
+
+			// label the image, put another copy into unlabeled and occupy first suffix
+			if _, err := p.ProcessImage(context.Background(), ImageRequest{inputFilename, 0, 0, existing}); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			copyTestImage(t, path.Join(unlabeled, inputFilename))
+			copyTestImage(t, path.Join(labeled, stem+"_1"+ext))
+
+			result, err := p.ProcessImage(context.Background(), ImageRequest{inputFilename, 0, 0, added})
+
+			var alreadyLabeled *AlreadyLabeledError
+			if tst.err != nil {
+				if !errors.As(err, &alreadyLabeled) {
+					t.Fatalf("that should be AlreadyLabeledError but got %v", err)
+				}
+
+				// nothing changed
+				annotation, _ := ReadAnnotation(labeled, inputFilename)
+				if annotation == nil || !reflect.DeepEqual(annotation.Objects, existing) {
+					t.Errorf("existing annotation shouldn't change, got %v", annotation)
+				}
+				if !fileExists(path.Join(unlabeled, inputFilename)) {
+					t.Error("image should stay unlabeled")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			wantFilename := stem + tst.filename + ext
+			if r, ok := result.(*LabelResult); !ok || r.Filename != wantFilename {
+				t.Fatalf("result should be labeled %s, got %v", wantFilename, result)
+			}
+
+			annotation, err := ReadAnnotation(labeled, wantFilename)
+			if err != nil {
+				t.Fatalf("error reading annotation: %v", err)
+			}
+			if annotation.Filename != wantFilename {
+				t.Errorf("annotation filename = %s, want %s", annotation.Filename, wantFilename)
+			}
+			if !reflect.DeepEqual(annotation.Objects, tst.objects) {
+				t.Errorf("objects = %v, want %v", annotation.Objects, tst.objects)
+			}
+		})
+	}
+}
+
+func Test_processorImpl_labelImage_mergeSmaller(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+	p, err := NewImageProcessor(unlabeled, labeled, Options{
+		ConflictPolicy: ConflictMerge,
+		Labels:         []Label{{Name: "car"}, {Name: "bike"}},
+		MinBoxSize:     2,
+	})
+	if err != nil {
+		t.Fatalf("error creating new image processor: %v", err)
+	}
+	defer p.Close()
+
+	if _, err := p.ProcessImage(context.Background(), ImageRequest{inputFilename, 0, 0, []Object{{"car", 1, 1, 3, 3}, {"car", 4, 4, 9, 9}}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// replacing image is smaller than labeled one
+	file, err := os.Create(path.Join(unlabeled, inputFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = png.Encode(file, image.NewRGBA(image.Rect(0, 0, 6, 6)))
+	_ = file.Close()
+
+	if _, err := p.ProcessImage(context.Background(), ImageRequest{inputFilename, 0, 0, []Object{{"bike", 2, 2, 6, 6}}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	annotation, err := ReadAnnotation(labeled, inputFilename)
+	if err != nil {
+		t.Fatalf("error reading annotation: %v", err)
+	}
+	if want := []Object{{"car", 1, 1, 3, 3}, {"bike", 2, 2, 6, 6}}; !reflect.DeepEqual(annotation.Objects, want) {
+		t.Errorf("objects = %v, want %v", annotation.Objects, want)
+	}
+	if annotation.Width != 6 || annotation.Height != 6 {
+		t.Errorf("size = %dx%d, want 6x6", annotation.Width, annotation.Height)
+	}
+}
+
+func Test_processorImpl_unlabelImage_conflict(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+	p, err := NewImageProcessor(unlabeled, labeled, Options{})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+	defer p.Close()
+
+	if _, err := p.ProcessImage(context.Background(), ImageRequest{inputFilename, 0, 0, []Object{{"car", 1, 1, 5, 5}}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	copyTestImage(t, path.Join(unlabeled, inputFilename))
+
+	if _, err := p.UnlabelImage(context.Background(), inputFilename); !errors.Is(err, UnlabeledExistsError) {
+		t.Errorf("that should be error %v but got %v", UnlabeledExistsError, err)
+	}
+
+	if !fileExists(path.Join(labeled, AnnotationFilename(inputFilename))) {
+		t.Error("annotation should be kept")
+	}
+}
+
+func TestNewImageProcessor_unknownConflictPolicy(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, _ := setupTempDir(tempDir)
+
+	if _, err := NewImageProcessor(unlabeled, labeled, Options{ConflictPolicy: "ignore"}); err == nil {
+		t.Error("unknown conflict policy should be rejected")
+	}
+}
+
+// copyTestImage creates image of the same size as setupTempDir does
+func copyTestImage(t *testing.T, filename string) {
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = png.Encode(file, image.NewRGBA(image.Rect(0, 0, testImageSize, testImageSize)))
+	_ = file.Close()
+}
@@ -0,0 +1,94 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+var NotImageError = errors.New("file is not an image")
+
+// DefaultExtensions are extensions of image files processed when no extensions are configured
+var DefaultExtensions = []string{".jpg", ".jpeg", ".png", ".gif"}
+
+// sniffLen is number of bytes used by http.DetectContentType
+const sniffLen = 512
+
+// HasImageExtension reports whether file name has one of allowed extensions (case insensitive)
+func HasImageExtension(name string, extensions []string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return false
+	}
+
+	for _, e := range extensions {
+		if ext == e {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SniffImage checks that file content is really an image of supported format.
+// Returns NotImageError if content isn't an image at all and UnsupportedImageError if it can't be decoded.
+func SniffImage(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("error opening image: %w", err)
+	}
+	defer file.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return fmt.Errorf("error reading image: %w", err)
+	}
+
+	if contentType := http.DetectContentType(head[:n]); !strings.HasPrefix(contentType, "image/") {
+		return fmt.Errorf("%w (detected %s)", NotImageError, contentType)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error reading image: %w", err)
+	}
+
+	if _, _, err := image.DecodeConfig(file); err != nil {
+		return fmt.Errorf("%w (%v)", UnsupportedImageError, err)
+	}
+
+	return nil
+}
+
+// ImageExtensions returns normalized extensions or DefaultExtensions if there are no extensions
+func ImageExtensions(extensions []string) []string {
+	extensions = normalizeExtensions(extensions)
+	if len(extensions) == 0 {
+		return DefaultExtensions
+	}
+
+	return extensions
+}
+
+// normalizeExtensions lowercases extensions and adds leading dot where it's missing
+func normalizeExtensions(extensions []string) []string {
+	res := make([]string, 0, len(extensions))
+
+	for _, e := range extensions {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e == "" {
+			continue
+		}
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		res = append(res, e)
+	}
+
+	return res
+}
@@ -0,0 +1,102 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestHasImageExtension(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"a.jpg", true},
+		{"dir/a.JPEG", true},
+		{"a.png", true},
+		{"a.xml", false},
+		{"Thumbs.db", false},
+		{"jpg", false},
+		{"a.jpg.txt", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasImageExtension(tt.name, DefaultExtensions); got != tt.want {
+				t.Errorf("HasImageExtension() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSniffImage(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	validPNG := path.Join(tempDir, "valid.png")
+	copyTestImage(t, validPNG)
+	valid, _ := ioutil.ReadFile(validPNG)
+
+	tests := []struct {
+		name    string
+		content []byte
+		err     error
+	}{
+		{"valid", valid, nil},
+		{"empty", nil, NotImageError},
+		{"text", []byte("download failed"), NotImageError},
+		// png signature but broken header
+		{"truncated", valid[:20], UnsupportedImageError},
+		// detected as image but there is no decoder for it
+		{"bmp", []byte("BM" + string(make([]byte, 60))), UnsupportedImageError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := path.Join(tempDir, tt.name+".png")
+			if err := ioutil.WriteFile(filePath, tt.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := SniffImage(filePath); !errors.Is(err, tt.err) || (err == nil) != (tt.err == nil) {
+				t.Errorf("SniffImage() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func Test_normalizeExtensions(t *testing.T) {
+	got := normalizeExtensions([]string{"JPG", ".Png", " gif ", ""})
+	want := []string{".jpg", ".png", ".gif"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("normalizeExtensions() = %v, want %v", got, want)
+	}
+}
+
+func Test_processorImpl_processCommand_extensions(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+	p, err := NewImageProcessor(unlabeled, labeled, Options{Extensions: []string{"jpg"}})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+	defer p.Close()
+
+	// png image isn't allowed anymore
+	_, err = p.ProcessImage(context.Background(), ImageRequest{inputFilename, 0, 0, []Object{{"car", 0, 0, 5, 5}}})
+	if !errors.Is(err, UnsupportedImageError) {
+		t.Errorf("that should be error %v but got %v", UnsupportedImageError, err)
+	}
+}
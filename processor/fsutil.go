@@ -0,0 +1,141 @@
+package processor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// tempFilePrefix marks temporary files created by processor. Such files are hidden and are removed on startup
+const tempFilePrefix = ".osp-"
+
+// isTempFile reports whether name is a temporary file of writeFileAtomic
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, tempFilePrefix)
+}
+
+// writeFileAtomic writes data to temporary file in the same directory, syncs it and renames it to filePath.
+// So filePath either keeps it's previous content or gets the new one completely.
+func writeFileAtomic(filePath string, data []byte) (err error) {
+	dir := filepath.Dir(filePath)
+
+	file, err := ioutil.TempFile(dir, tempFilePrefix+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating temp file: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}
+	}()
+
+	written, err := file.Write(data)
+	if err != nil {
+		return fmt.Errorf("error writing to file: %w", err)
+	} else if written < len(data) {
+		return fmt.Errorf("couldn't write all data: written %d instead of %d", written, len(data))
+	}
+
+	if err = file.Sync(); err != nil {
+		return fmt.Errorf("error syncing file: %w", err)
+	}
+
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("error flushing file: %w", err)
+	}
+
+	// ioutil.TempFile creates files readable only by owner
+	if err = os.Chmod(file.Name(), 0644); err != nil {
+		return fmt.Errorf("error changing file mode: %w", err)
+	}
+
+	if err = os.Rename(file.Name(), filePath); err != nil {
+		return fmt.Errorf("error renaming temp file: %w", err)
+	}
+
+	syncDir(dir)
+
+	return nil
+}
+
+// copyFile copies content of src to dst atomically
+func copyFile(src, dst string) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(dst, data)
+}
+
+// syncDir flushes directory entries (renames, removals) to disk. It's best effort: not all systems support it.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+
+	_ = d.Sync()
+	_ = d.Close()
+}
+
+// ListFiles returns sorted paths of all files under root relative to it. Paths are slash separated and are used
+// as image identifiers. Hidden files and directories (including temporary files of processor) are skipped.
+func ListFiles(root string) ([]string, error) {
+	files := make([]string, 0)
+
+	err := filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if filePath == root {
+			return nil
+		}
+
+		if strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, filepath.ToSlash(rel))
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// walk order differs from plain string order ("a/b.jpg" goes before "a.jpg")
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// removeEmptyDirs removes dir and it's parents while they are empty, stopping at root (root itself is kept)
+func removeEmptyDirs(root, dir string) {
+	root = filepath.Clean(root)
+
+	for dir = filepath.Clean(dir); dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			// not empty or can't be removed
+			return
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+
+	// supported image formats. Only decoders of standard library are registered: bmp, tiff and webp are not supported
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+var UnsupportedImageError = errors.New("unsupported or corrupted image")
+var SizeMismatchError = errors.New("reported image size doesn't match actual one")
+
+// imageInfo describes image as it's stored on disk
+type imageInfo struct {
+	Format        string
+	Width, Height int
+	// number of channels
+	Depth int
+}
+
+// readImageInfo decodes image header and returns it's real size and depth
+func readImageInfo(imagePath string) (*imageInfo, error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening image: %w", err)
+	}
+	defer file.Close()
+
+	config, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w (%s: %v)", UnsupportedImageError, imagePath, err)
+	}
+
+	depth := colorModelDepth(config.ColorModel)
+	if format == "png" {
+		// png decoder reports RGBA model for opaque truecolor images, channels are known from header only
+		if channels, err := pngChannels(file); err == nil && channels > 0 {
+			depth = channels
+		}
+	}
+
+	return &imageInfo{
+		Format: format,
+		Width:  config.Width,
+		Height: config.Height,
+		Depth:  depth,
+	}, nil
+}
+
+// pngColorTypeOffset is offset of color type in png file: signature, length and type of IHDR chunk, width, height and
+// bit depth precede it
+const pngColorTypeOffset = 25
+
+// pngChannels returns number of channels by color type from IHDR chunk of png file.
+// Returns 0 for paletted images: their depth depends on transparency of palette.
+func pngChannels(file io.ReaderAt) (int, error) {
+	colorType := make([]byte, 1)
+	if _, err := file.ReadAt(colorType, pngColorTypeOffset); err != nil {
+		return 0, err
+	}
+
+	switch colorType[0] {
+	case 0: // grayscale
+		return 1, nil
+	case 2: // truecolor
+		return 3, nil
+	case 4: // grayscale with alpha
+		return 2, nil
+	case 6: // truecolor with alpha
+		return 4, nil
+	}
+
+	return 0, nil
+}
+
+// colorModelDepth returns number of channels for color model
+func colorModelDepth(m color.Model) int {
+	switch m {
+	case color.GrayModel, color.Gray16Model:
+		return 1
+	case color.RGBAModel, color.RGBA64Model, color.NRGBAModel, color.NRGBA64Model, color.CMYKModel:
+		return 4
+	case color.AlphaModel, color.Alpha16Model:
+		return 1
+	}
+
+	if palette, ok := m.(color.Palette); ok {
+		// paletted images are stored as RGB unless palette has transparent colors
+		for _, c := range palette {
+			if _, _, _, a := c.RGBA(); a != 0xffff {
+				return 4
+			}
+		}
+	}
+
+	// YCbCr and all other models are 3-channel
+	return 3
+}
+
+// checkSize verifies that size reported by client matches real one. Zero size means client doesn't know it.
+func (info *imageInfo) checkSize(width, height int) error {
+	if width == 0 && height == 0 {
+		return nil
+	}
+
+	if width != info.Width || height != info.Height {
+		return fmt.Errorf("%w (reported %dx%d, actual %dx%d)", SizeMismatchError, width, height, info.Width, info.Height)
+	}
+
+	return nil
+}
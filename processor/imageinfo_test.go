@@ -0,0 +1,109 @@
+package processor
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_readImageInfo(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	rect := image.Rect(0, 0, 7, 5)
+
+	tests := []struct {
+		name   string
+		encode func(w io.Writer) error
+		want   imageInfo
+		err    error
+	}{
+		{"gray png", func(w io.Writer) error { return png.Encode(w, image.NewGray(rect)) }, imageInfo{"png", 7, 5, 1}, nil},
+		{"rgba png", func(w io.Writer) error { return png.Encode(w, image.NewRGBA(rect)) }, imageInfo{"png", 7, 5, 4}, nil},
+		{"rgb png", func(w io.Writer) error { return png.Encode(w, opaque(image.NewRGBA(rect))) }, imageInfo{"png", 7, 5, 3}, nil},
+		{"gray alpha png", func(w io.Writer) error { return writeFile(w, grayAlphaPNG) }, imageInfo{"png", 1, 1, 2}, nil},
+		{"paletted png", func(w io.Writer) error { return png.Encode(w, image.NewPaletted(rect, palette.Plan9)) }, imageInfo{"png", 7, 5, 3}, nil},
+		{"jpeg", func(w io.Writer) error { return jpeg.Encode(w, image.NewRGBA(rect), nil) }, imageInfo{"jpeg", 7, 5, 3}, nil},
+		{"gray jpeg", func(w io.Writer) error { return jpeg.Encode(w, image.NewGray(rect), nil) }, imageInfo{"jpeg", 7, 5, 1}, nil},
+		{"gif", func(w io.Writer) error { return gif.Encode(w, image.NewPaletted(rect, palette.Plan9), nil) }, imageInfo{"gif", 7, 5, 3}, nil},
+		{"not an image", func(w io.Writer) error { _, err := w.Write([]byte("hello")); return err }, imageInfo{}, UnsupportedImageError},
+	}
+
+	for ind, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := path.Join(tempDir, string(rune('a'+ind)))
+			file, err := os.Create(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.encode(file); err != nil {
+				t.Fatal(err)
+			}
+			_ = file.Close()
+
+			got, err := readImageInfo(filename)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("that should be error %v but got %v", tt.err, err)
+			}
+			if err == nil && *got != tt.want {
+				t.Errorf("readImageInfo() = %v, want %v", *got, tt.want)
+			}
+		})
+	}
+}
+
+// opaque fills image with opaque color, so png encoder writes it as truecolor without alpha
+func opaque(img *image.RGBA) *image.RGBA {
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{R: 10, G: 20, B: 30, A: 255}), image.Point{}, draw.Src)
+	return img
+}
+
+func writeFile(w io.Writer, data []byte) error {
+	_, err := w.Write(data)
+	return err
+}
+
+// grayAlphaPNG is 1x1 png with color type 4 (grayscale with alpha), which image/png can't encode
+var grayAlphaPNG = []byte{
+	0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0x00, 0x00, 0x0d, 0x49, 0x48, 0x44, 0x52,
+	0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x08, 0x04, 0x00, 0x00, 0x00, 0xb5, 0x1c, 0x0c,
+	0x02, 0x00, 0x00, 0x00, 0x0b, 0x49, 0x44, 0x41, 0x54, 0x78, 0x9c, 0x63, 0x60, 0x60, 0x00, 0x00,
+	0x00, 0x03, 0x00, 0x01, 0xb8, 0xad, 0x3a, 0x63, 0x00, 0x00, 0x00, 0x00, 0x49, 0x45, 0x4e, 0x44,
+	0xae, 0x42, 0x60, 0x82,
+}
+
+func Test_imageInfo_checkSize(t *testing.T) {
+	info := &imageInfo{Width: 10, Height: 20}
+
+	tests := []struct {
+		name          string
+		width, height int
+		err           error
+	}{
+		{"unknown", 0, 0, nil},
+		{"equal", 10, 20, nil},
+		{"swapped", 20, 10, SizeMismatchError},
+		{"wrong width", 11, 20, SizeMismatchError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := info.checkSize(tt.width, tt.height); !errors.Is(err, tt.err) {
+				t.Errorf("that should be error %v but got %v", tt.err, err)
+			}
+		})
+	}
+}
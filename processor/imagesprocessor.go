@@ -1,91 +1,288 @@
 package processor
 
 import (
+	"context"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
-	"strings"
+	"sync"
 	"time"
+
+	"github.com/porfirion/osp/safepath"
 )
 
 var EmptyFilenameError = errors.New("filename can't be empty")
 var EmptyLabelError = errors.New("label can't be empty")
 var MissingInputFileError = errors.New("missing input file")
+var NoObjectsError = errors.New("at least one object required")
+var UnknownActionError = errors.New("unknown action")
+var SendTimeoutError = errors.New("write command timeout exceeded")
+var ResponseTimeoutError = errors.New("read response timeout exceeded")
+var ProcessorClosedError = errors.New("processor is closed")
 
-var logger = log.New(os.Stdout, "ImageProcessor: ", 0)
+// default timeouts of communication with processing goroutine
+const DefaultSendTimeout = 5 * time.Second
+const DefaultResponseTimeout = 30 * time.Second
 
-var xmlHeader = []byte("<?xml version=\"1.0\"?>\n")
+var logger = log.New(os.Stdout, "ImageProcessor: ", 0)
 
 // CommandChan receives commands for ImageProcessor
 type CommandChan chan Command
 
 type Processor interface {
-	ProcessImage(filename string, width, height int, label string, top, left, right, bottom int) (result interface{}, err error)
+	// ProcessImage labels unlabeled image: writes annotation and moves image to labeled path
+	ProcessImage(ctx context.Context, req ImageRequest) (result interface{}, err error)
+	// UpdateImage rewrites annotation of already labeled image
+	UpdateImage(ctx context.Context, req ImageRequest) (result interface{}, err error)
+	// UnlabelImage moves labeled image back to unlabeled path and removes it's annotation
+	UnlabelImage(ctx context.Context, filename string) (result interface{}, err error)
+	// SkipImage leaves unlabeled image in queue, but it's offered only after all not skipped ones
+	SkipImage(ctx context.Context, filename string) (result interface{}, err error)
+	// RejectImage moves unlabeled image to rejected path with file containing reason of rejection
+	RejectImage(ctx context.Context, filename string, reason string) (result interface{}, err error)
+	// MarkNegative labels image as one without objects: writes annotation without objects and moves image to labeled path
+	MarkNegative(ctx context.Context, req ImageRequest) (result interface{}, err error)
+	// Undo reverts save described by revision returned in LabelResult
+	Undo(ctx context.Context, rev *Revision) (result interface{}, err error)
+	// Suggestions returns suggested boxes for unlabeled image in coordinates of shown image. They are read from file
+	// next to image or asked from labeler, whose answer is cached in such file. Labeler is called in background:
+	// SuggestionsPendingError is returned until it answers, it's failure is returned for a minute after that.
+	Suggestions(filename string) ([]Suggestion, error)
+	// IsSkipped reports whether unlabeled image was skipped
+	IsSkipped(filename string) bool
+	// Labels returns allowed labels (empty if any label is allowed)
+	Labels() []Label
+	// Extensions returns allowed extensions of image files
+	Extensions() []string
+	// Close stops accepting new commands, cancels labeler calls in progress and waits until they finish and already
+	// accepted commands are processed
+	Close() error
+}
+
+// Options tune behaviour of ImageProcessor
+type Options struct {
+	// MinBoxSize is minimal width and height of bounding box in pixels (1 if not set)
+	MinBoxSize int
+	// ClampBoxes cuts bounding boxes by image borders instead of rejecting them
+	ClampBoxes bool
+	// Labels is the set of allowed labels. Any label is allowed if it's empty
+	Labels []Label
+	// Extensions of image files that can be labeled, like ".jpg" (DefaultExtensions if not set)
+	Extensions []string
+
+	// SendTimeout limits waiting for processing goroutine to take command (DefaultSendTimeout if not set)
+	SendTimeout time.Duration
+	// ResponseTimeout limits waiting for command result (DefaultResponseTimeout if not set)
+	ResponseTimeout time.Duration
+
+	// ConflictPolicy tells what to do if labeled image with the same name exists (ConflictFail if not set)
+	ConflictPolicy ConflictPolicy
+	// OrientationMode tells how images with EXIF orientation are stored (OrientationNormalize if not set)
+	OrientationMode OrientationMode
+
+	// RejectedPath is where rejected images are moved. Rejecting is disabled if it's empty
+	RejectedPath string
+
+	// Writers write annotation in several formats on each save. Pascal VOC writer is required (it's the only one
+	// if not set), see NewAnnotationWriters
+	Writers []AnnotationWriter
+
+	// Labeler proposes boxes for images without suggestions file (nothing is proposed if it's nil)
+	Labeler Labeler
+	// LabelerTimeout limits single call of labeler (DefaultLabelerTimeout if not set)
+	LabelerTimeout time.Duration
+
+	// OnMove is called after processor moves image between unlabeled and labeled paths (including recovery).
+	// It's called from processing goroutine and shouldn't block.
+	OnMove func(m Move)
+}
+
+// Move describes image moved by processor
+type Move struct {
+	// From and To are names of image before and after move. They differ if image was renamed because of conflict
+	From, To string
+	// ToLabeled is true if image was moved from unlabeled path to labeled one and false for opposite direction
+	ToLabeled bool
+	// Rejected is true if image was moved from unlabeled path to rejected one
+	Rejected bool
+	// Copied is true if image appeared in To while labeled image From stays in place (undo of save over existing
+	// labeled image puts replaced image back)
+	Copied bool
+}
+
+// ImageRequest describes annotation of single image
+type ImageRequest struct {
+	// Filename is slash separated path of image relative to unlabeled (or labeled) path, like "camera_01/image.jpg"
+	Filename string
+
+	// width and height of original image as seen by client (zero if unknown)
+	Width, Height int
+
+	// bounding boxes found on image
+	Objects []Object
 }
 
 // processorImpl is service that takes commands for processing images and their mapping
 type processorImpl struct {
 	unlabeledPath, labeledPath string
+	options                    Options
 	inpChan                    CommandChan
+	skipped                    *skipList
+	labelerCalls               *labelerCalls
+
+	// closing is closed when processor is asked to stop
+	closing   chan struct{}
+	closeOnce sync.Once
+	// done is closed when processing goroutine finishes
+	done chan struct{}
 }
 
+// Action specifies what Command should do with image
+type Action int
+
+const (
+	// ActionLabel writes annotation for unlabeled image and moves it to labeled path
+	ActionLabel Action = iota
+	// ActionUpdate rewrites annotation of labeled image
+	ActionUpdate
+	// ActionUnlabel moves labeled image back to unlabeled path
+	ActionUnlabel
+	// ActionSkip marks unlabeled image as skipped
+	ActionSkip
+	// ActionReject moves unlabeled image to rejected path
+	ActionReject
+	// ActionNegative labels image with annotation without objects
+	ActionNegative
+	// ActionUndo reverts save of annotation
+	ActionUndo
+	// ActionCacheSuggestions writes suggestions of labeler next to unlabeled image
+	ActionCacheSuggestions
+)
+
 // Command to execute on processorImpl
 type Command struct {
+	// Ctx of request that issued command. Command is not executed if it's cancelled before processing starts
+	Ctx context.Context
+
+	Action   Action
 	Filename string
 
 	// width and height of original image
 	Width, Height int
 
-	// bounding box params
-	Label         string
-	Left, Top     int
-	Right, Bottom int
+	// bounding boxes found on image
+	Objects []Object
+
+	// Reason of rejection
+	Reason string
+
+	// Revision to undo
+	Revision *Revision
+
+	// Suggestions of labeler to cache
+	Suggestions []Suggestion
 
 	Resp chan interface{}
 }
 
-type pascalvoc struct {
-	XMLName  xml.Name `xml:"annotation"`
-	Folder   string   `xml:"folder"`
-	Filename string   `xml:"filename"`
-	Path     string   `xml:"path"`
-	Database string   `xml:"source>database"`
+// Object is a single labeled bounding box on image
+type Object struct {
+	Label  string `json:"label"`
+	Left   int    `json:"left"`
+	Top    int    `json:"top"`
+	Right  int    `json:"right"`
+	Bottom int    `json:"bottom"`
+}
+
+func (p *processorImpl) ProcessImage(ctx context.Context, req ImageRequest) (result interface{}, err error) {
+	return p.send(ctx, Command{
+		Action:   ActionLabel,
+		Filename: req.Filename,
+		Width:    req.Width,
+		Height:   req.Height,
+		Objects:  req.Objects,
+	})
+}
 
-	Width  int `xml:"size>width"`
-	Height int `xml:"size>height"`
-	Depth  int `xml:"size>depth"`
+func (p *processorImpl) UpdateImage(ctx context.Context, req ImageRequest) (result interface{}, err error) {
+	return p.send(ctx, Command{
+		Action:   ActionUpdate,
+		Filename: req.Filename,
+		Width:    req.Width,
+		Height:   req.Height,
+		Objects:  req.Objects,
+	})
+}
 
-	Segmented int `xml:"segmented"`
+func (p *processorImpl) UnlabelImage(ctx context.Context, filename string) (result interface{}, err error) {
+	return p.send(ctx, Command{
+		Action:   ActionUnlabel,
+		Filename: filename,
+	})
+}
 
-	Name      string `xml:"object>name"`
-	Pose      string `xml:"object>pose"`
-	Truncated int    `xml:"object>truncated"`
-	Difficult int    `xml:"object>difficult"`
-	Xmin      int    `xml:"object>bndbox>xmin"`
-	Ymin      int    `xml:"object>bndbox>ymin"`
-	Xmax      int    `xml:"object>bndbox>xmax"`
-	Ymax      int    `xml:"object>bndbox>ymax"`
+func (p *processorImpl) SkipImage(ctx context.Context, filename string) (result interface{}, err error) {
+	return p.send(ctx, Command{
+		Action:   ActionSkip,
+		Filename: filename,
+	})
 }
 
-func (p *processorImpl) ProcessImage(filename string, width, height int, label string, left, top, right, bottom int) (result interface{}, err error) {
-	c := Command{
+func (p *processorImpl) RejectImage(ctx context.Context, filename string, reason string) (result interface{}, err error) {
+	return p.send(ctx, Command{
+		Action:   ActionReject,
 		Filename: filename,
-		Width:    width,
-		Height:   height,
+		Reason:   reason,
+	})
+}
+
+func (p *processorImpl) MarkNegative(ctx context.Context, req ImageRequest) (result interface{}, err error) {
+	return p.send(ctx, Command{
+		Action:   ActionNegative,
+		Filename: req.Filename,
+		Width:    req.Width,
+		Height:   req.Height,
+	})
+}
 
-		Label:  label,
-		Left:   left,
-		Top:    top,
-		Right:  right,
-		Bottom: bottom,
-		Resp:   make(chan interface{}, 1),
+func (p *processorImpl) Undo(ctx context.Context, rev *Revision) (result interface{}, err error) {
+	if rev == nil {
+		return nil, NothingToUndoError
 	}
+
+	return p.send(ctx, Command{
+		Action:   ActionUndo,
+		Filename: rev.Filename,
+		Revision: rev,
+	})
+}
+
+func (p *processorImpl) Labels() []Label {
+	return p.options.Labels
+}
+
+func (p *processorImpl) Extensions() []string {
+	return p.options.Extensions
+}
+
+// send passes command to processing goroutine and waits for response
+func (p *processorImpl) send(ctx context.Context, c Command) (result interface{}, err error) {
+	c.Ctx = ctx
+	c.Resp = make(chan interface{}, 1)
+
+	sendTimer := time.NewTimer(p.options.SendTimeout)
+	defer sendTimer.Stop()
+
 	select {
 	case p.inpChan <- c:
+		responseTimer := time.NewTimer(p.options.ResponseTimeout)
+		defer responseTimer.Stop()
+
 		select {
 		case v, ok := <-c.Resp:
 			if !ok {
@@ -98,140 +295,489 @@ func (p *processorImpl) ProcessImage(filename string, width, height int, label s
 			default:
 				return v, nil
 			}
-		case <-time.After(time.Second):
-			return nil, errors.New("read response timeout exceeded")
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for response cancelled: %w", ctx.Err())
+		case <-responseTimer.C:
+			return nil, ResponseTimeoutError
 		}
-	case <-time.After(time.Second):
-		return nil, errors.New("write command timeout exceeded")
+	case <-ctx.Done():
+		return nil, fmt.Errorf("command cancelled: %w", ctx.Err())
+	case <-p.closing:
+		return nil, ProcessorClosedError
+	case <-sendTimer.C:
+		return nil, SendTimeoutError
 	}
 }
 
 func (p *processorImpl) start() {
 	go func() {
+		defer close(p.done)
+
 		for {
 			select {
-			case command, ok := <-p.inpChan:
-				if !ok {
-					logger.Fatalf("input channel is closed")
-				}
-
+			case command := <-p.inpChan:
 				logger.Printf("received command %v\n", command)
 
 				p.processCommand(command)
+			case <-p.closing:
+				// process commands that were handed over while we were closing
+				for {
+					select {
+					case command := <-p.inpChan:
+						logger.Printf("received command %v while closing\n", command)
+
+						p.processCommand(command)
+					default:
+						logger.Println("processor stopped")
+						return
+					}
+				}
 			}
 		}
 	}()
 }
 
+func (p *processorImpl) Close() error {
+	// labeler calls send their answers to processor, so they are stopped first
+	p.labelerCalls.stop()
+
+	p.closeOnce.Do(func() {
+		close(p.closing)
+	})
+
+	<-p.done
+
+	return nil
+}
+
 func (p *processorImpl) processCommand(c Command) {
+	if c.Ctx != nil && c.Ctx.Err() != nil {
+		// nobody waits for result anymore
+		logger.Printf("command cancelled before processing: %v\n", c.Ctx.Err())
+		p.WriteResponse(c, nil, c.Ctx.Err())
+		return
+	}
+
 	if c.Filename == "" {
 		p.WriteResponse(c, nil, EmptyFilenameError)
 		return
 	}
 
-	oldFilePath := path.Join(p.unlabeledPath, c.Filename)
-
-	if _, err := os.Stat(oldFilePath); os.IsNotExist(err) {
-		p.WriteResponse(c, nil, fmt.Errorf("%w (%s)", MissingInputFileError, oldFilePath))
+	paths, err := p.resolvePaths(c.Filename)
+	if err != nil {
+		p.WriteResponse(c, nil, err)
 		return
 	}
 
-	if strings.Trim(c.Label, " \n") == "" {
-		p.WriteResponse(c, nil, EmptyLabelError)
-		return;
+	var result interface{}
+
+	switch c.Action {
+	case ActionLabel, ActionNegative:
+		result, err = p.labelImage(c, paths)
+	case ActionUpdate:
+		result, err = p.updateImage(c, paths)
+	case ActionUnlabel:
+		result, err = p.unlabelImage(c, paths)
+	case ActionSkip:
+		result, err = p.skipImage(c, paths)
+	case ActionReject:
+		result, err = p.rejectImage(c, paths)
+	case ActionUndo:
+		result, err = p.undoImage(c, paths)
+	case ActionCacheSuggestions:
+		result, err = p.cacheSuggestions(c, paths)
+	default:
+		err = fmt.Errorf("%w (%d)", UnknownActionError, c.Action)
 	}
 
-	doc := &pascalvoc{
-		Folder:   filepath.Base(p.unlabeledPath),
-		Filename: c.Filename,
-		Path:     oldFilePath,
-		Database: "Unknown",
+	p.WriteResponse(c, result, err)
+}
 
-		// Size
-		Width:  c.Width,
-		Height: c.Height,
-		Depth:  3,
+// imagePaths are locations of image and it's annotation inside processor directories
+type imagePaths struct {
+	unlabeled  string
+	labeled    string
+	annotation string
+}
 
-		Segmented: 0,
+// resolvePaths safely resolves filename inside unlabeled and labeled paths
+func (p *processorImpl) resolvePaths(filename string) (paths imagePaths, err error) {
+	if paths.unlabeled, err = safepath.Resolve(p.unlabeledPath, filename); err != nil {
+		return paths, err
+	}
+
+	if paths.labeled, err = safepath.Resolve(p.labeledPath, filename); err != nil {
+		return paths, err
+	}
+
+	if paths.annotation, err = safepath.Resolve(p.labeledPath, AnnotationFilename(filename)); err != nil {
+		return paths, err
+	}
+
+	return paths, nil
+}
+
+func (p *processorImpl) labelImage(c Command, paths imagePaths) (interface{}, error) {
+	oldFilePath := paths.unlabeled
 
-		Name:      c.Label,
-		Pose:      "Unspecified",
-		Truncated: 0,
-		Difficult: 0,
+	if _, err := os.Stat(oldFilePath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w (%s)", MissingInputFileError, oldFilePath)
+	}
 
-		Xmin: c.Left,
-		Ymin: c.Top,
-		Xmax: c.Right,
-		Ymax: c.Bottom,
+	doc, err := p.makeDocument(c, oldFilePath)
+	if err != nil {
+		return nil, err
 	}
 
-	output, err := xml.MarshalIndent(doc, "  ", "    ")
+	// suggestions are kept next to unlabeled image, so they are shown again if image is unlabeled
+	if suggestions, err := readOrientedSuggestions(oldFilePath); err != nil {
+		logger.Printf("error reading suggestions of %s: %v\n", oldFilePath, err)
+	} else {
+		recordOrigins(doc, c.Objects, suggestionReferences(suggestions))
+	}
+
+	target, err := p.labelTarget(c.Filename, paths)
 	if err != nil {
-		p.WriteResponse(c, nil, fmt.Errorf("error marshalling document: %w", err))
-		return
+		return nil, err
+	}
+
+	p.locate(doc, target.filename, target.paths.labeled)
+	if target.merge != nil {
+		doc.Objects = mergeObjects(p.mergedObjects(target, doc.Width, doc.Height), doc.Objects)
 	}
 
-	//logger.Println(string(output))
+	newFilePath := target.paths.labeled
+	xmlPath := target.paths.annotation
+
+	if err := p.rotate(oldFilePath, doc); err != nil {
+		return nil, err
+	}
 
-	newFilePath := path.Join(p.labeledPath, c.Filename)
-	cleanName := strings.TrimSuffix(c.Filename, filepath.Ext(c.Filename))
-	xmlPath := path.Join(p.labeledPath, cleanName+".xml")
+	// labeled tree mirrors structure of unlabeled one
+	newDir := filepath.Dir(newFilePath)
+	if err := os.MkdirAll(newDir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating labeled directory: %w", err)
+	}
 
 	//logger.Printf("Writing xml to %s\n", xmlPath)
 
-	file, err := os.Create(xmlPath)
+	if err := p.writeExtraAnnotations(target.filename, doc); err != nil {
+		p.restoreExtraAnnotations(target.filename, target.previous)
+		removeEmptyDirs(p.labeledPath, newDir)
+		return nil, err
+	}
+
+	// annotation is written first: existing xml means that image is labeled even if we crash before moving image.
+	// Recovery on startup finishes such moves.
+	saved, err := writeDocument(xmlPath, doc)
 	if err != nil {
-		p.WriteResponse(c, nil, fmt.Errorf("error creating xml file: %w", err))
-		return
+		p.restoreExtraAnnotations(target.filename, target.previous)
+		removeEmptyDirs(p.labeledPath, newDir)
+		return nil, err
 	}
 
-	written, err := file.Write(xmlHeader)
+	// rollback restores replaced annotation, image stays unlabeled
+	rollback := func() {
+		if target.previous != nil {
+			if wErr := writeFileAtomic(xmlPath, target.previous); wErr != nil {
+				logger.Printf("error restoring annotation %s after failed move: %v\n", xmlPath, wErr)
+			}
+		} else if rmErr := os.Remove(xmlPath); rmErr != nil {
+			logger.Printf("error removing annotation %s after failed move: %v\n", xmlPath, rmErr)
+		}
+		p.restoreExtraAnnotations(target.filename, target.previous)
+		removeEmptyDirs(p.labeledPath, newDir)
+	}
+
+	// replaced image is kept, so the save can be undone
+	backup := ""
+	if fileExists(newFilePath) {
+		if backup, err = p.backupImage(newFilePath); err != nil {
+			rollback()
+			return nil, err
+		}
+	}
+
+	if err := os.Rename(oldFilePath, newFilePath); err != nil {
+		if backup != "" {
+			if rErr := p.restoreBackup(backup, newFilePath); rErr != nil {
+				logger.Printf("error restoring replaced image %s after failed move: %v\n", newFilePath, rErr)
+			}
+		}
+		rollback()
+		return nil, fmt.Errorf("error moving image: %w", err)
+	}
+
+	syncDir(filepath.Dir(oldFilePath))
+	syncDir(newDir)
+	p.moved(Move{From: c.Filename, To: target.filename, ToLabeled: true})
+
+	if err := p.skipped.set(c.Filename, false); err != nil {
+		logger.Printf("error removing %s from skipped: %v\n", c.Filename, err)
+	}
+
+	return &LabelResult{
+		Filename: target.filename,
+		Revision: &Revision{
+			Filename:  target.filename,
+			Unlabeled: c.Filename,
+			Objects:   c.Objects,
+			Previous:  target.previous,
+			Backup:    backup,
+			Saved:     saved,
+		},
+	}, nil
+}
+
+func (p *processorImpl) updateImage(c Command, paths imagePaths) (interface{}, error) {
+	filePath := paths.labeled
+
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w (%s)", MissingInputFileError, filePath)
+	}
+
+	doc, err := p.makeDocument(c, filePath)
 	if err != nil {
-		p.WriteResponse(c, nil, fmt.Errorf("error writing to file: %w", err))
-		return
-	} else if written < len(xmlHeader) {
-		p.WriteResponse(c, nil,fmt.Errorf("couldn't write all data: written %d instead of %d", written, len(output)))
-		return
+		return nil, err
+	}
+	p.locate(doc, c.Filename, filePath)
+
+	previous, err := ioutil.ReadFile(paths.annotation)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading annotation: %w", err)
+	}
+
+	if previous != nil {
+		// origins of boxes are kept if they were recorded
+		previousDoc := &pascalvoc{}
+		if err := xml.Unmarshal(previous, previousDoc); err == nil {
+			recordOrigins(doc, c.Objects, annotationReferences(previousDoc))
+		}
+	}
+
+	if err := p.rotate(filePath, doc); err != nil {
+		return nil, err
+	}
+
+	if err := p.writeExtraAnnotations(c.Filename, doc); err != nil {
+		p.restoreExtraAnnotations(c.Filename, previous)
+		return nil, err
 	}
 
-	written, err = file.Write(output)
+	saved, err := writeDocument(paths.annotation, doc)
 	if err != nil {
-		p.WriteResponse(c, nil, fmt.Errorf("error writing to file: %w", err))
-		return
-	} else if written < len(output) {
-		p.WriteResponse(c, nil,fmt.Errorf("couldn't write all data: written %d instead of %d", written, len(output)))
-		return
+		p.restoreExtraAnnotations(c.Filename, previous)
+		return nil, err
 	}
 
-	if err := file.Close(); err != nil {
-		p.WriteResponse(c, nil, fmt.Errorf("error flushing file: %w", err))
-		return
+	rev := &Revision{
+		Filename: c.Filename,
+		Objects:  c.Objects,
+		Previous: previous,
+		Saved:    saved,
+	}
+	if previous == nil {
+		// image without annotation is unlabeled one, so undo moves it back
+		rev.Unlabeled = c.Filename
 	}
 
-	if err = os.Rename(oldFilePath, newFilePath); err != nil {
-		p.WriteResponse(c, nil, fmt.Errorf("error moving image: %w", err))
-		return
+	return &LabelResult{Filename: c.Filename, Revision: rev}, nil
+}
+
+func (p *processorImpl) unlabelImage(c Command, paths imagePaths) (interface{}, error) {
+	oldFilePath := paths.labeled
+
+	if _, err := os.Stat(oldFilePath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w (%s)", MissingInputFileError, oldFilePath)
+	}
+
+	if fileExists(paths.unlabeled) {
+		return nil, fmt.Errorf("%w (%s)", UnlabeledExistsError, paths.unlabeled)
+	}
+
+	unlabeledDir := filepath.Dir(paths.unlabeled)
+	if err := os.MkdirAll(unlabeledDir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating unlabeled directory: %w", err)
+	}
+
+	// annotation is removed first, labeled image without annotation is moved back to unlabeled by recovery
+	xmlPath := paths.annotation
+	annotation, err := ioutil.ReadFile(xmlPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading annotation: %w", err)
+	}
+
+	if err := os.Remove(xmlPath); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error removing annotation: %w", err)
 	}
 
-	p.WriteResponse(c, true, nil)
+	if err := os.Rename(oldFilePath, paths.unlabeled); err != nil {
+		if annotation != nil {
+			// rollback, image stays labeled
+			if wErr := writeFileAtomic(xmlPath, annotation); wErr != nil {
+				logger.Printf("error restoring annotation %s after failed move: %v\n", xmlPath, wErr)
+			}
+		}
+		return nil, fmt.Errorf("error moving image: %w", err)
+	}
+
+	labeledDir := filepath.Dir(oldFilePath)
+	syncDir(labeledDir)
+	syncDir(unlabeledDir)
+	p.restoreExtraAnnotations(c.Filename, nil)
+	removeEmptyDirs(p.labeledPath, labeledDir)
+	p.moved(Move{From: c.Filename, To: c.Filename, ToLabeled: false})
+
+	return true, nil
+}
+
+// moved notifies listener about moved image
+func (p *processorImpl) moved(m Move) {
+	if p.options.OnMove != nil {
+		p.options.OnMove(m)
+	}
+}
+
+// makeDocument validates command objects and builds Pascal VOC document for them.
+// Image size and depth are taken from image itself, size reported in command is only checked against them.
+// Location of image is filled by locate.
+func (p *processorImpl) makeDocument(c Command, imagePath string) (*pascalvoc, error) {
+	if c.Action == ActionNegative {
+		// image without objects
+		c.Objects = nil
+	} else if len(c.Objects) == 0 {
+		return nil, NoObjectsError
+	}
+
+	labels := make([]string, 0, len(c.Objects))
+	for _, o := range c.Objects {
+		label, err := normalizeLabel(o.Label, p.options.Labels)
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, label)
+	}
+
+	if !HasImageExtension(c.Filename, p.options.Extensions) {
+		return nil, fmt.Errorf("%w (extension of %s is not allowed)", UnsupportedImageError, c.Filename)
+	}
+
+	info, err := readImageInfo(imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	orientation, err := ReadOrientation(imagePath)
+	if err != nil {
+		// browsers ignore broken exif too
+		logger.Printf("error reading orientation of %s: %v\n", imagePath, err)
+		orientation = OrientationNormal
+	}
+
+	// client sees image rotated according to orientation
+	shown := *info
+	shown.Width, shown.Height = orientedSize(info.Width, info.Height, orientation)
+
+	if err := shown.checkSize(c.Width, c.Height); err != nil {
+		return nil, err
+	}
+
+	objects := make([]pascalvocObject, 0, len(c.Objects))
+	for ind, o := range c.Objects {
+		o, err := validateObject(o, shown.Width, shown.Height, p.options.MinBoxSize, p.options.ClampBoxes)
+		if err != nil {
+			return nil, err
+		}
+
+		if p.options.OrientationMode == OrientationNormalize {
+			o = unorientObject(o, info.Width, info.Height, orientation)
+		}
+
+		o.Label = labels[ind]
+		objects = append(objects, newPascalvocObject(o))
+	}
+
+	doc := &pascalvoc{
+		Database: "Unknown",
+
+		// Size
+		Width:  info.Width,
+		Height: info.Height,
+		Depth:  info.Depth,
+
+		Segmented: 0,
+
+		Orientation: orientation,
+
+		Objects: objects,
+	}
+
+	if p.options.OrientationMode == OrientationRotate {
+		// image will be rotated by rotate
+		doc.Width, doc.Height = shown.Width, shown.Height
+	}
+
+	return doc, nil
+}
+
+// rotate physically rotates image according to orientation of doc if processor is in OrientationRotate mode.
+// Rotated image is shown the same way, so it's harmless if labeling fails after rotation.
+func (p *processorImpl) rotate(imagePath string, doc *pascalvoc) error {
+	if p.options.OrientationMode != OrientationRotate || doc.Orientation <= OrientationNormal {
+		return nil
+	}
+
+	if err := rotateImageFile(imagePath, doc.Orientation); err != nil {
+		return err
+	}
+
+	doc.Orientation = OrientationNormal
+
+	return nil
+}
+
+// locate fills location fields of doc for image filename (relative to processor paths) placed at imagePath.
+// Folder is sub directory of image, images from the root of the tree keep name of unlabeled path as folder.
+func (p *processorImpl) locate(doc *pascalvoc, filename, imagePath string) {
+	doc.Folder = path.Dir(filename)
+	if doc.Folder == "." {
+		doc.Folder = filepath.Base(p.unlabeledPath)
+	}
+	doc.Filename = path.Base(filename)
+	doc.Path = imagePath
+}
+
+// writeDocument marshals doc and atomically writes it to xmlPath (replacing existing file).
+// Returns written content.
+func writeDocument(xmlPath string, doc *pascalvoc) ([]byte, error) {
+	output, err := doc.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeFileAtomic(xmlPath, output); err != nil {
+		return nil, fmt.Errorf("error writing xml file: %w", err)
+	}
+
+	return output, nil
 }
 
 func (p *processorImpl) WriteResponse(c Command, result interface{}, err error) {
 	if err != nil {
 		logger.Printf("error processing command: %v\n", err)
-		c.Resp <- err
+		result = err
 	}
 
 	select {
 	case c.Resp <- result:
 		// it's ok
-	case <- time.After(time.Second):
+	case <-time.After(time.Second):
 		logger.Println("write response timeout exceeded")
 	}
 }
 
 // NewImageProcessor creates new ImageProcessor, starts it and returns it
-func NewImageProcessor(unlabeledPath, labeledPath string) (Processor, error) {
+func NewImageProcessor(unlabeledPath, labeledPath string, options Options) (Processor, error) {
 	if _, err := os.Stat(unlabeledPath); os.IsNotExist(err) {
 		return nil, errors.New("unlabeled path doesn't exists")
 	}
@@ -240,10 +786,76 @@ func NewImageProcessor(unlabeledPath, labeledPath string) (Processor, error) {
 		return nil, errors.New("labeled path doesn't exists")
 	}
 
+	if options.MinBoxSize < 1 {
+		options.MinBoxSize = 1
+	}
+
+	options.Extensions = ImageExtensions(options.Extensions)
+
+	if options.OrientationMode == "" {
+		options.OrientationMode = OrientationNormalize
+	} else if !validOrientationMode(options.OrientationMode) {
+		return nil, fmt.Errorf("unknown orientation mode %q", options.OrientationMode)
+	}
+
+	if options.ConflictPolicy == "" {
+		options.ConflictPolicy = ConflictFail
+	} else if !validConflictPolicy(options.ConflictPolicy) {
+		return nil, fmt.Errorf("unknown conflict policy %q", options.ConflictPolicy)
+	}
+
+	if options.SendTimeout <= 0 {
+		options.SendTimeout = DefaultSendTimeout
+	}
+
+	if options.ResponseTimeout <= 0 {
+		options.ResponseTimeout = DefaultResponseTimeout
+	}
+
+	if len(options.Writers) == 0 {
+		options.Writers = []AnnotationWriter{VOCWriter{}}
+	}
+
+	hasVOC := false
+	for _, w := range options.Writers {
+		hasVOC = hasVOC || w.Format() == FormatVOC
+	}
+	if !hasVOC {
+		return nil, MissingVOCFormatError
+	}
+
+	if err := WriteYOLOClasses(labeledPath, options.Writers); err != nil {
+		return nil, err
+	}
+
+	if options.LabelerTimeout <= 0 {
+		options.LabelerTimeout = DefaultLabelerTimeout
+	}
+
+	if options.RejectedPath != "" {
+		if err := os.MkdirAll(options.RejectedPath, 0755); err != nil {
+			return nil, fmt.Errorf("error creating rejected path: %w", err)
+		}
+	}
+
+	skipped, err := loadSkipList(unlabeledPath)
+	if err != nil {
+		return nil, err
+	}
+
 	p := &processorImpl{
 		unlabeledPath: unlabeledPath,
 		labeledPath:   labeledPath,
+		options:       options,
+		skipped:       skipped,
+		labelerCalls:  newLabelerCalls(),
 		inpChan:       make(CommandChan),
+		closing:       make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	if err := p.recoverPairs(); err != nil {
+		return nil, fmt.Errorf("error recovering labeled path: %w", err)
 	}
 
 	p.start()
@@ -1,17 +1,31 @@
 package processor
 
 import (
+	"context"
+	"encoding/xml"
 	"errors"
+	"image"
+	"image/png"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/porfirion/osp/safepath"
 )
 
+// testImageSize is width and height of image created by setupTempDir
+const testImageSize = 10
+
 func setupTempDir(dir string) (unlabeled, labeled string, filename string) {
 	unlabeled, _ = ioutil.TempDir(dir, "unlabeled")
 	labeled, _ = ioutil.TempDir(dir, "labeled")
 	file, _ := ioutil.TempFile(unlabeled, "input*.png")
+	_ = png.Encode(file, image.NewRGBA(image.Rect(0, 0, testImageSize, testImageSize)))
 	_ = file.Close()
 	filename = path.Base(file.Name())
 
@@ -33,7 +47,7 @@ func Test_processorImpl_processCommand_notExistingDirs(t *testing.T) {
 		{"", labeled},
 	}
 	for _, paths := range inps {
-		_, err = NewImageProcessor(paths[0], paths[1])
+		_, err = NewImageProcessor(paths[0], paths[1], Options{})
 		if err == nil {
 			t.Error("it should fail when directories doesn't exist")
 		}
@@ -48,18 +62,18 @@ func Test_processorImpl_processCommand_2(t *testing.T) {
 	defer os.RemoveAll(tempDir)
 	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
 
-	p, err := NewImageProcessor(unlabeled, labeled)
+	p, err := NewImageProcessor(unlabeled, labeled, Options{})
 	if err != nil {
 		t.Fatal("error creating new image processor")
 	}
+	defer p.Close()
 
 	type test struct {
-		name                     string
-		filename                 string
-		width, height            int
-		label                    string
-		left, top, right, bottom int
-		err                      error
+		name          string
+		filename      string
+		width, height int
+		objects       []Object
+		err           error
 	}
 
 	p, ok := p.(*processorImpl)
@@ -68,18 +82,419 @@ func Test_processorImpl_processCommand_2(t *testing.T) {
 	}
 
 	tests := []test{
-		{"empty name", "", 0, 0, "", 0, 0, 0, 0, EmptyFilenameError},
-		{"wrong name", "nothing", 0, 0, "", 0, 0, 0, 0, MissingInputFileError},
-		{"empty label", inputFilename, 0, 0, "", 0, 0, 0, 0, EmptyLabelError},
-		{"ok", inputFilename, 0, 0, "test", 0, 0, 0, 0, nil},
+		{"empty name", "", 0, 0, nil, EmptyFilenameError},
+		{"wrong name", "nothing", 0, 0, nil, MissingInputFileError},
+		{"no objects", inputFilename, 0, 0, nil, NoObjectsError},
+		{"empty label", inputFilename, 0, 0, []Object{{"", 0, 0, 5, 5}}, EmptyLabelError},
+		{"one empty label", inputFilename, 0, 0, []Object{{"test", 0, 0, 5, 5}, {" ", 0, 0, 5, 5}}, EmptyLabelError},
+		{"size mismatch", inputFilename, 5, 5, []Object{{"test", 0, 0, 5, 5}}, SizeMismatchError},
+		{"ok", inputFilename, 0, 0, []Object{{"test", 0, 0, 5, 5}, {"other", 0, 0, 5, 5}}, nil},
 	}
 
 	for _, tst := range tests {
 		t.Run(tst.name, func(t *testing.T) {
-			_, err := p.ProcessImage(tst.filename, tst.width, tst.height, tst.label, tst.top, tst.left, tst.right, tst.bottom)
+			_, err := p.ProcessImage(context.Background(), ImageRequest{tst.filename, tst.width, tst.height, tst.objects})
 			if !errors.Is(err, tst.err) {
 				t.Errorf("that should be error %v but got %v", tst.err, err)
 			}
 		})
 	}
 }
+
+func Test_processorImpl_processCommand_multipleObjects(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+	p, err := NewImageProcessor(unlabeled, labeled, Options{})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+	defer p.Close()
+
+	objects := []Object{
+		{"car", 1, 2, 3, 4},
+		{"bike", 5, 6, 7, 8},
+	}
+	if _, err := p.ProcessImage(context.Background(), ImageRequest{inputFilename, 10, 10, objects}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	xmlPath := path.Join(labeled, strings.TrimSuffix(inputFilename, filepath.Ext(inputFilename))+".xml")
+	data, err := ioutil.ReadFile(xmlPath)
+	if err != nil {
+		t.Fatalf("error reading xml: %v", err)
+	}
+
+	doc := &pascalvoc{}
+	if err := xml.Unmarshal(data, doc); err != nil {
+		t.Fatalf("error parsing xml: %v", err)
+	}
+
+	if len(doc.Objects) != len(objects) {
+		t.Fatalf("expected %d objects but got %d", len(objects), len(doc.Objects))
+	}
+
+	for i, o := range objects {
+		got := doc.Objects[i]
+		if got.Name != o.Label || got.Xmin != o.Left || got.Ymin != o.Top || got.Xmax != o.Right || got.Ymax != o.Bottom {
+			t.Errorf("object %d: expected %v but got %v", i, o, got)
+		}
+	}
+}
+
+func Test_processorImpl_updateAndUnlabel(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+	p, err := NewImageProcessor(unlabeled, labeled, Options{})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+	defer p.Close()
+
+	if _, err := p.UpdateImage(context.Background(), ImageRequest{inputFilename, 10, 10, []Object{{"car", 1, 2, 3, 4}}}); !errors.Is(err, MissingInputFileError) {
+		t.Fatalf("updating unlabeled image should fail with %v but got %v", MissingInputFileError, err)
+	}
+
+	if _, err := p.ProcessImage(context.Background(), ImageRequest{inputFilename, 10, 10, []Object{{"car", 1, 2, 3, 4}}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	updated := []Object{{"bike", 2, 3, 4, 5}, {"car", 5, 6, 7, 8}}
+	if _, err := p.UpdateImage(context.Background(), ImageRequest{inputFilename, 10, 10, updated}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	annotation, err := ReadAnnotation(labeled, inputFilename)
+	if err != nil {
+		t.Fatalf("error reading annotation: %v", err)
+	}
+	if !reflect.DeepEqual(annotation.Objects, updated) {
+		t.Errorf("expected objects %v but got %v", updated, annotation.Objects)
+	}
+
+	if _, err := p.UnlabelImage(context.Background(), inputFilename); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if _, err := os.Stat(path.Join(unlabeled, inputFilename)); err != nil {
+		t.Errorf("image should be moved back to unlabeled: %v", err)
+	}
+	if _, err := os.Stat(path.Join(labeled, AnnotationFilename(inputFilename))); !os.IsNotExist(err) {
+		t.Errorf("annotation should be removed, got %v", err)
+	}
+}
+
+func Test_processorImpl_subdirectories(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, _ := setupTempDir(tempDir)
+
+	const filename = "camera_01/2026-10-01/image.png"
+	if err := os.MkdirAll(path.Join(unlabeled, path.Dir(filename)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	copyTestImage(t, path.Join(unlabeled, filename))
+
+	p, err := NewImageProcessor(unlabeled, labeled, Options{})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+	defer p.Close()
+
+	objects := []Object{{"car", 1, 2, 3, 4}}
+	if _, err := p.ProcessImage(context.Background(), ImageRequest{filename, 10, 10, objects}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// labeled tree mirrors unlabeled one
+	if !fileExists(path.Join(labeled, filename)) {
+		t.Fatalf("image should be moved to labeled sub directory")
+	}
+
+	data, err := ioutil.ReadFile(path.Join(labeled, AnnotationFilename(filename)))
+	if err != nil {
+		t.Fatalf("error reading xml: %v", err)
+	}
+
+	doc := &pascalvoc{}
+	if err := xml.Unmarshal(data, doc); err != nil {
+		t.Fatalf("error parsing xml: %v", err)
+	}
+	if doc.Folder != "camera_01/2026-10-01" || doc.Filename != "image.png" || doc.Path != path.Join(labeled, filename) {
+		t.Errorf("wrong location in annotation: folder %q, filename %q, path %q", doc.Folder, doc.Filename, doc.Path)
+	}
+
+	annotation, err := ReadAnnotation(labeled, filename)
+	if err != nil {
+		t.Fatalf("error reading annotation: %v", err)
+	}
+	if annotation.Filename != filename {
+		t.Errorf("annotation filename = %q, want %q", annotation.Filename, filename)
+	}
+
+	if _, err := p.UnlabelImage(context.Background(), filename); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if !fileExists(path.Join(unlabeled, filename)) {
+		t.Errorf("image should be moved back to unlabeled sub directory")
+	}
+	if _, err := os.Stat(path.Join(labeled, "camera_01")); !os.IsNotExist(err) {
+		t.Errorf("empty labeled sub directories should be removed, got %v", err)
+	}
+}
+
+func Test_processorImpl_OnMove(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+	// called from processing goroutine, but commands are synchronous, so there is no race
+	var moves []Move
+	p, err := NewImageProcessor(unlabeled, labeled, Options{OnMove: func(m Move) { moves = append(moves, m) }})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+	defer p.Close()
+
+	if _, err := p.ProcessImage(context.Background(), ImageRequest{inputFilename, 0, 0, []Object{{"car", 0, 0, 5, 5}}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := p.UnlabelImage(context.Background(), inputFilename); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// failed commands don't move anything
+	_, _ = p.UnlabelImage(context.Background(), inputFilename)
+
+	want := []Move{
+		{From: inputFilename, To: inputFilename, ToLabeled: true},
+		{From: inputFilename, To: inputFilename, ToLabeled: false},
+	}
+	if !reflect.DeepEqual(moves, want) {
+		t.Errorf("moves = %v, want %v", moves, want)
+	}
+}
+
+func TestListFiles(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	for _, name := range []string{"a/b/c.jpg", "a.jpg", "b.jpg", ".hidden/d.jpg", "a/.DS_Store", "empty/"} {
+		filePath := path.Join(tempDir, name)
+		if err := os.MkdirAll(path.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasSuffix(name, "/") {
+			continue
+		}
+		if err := ioutil.WriteFile(filePath, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := ListFiles(tempDir)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	want := []string{"a.jpg", "a/b/c.jpg", "b.jpg"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("ListFiles() = %v, want %v", files, want)
+	}
+}
+
+func Test_processorImpl_processCommand_boxValidation(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	type test struct {
+		name    string
+		options Options
+		object  Object
+		want    Object
+		err     error
+	}
+
+	// test image is testImageSize x testImageSize
+	tests := []test{
+		{"ok", Options{}, Object{"a", 1, 2, 3, 4}, Object{"a", 1, 2, 3, 4}, nil},
+		{"whole image", Options{}, Object{"a", 0, 0, 10, 10}, Object{"a", 0, 0, 10, 10}, nil},
+		{"negative left", Options{}, Object{"a", -1, 2, 3, 4}, Object{}, OutOfBoundsError},
+		{"negative top", Options{}, Object{"a", 1, -2, 3, 4}, Object{}, OutOfBoundsError},
+		{"beyond right", Options{}, Object{"a", 1, 2, 11, 4}, Object{}, OutOfBoundsError},
+		{"beyond bottom", Options{}, Object{"a", 1, 2, 3, 11}, Object{}, OutOfBoundsError},
+		{"inverted horizontally", Options{}, Object{"a", 3, 2, 1, 4}, Object{}, InvertedBoxError},
+		{"inverted vertically", Options{}, Object{"a", 1, 4, 3, 2}, Object{}, InvertedBoxError},
+		{"zero width", Options{}, Object{"a", 1, 2, 1, 4}, Object{}, BoxTooSmallError},
+		{"zero height", Options{}, Object{"a", 1, 2, 3, 2}, Object{}, BoxTooSmallError},
+		{"below minimum", Options{MinBoxSize: 3}, Object{"a", 1, 2, 3, 6}, Object{}, BoxTooSmallError},
+		{"minimum", Options{MinBoxSize: 2}, Object{"a", 1, 2, 3, 4}, Object{"a", 1, 2, 3, 4}, nil},
+		{"clamped", Options{ClampBoxes: true}, Object{"a", -5, -1, 20, 12}, Object{"a", 0, 0, 10, 10}, nil},
+		{"clamped too small", Options{ClampBoxes: true}, Object{"a", 10, 2, 15, 4}, Object{}, BoxTooSmallError},
+		{"clamped inverted", Options{ClampBoxes: true}, Object{"a", 5, 2, -3, 4}, Object{}, InvertedBoxError},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+			p, err := NewImageProcessor(unlabeled, labeled, tst.options)
+			if err != nil {
+				t.Fatal("error creating new image processor")
+			}
+			defer p.Close()
+
+			_, err = p.ProcessImage(context.Background(), ImageRequest{inputFilename, 0, 0, []Object{tst.object}})
+			if !errors.Is(err, tst.err) {
+				t.Fatalf("that should be error %v but got %v", tst.err, err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			annotation, err := ReadAnnotation(labeled, inputFilename)
+			if err != nil {
+				t.Fatalf("error reading annotation: %v", err)
+			}
+			if len(annotation.Objects) != 1 || annotation.Objects[0] != tst.want {
+				t.Errorf("expected objects %v but got %v", []Object{tst.want}, annotation.Objects)
+			}
+		})
+	}
+}
+
+func Test_processorImpl_send_timeoutsAndCancellation(t *testing.T) {
+	// processing goroutine is not started, so nobody reads commands
+	idle := &processorImpl{
+		options: Options{SendTimeout: 10 * time.Millisecond, ResponseTimeout: 10 * time.Millisecond},
+		inpChan: make(CommandChan),
+	}
+
+	if _, err := idle.UnlabelImage(context.Background(), "test.png"); !errors.Is(err, SendTimeoutError) {
+		t.Errorf("that should be error %v but got %v", SendTimeoutError, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := idle.UnlabelImage(ctx, "test.png"); !errors.Is(err, context.Canceled) {
+		t.Errorf("that should be error %v but got %v", context.Canceled, err)
+	}
+
+	// goroutine takes commands but never answers
+	stuck := &processorImpl{
+		options: Options{SendTimeout: 10 * time.Millisecond, ResponseTimeout: 10 * time.Millisecond},
+		inpChan: make(CommandChan, 1),
+	}
+
+	if _, err := stuck.UnlabelImage(context.Background(), "test.png"); !errors.Is(err, ResponseTimeoutError) {
+		t.Errorf("that should be error %v but got %v", ResponseTimeoutError, err)
+	}
+
+	// cancelled command is not executed
+	c := Command{Ctx: ctx, Action: ActionUnlabel, Filename: "test.png", Resp: make(chan interface{}, 1)}
+	stuck.processCommand(c)
+	if err, ok := (<-c.Resp).(error); !ok || !errors.Is(err, context.Canceled) {
+		t.Errorf("that should be error %v but got %v", context.Canceled, err)
+	}
+}
+
+func Test_processorImpl_Close(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+	p, err := NewImageProcessor(unlabeled, labeled, Options{})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+	defer p.Close()
+
+	// commands sent before closing are processed
+	if _, err := p.ProcessImage(context.Background(), ImageRequest{inputFilename, 0, 0, []Object{{"car", 1, 2, 3, 4}}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("unexpected error closing processor: %v", err)
+	}
+
+	if _, err := p.UnlabelImage(context.Background(), inputFilename); !errors.Is(err, ProcessorClosedError) {
+		t.Errorf("that should be error %v but got %v", ProcessorClosedError, err)
+	}
+
+	// closing twice is fine
+	if err := p.Close(); err != nil {
+		t.Errorf("unexpected error closing processor twice: %v", err)
+	}
+}
+
+func Test_processorImpl_processCommand_unsafePaths(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+	// file outside of processor directories
+	_ = ioutil.WriteFile(path.Join(tempDir, "outside.png"), []byte("secret"), 0644)
+
+	p, err := NewImageProcessor(unlabeled, labeled, Options{})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+	defer p.Close()
+
+	objects := []Object{{"car", 1, 1, 5, 5}}
+	names := []string{"../outside.png", "/" + path.Join(unlabeled, inputFilename), "../" + path.Base(unlabeled) + "/" + inputFilename}
+
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			var unsafe *safepath.UnsafePathError
+
+			if _, err := p.ProcessImage(context.Background(), ImageRequest{name, 0, 0, objects}); !errors.As(err, &unsafe) {
+				t.Errorf("ProcessImage should fail with UnsafePathError but got %v", err)
+			}
+			if _, err := p.UnlabelImage(context.Background(), name); !errors.As(err, &unsafe) {
+				t.Errorf("UnlabelImage should fail with UnsafePathError but got %v", err)
+			}
+		})
+	}
+
+	if !fileExists(path.Join(tempDir, "outside.png")) || !fileExists(path.Join(unlabeled, inputFilename)) {
+		t.Error("files shouldn't be moved")
+	}
+}
@@ -0,0 +1,327 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/porfirion/osp/safepath"
+)
+
+var LabelerFailedError = errors.New("labeler failed")
+var NotLocalLabelerError = errors.New("labeler URL should point to localhost")
+var SuggestionsPendingError = errors.New("labeler is still proposing boxes")
+
+// DefaultLabelerTimeout limits single call of labeler
+const DefaultLabelerTimeout = 30 * time.Second
+
+// labelerFailureTTL is how long failed call of labeler is remembered
+const labelerFailureTTL = time.Minute
+
+// maxLabelerResponse limits size of labeler answer
+const maxLabelerResponse = 10 << 20
+
+// Labeler proposes bounding boxes for image, for example by running detector. Boxes are in coordinates of raw image.
+type Labeler interface {
+	Label(ctx context.Context, req LabelerRequest) ([]Suggestion, error)
+}
+
+// LabelerRequest is sent to labeler as JSON
+type LabelerRequest struct {
+	// Filename is slash separated path of image relative to unlabeled path
+	Filename string `json:"filename"`
+	// Path is absolute path of image file
+	Path string `json:"path"`
+	// Width and Height of raw image
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Labels are names of allowed labels (empty if any label is allowed)
+	Labels []string `json:"labels,omitempty"`
+}
+
+// parseLabelerResponse reads answer of labeler. It has the same format as suggestions file.
+func parseLabelerResponse(data []byte) ([]Suggestion, error) {
+	doc := &SuggestionsDocument{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("%w: error parsing response: %v", LabelerFailedError, err)
+	}
+
+	return doc.Objects, nil
+}
+
+// CommandLabeler starts executable for each image. Request is written to it's stdin and answer is read from stdout.
+type CommandLabeler struct {
+	// Command is executable with arguments
+	Command []string
+}
+
+func (l *CommandLabeler) Label(ctx context.Context, req LabelerRequest) ([]Suggestion, error) {
+	if len(l.Command) == 0 {
+		return nil, fmt.Errorf("%w: command is empty", LabelerFailedError)
+	}
+
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, l.Command[0], l.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v %s", LabelerFailedError, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseLabelerResponse(output)
+}
+
+// HTTPLabeler posts request to http endpoint on localhost
+type HTTPLabeler struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewHTTPLabeler creates labeler for endpoint. Images are sent to it, so only localhost is allowed.
+func NewHTTPLabeler(rawURL string) (*HTTPLabeler, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing labeler URL: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme of labeler URL %q", u.Scheme)
+	}
+
+	if !isLocalHost(u.Hostname()) {
+		return nil, fmt.Errorf("%w (%s)", NotLocalLabelerError, u.Hostname())
+	}
+
+	return &HTTPLabeler{URL: rawURL, Client: http.DefaultClient}, nil
+}
+
+func isLocalHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (l *HTTPLabeler) Label(ctx context.Context, req LabelerRequest) ([]Suggestion, error) {
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, l.URL, bytes.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := l.Client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", LabelerFailedError, err)
+	}
+	defer resp.Body.Close()
+
+	output, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxLabelerResponse))
+	if err != nil {
+		return nil, fmt.Errorf("%w: error reading response: %v", LabelerFailedError, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %s %s", LabelerFailedError, resp.Status, strings.TrimSpace(string(output)))
+	}
+
+	return parseLabelerResponse(output)
+}
+
+// labelerFailure is remembered error of labeler, so failing labeler isn't asked again on each request of image
+type labelerFailure struct {
+	err error
+	at  time.Time
+}
+
+// labelerCalls makes sure that labeler is called once for image even if it's opened by several users at once.
+// Calls are made in background, so requests of image don't wait for labeler. They are cancelled by stop.
+type labelerCalls struct {
+	mu       sync.Mutex
+	calls    map[string]bool
+	failures map[string]labelerFailure
+	// failureTTL is how long failure is returned before labeler is asked again
+	failureTTL time.Duration
+
+	// ctx is parent of all calls, it's cancelled when processor is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newLabelerCalls() *labelerCalls {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &labelerCalls{
+		calls:      make(map[string]bool),
+		failures:   make(map[string]labelerFailure),
+		failureTTL: labelerFailureTTL,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// start runs fn in background unless it's already running for image. Error of recent failure is returned
+// instead of starting it again, otherwise SuggestionsPendingError is returned.
+func (l *labelerCalls) start(filename string, fn func(ctx context.Context) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.ctx.Err() != nil {
+		return ProcessorClosedError
+	}
+
+	if f, ok := l.failures[filename]; ok {
+		if time.Since(f.at) < l.failureTTL {
+			return f.err
+		}
+		delete(l.failures, filename)
+	}
+
+	if !l.calls[filename] {
+		l.calls[filename] = true
+		l.wg.Add(1)
+		go l.run(filename, fn)
+	}
+
+	return SuggestionsPendingError
+}
+
+// stop cancels calls in progress and waits for them to finish. No calls are started after it.
+func (l *labelerCalls) stop() {
+	l.mu.Lock()
+	l.cancel()
+	l.mu.Unlock()
+
+	l.wg.Wait()
+}
+
+func (l *labelerCalls) run(filename string, fn func(ctx context.Context) error) {
+	defer l.wg.Done()
+
+	err := fn(l.ctx)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.calls, filename)
+	if err == nil {
+		return
+	}
+
+	// expired failures of other images are dropped here, so map doesn't grow with images that aren't opened again
+	now := time.Now()
+	for f, failure := range l.failures {
+		if now.Sub(failure.at) >= l.failureTTL {
+			delete(l.failures, f)
+		}
+	}
+	l.failures[filename] = labelerFailure{err: err, at: now}
+}
+
+func (p *processorImpl) Suggestions(filename string) ([]Suggestion, error) {
+	imagePath, err := safepath.Resolve(p.unlabeledPath, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.options.Labeler == nil || hasSuggestions(imagePath) {
+		return readOrientedSuggestions(imagePath)
+	}
+
+	if !fileExists(imagePath) {
+		return nil, fmt.Errorf("%w (%s)", MissingInputFileError, imagePath)
+	}
+
+	// labeler isn't called from processing goroutine, it may be slow. Only it's answer is written by processor.
+	return nil, p.labelerCalls.start(filename, func(ctx context.Context) error {
+		info, err := readImageInfo(imagePath)
+		if err != nil {
+			return err
+		}
+
+		absPath, err := filepath.Abs(imagePath)
+		if err != nil {
+			return err
+		}
+
+		labels := make([]string, 0, len(p.options.Labels))
+		for _, l := range p.options.Labels {
+			labels = append(labels, l.Name)
+		}
+
+		// call doesn't belong to request that started it, so it has it's own timeout
+		labelerCtx, cancel := context.WithTimeout(ctx, p.options.LabelerTimeout)
+		defer cancel()
+
+		suggestions, err := p.options.Labeler.Label(labelerCtx, LabelerRequest{
+			Filename: filename,
+			Path:     absPath,
+			Width:    info.Width,
+			Height:   info.Height,
+			Labels:   labels,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = p.send(ctx, Command{
+			Action:      ActionCacheSuggestions,
+			Filename:    filename,
+			Suggestions: suggestions,
+		})
+		return err
+	})
+}
+
+// cacheSuggestions writes answer of labeler next to unlabeled image. Existing suggestions file is never replaced.
+func (p *processorImpl) cacheSuggestions(c Command, paths imagePaths) (interface{}, error) {
+	if !fileExists(paths.unlabeled) {
+		return nil, fmt.Errorf("%w (%s)", MissingInputFileError, paths.unlabeled)
+	}
+
+	if hasSuggestions(paths.unlabeled) {
+		return true, nil
+	}
+
+	// empty answer is cached too, so labeler isn't asked again
+	doc := SuggestionsDocument{Objects: c.Suggestions}
+	if doc.Objects == nil {
+		doc.Objects = make([]Suggestion, 0)
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeFileAtomic(SuggestionsFilename(paths.unlabeled), data); err != nil {
+		return nil, fmt.Errorf("error writing suggestions: %w", err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,263 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// labelerFunc allows to use function as Labeler
+type labelerFunc func(ctx context.Context, req LabelerRequest) ([]Suggestion, error)
+
+func (f labelerFunc) Label(ctx context.Context, req LabelerRequest) ([]Suggestion, error) {
+	return f(ctx, req)
+}
+
+func Test_NewHTTPLabeler(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"http://localhost:8081/", false},
+		{"http://127.0.0.1:8081/label", false},
+		{"http://[::1]:8081/", false},
+		{"https://LOCALHOST/", false},
+		{"http://example.com/", true},
+		{"http://192.168.1.10:8081/", true},
+		{"ftp://localhost/", true},
+		{"localhost:8081", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if _, err := NewHTTPLabeler(tt.url); (err != nil) != tt.wantErr {
+				t.Errorf("NewHTTPLabeler() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// waitSuggestions asks suggestions of image until labeler answers
+func waitSuggestions(t *testing.T, p Processor, filename string) ([]Suggestion, error) {
+	t.Helper()
+
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		if suggestions, err := p.Suggestions(filename); !errors.Is(err, SuggestionsPendingError) {
+			return suggestions, err
+		}
+	}
+
+	t.Fatal("labeler didn't answer")
+	return nil, nil
+}
+
+func Test_processorImpl_Suggestions_labeler(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+	var calls int32
+	var fail atomic.Value
+	fail.Store(true)
+
+	labeler := labelerFunc(func(ctx context.Context, req LabelerRequest) ([]Suggestion, error) {
+		atomic.AddInt32(&calls, 1)
+		if req.Filename != inputFilename || req.Width != testImageSize || req.Height != testImageSize || !filepath.IsAbs(req.Path) {
+			t.Errorf("unexpected request %v", req)
+		}
+		if fail.Load().(bool) {
+			return nil, LabelerFailedError
+		}
+		return []Suggestion{{Object{"car", 1, 1, 5, 5}, 0.7}}, nil
+	})
+
+	p, err := NewImageProcessor(unlabeled, labeled, Options{Labeler: labeler})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+	defer p.Close()
+
+	// labeler is called in background
+	if _, err := p.Suggestions(inputFilename); !errors.Is(err, SuggestionsPendingError) {
+		t.Fatalf("Suggestions() error = %v, want %v", err, SuggestionsPendingError)
+	}
+	if _, err := waitSuggestions(t, p, inputFilename); !errors.Is(err, LabelerFailedError) {
+		t.Fatalf("Suggestions() error = %v, want %v", err, LabelerFailedError)
+	}
+	if fileExists(path.Join(unlabeled, SuggestionsFilename(inputFilename))) {
+		t.Error("failed answer shouldn't be written")
+	}
+
+	// failure is remembered for a while
+	fail.Store(false)
+	if _, err := p.Suggestions(inputFilename); !errors.Is(err, LabelerFailedError) {
+		t.Fatalf("Suggestions() error = %v, want remembered %v", err, LabelerFailedError)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("labeler called %d times, want 1 (failure should be remembered)", n)
+	}
+
+	labelerCalls := p.(*processorImpl).labelerCalls
+	labelerCalls.mu.Lock()
+	labelerCalls.failureTTL = 0
+	labelerCalls.mu.Unlock()
+
+	want := []Suggestion{{Object{"car", 1, 1, 5, 5}, 0.7}}
+	for i := 0; i < 2; i++ {
+		got, err := waitSuggestions(t, p, inputFilename)
+		if err != nil {
+			t.Fatalf("Suggestions() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Suggestions() = %v, want %v", got, want)
+		}
+	}
+
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("labeler called %d times, want 2 (answer should be cached)", n)
+	}
+	if !fileExists(path.Join(unlabeled, SuggestionsFilename(inputFilename))) {
+		t.Error("answer of labeler should be cached next to image")
+	}
+
+	// cached suggestions are used to record origins
+	if _, err := p.ProcessImage(context.Background(), ImageRequest{inputFilename, 10, 10, []Object{{"car", 1, 1, 5, 5}}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	a, err := ReadAnnotation(labeled, inputFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Objects) != 1 {
+		t.Errorf("objects = %v, want single object", a.Objects)
+	}
+
+	// missing image isn't sent to labeler
+	if _, err := p.Suggestions("missing.png"); !errors.Is(err, MissingInputFileError) {
+		t.Errorf("Suggestions() of missing image error = %v, want %v", err, MissingInputFileError)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("labeler called %d times, want 2", n)
+	}
+}
+
+func Test_processorImpl_Suggestions_slowLabeler(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+	var calls int32
+	release := make(chan struct{})
+	labeler := labelerFunc(func(ctx context.Context, req LabelerRequest) ([]Suggestion, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []Suggestion{{Object{"car", 1, 1, 5, 5}, 0.7}}, nil
+	})
+
+	p, err := NewImageProcessor(unlabeled, labeled, Options{Labeler: labeler})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+	defer p.Close()
+
+	// requests don't wait for labeler and don't start it again
+	for i := 0; i < 3; i++ {
+		if _, err := p.Suggestions(inputFilename); !errors.Is(err, SuggestionsPendingError) {
+			t.Fatalf("Suggestions() error = %v, want %v", err, SuggestionsPendingError)
+		}
+	}
+
+	close(release)
+	if got, err := waitSuggestions(t, p, inputFilename); err != nil || len(got) != 1 {
+		t.Errorf("Suggestions() = %v, %v, want single suggestion", got, err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("labeler called %d times, want 1", n)
+	}
+}
+
+func Test_processorImpl_Close_labeler(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+	var finished int32
+	started := make(chan struct{})
+	labeler := labelerFunc(func(ctx context.Context, req LabelerRequest) ([]Suggestion, error) {
+		close(started)
+		<-ctx.Done()
+		atomic.StoreInt32(&finished, 1)
+		return nil, ctx.Err()
+	})
+
+	p, err := NewImageProcessor(unlabeled, labeled, Options{Labeler: labeler, LabelerTimeout: time.Hour})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+
+	if _, err := p.Suggestions(inputFilename); !errors.Is(err, SuggestionsPendingError) {
+		t.Fatalf("Suggestions() error = %v, want %v", err, SuggestionsPendingError)
+	}
+	<-started
+
+	// labeler is cancelled and waited for
+	_ = p.Close()
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Error("labeler call should be finished when processor is closed")
+	}
+
+	p.(*processorImpl).labelerCalls.failureTTL = 0
+	if _, err := p.Suggestions(inputFilename); !errors.Is(err, ProcessorClosedError) {
+		t.Errorf("Suggestions() error = %v, want %v", err, ProcessorClosedError)
+	}
+}
+
+func Test_processorImpl_Suggestions_emptyAnswer(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+	var calls int32
+	labeler := labelerFunc(func(ctx context.Context, req LabelerRequest) ([]Suggestion, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, nil
+	})
+
+	p, err := NewImageProcessor(unlabeled, labeled, Options{Labeler: labeler})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+	defer p.Close()
+
+	for i := 0; i < 2; i++ {
+		if got, err := waitSuggestions(t, p, inputFilename); err != nil || got != nil {
+			t.Errorf("Suggestions() = %v, %v, want nothing", got, err)
+		}
+	}
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("labeler called %d times, want 1 (empty answer should be cached)", n)
+	}
+}
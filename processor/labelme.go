@@ -0,0 +1,213 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path"
+)
+
+// labelMeVersion is version of LabelMe written into new documents
+const labelMeVersion = "5.0.1"
+
+// labelMeRectangle is shape type of bounding box
+const labelMeRectangle = "rectangle"
+
+// labelMeDocument is LabelMe json. Shapes are kept raw, so shapes osp doesn't know (polygons and others) are written
+// back as they were.
+type labelMeDocument struct {
+	Version     string            `json:"version"`
+	Flags       json.RawMessage   `json:"flags"`
+	Shapes      []json.RawMessage `json:"shapes"`
+	ImagePath   string            `json:"imagePath"`
+	ImageData   *string           `json:"imageData"`
+	ImageHeight int               `json:"imageHeight"`
+	ImageWidth  int               `json:"imageWidth"`
+}
+
+type labelMeShape struct {
+	Label     string          `json:"label"`
+	Points    [][]float64     `json:"points"`
+	GroupID   *int            `json:"group_id"`
+	ShapeType string          `json:"shape_type"`
+	Flags     json.RawMessage `json:"flags"`
+}
+
+// emptyFlags are written for documents and shapes without flags
+var emptyFlags = json.RawMessage("{}")
+
+// parseLabelMe parses LabelMe json. Rectangles become objects, other shapes are skipped.
+// Filename is imagePath of document: path of image relative to json file.
+func parseLabelMe(data []byte) (*Annotation, error) {
+	doc := &labelMeDocument{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("error parsing labelme document: %w", err)
+	}
+
+	if doc.Shapes == nil {
+		return nil, fmt.Errorf("error parsing labelme document: shapes not found")
+	}
+
+	a := &Annotation{
+		Filename: doc.ImagePath,
+		Width:    doc.ImageWidth,
+		Height:   doc.ImageHeight,
+		Objects:  make([]Object, 0, len(doc.Shapes)),
+	}
+
+	for ind, raw := range doc.Shapes {
+		shape := &labelMeShape{}
+		if err := json.Unmarshal(raw, shape); err != nil {
+			return nil, fmt.Errorf("error parsing labelme shape %d: %w", ind, err)
+		}
+
+		// shape type is omitted by old versions of LabelMe for polygons
+		if shape.ShapeType != labelMeRectangle {
+			continue
+		}
+
+		o, err := shape.object()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing labelme shape %d: %w", ind, err)
+		}
+		a.Objects = append(a.Objects, o)
+	}
+
+	return a, nil
+}
+
+// object returns bounding box of rectangle. LabelMe keeps two opposite corners in order they were drawn.
+func (s *labelMeShape) object() (Object, error) {
+	if len(s.Points) < 2 {
+		return Object{}, fmt.Errorf("rectangle has %d points", len(s.Points))
+	}
+
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range s.Points {
+		if len(p) != 2 {
+			return Object{}, fmt.Errorf("point has %d coordinates", len(p))
+		}
+		minX, maxX = math.Min(minX, p[0]), math.Max(maxX, p[0])
+		minY, maxY = math.Min(minY, p[1]), math.Max(maxY, p[1])
+	}
+
+	return Object{
+		Label:  s.Label,
+		Left:   int(math.Round(minX)),
+		Top:    int(math.Round(minY)),
+		Right:  int(math.Round(maxX)),
+		Bottom: int(math.Round(maxY)),
+	}, nil
+}
+
+// ReadLabelMeFile reads and parses LabelMe json file. Only rectangles are read.
+func ReadLabelMeFile(jsonPath string) (*Annotation, error) {
+	data, err := ioutil.ReadFile(jsonPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading annotation: %w", err)
+	}
+
+	a, err := parseLabelMe(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", jsonPath, err)
+	}
+
+	return a, nil
+}
+
+// LabelMeWriter writes LabelMe json with rectangle shapes
+type LabelMeWriter struct{}
+
+func (LabelMeWriter) Format() string {
+	return FormatLabelMe
+}
+
+func (LabelMeWriter) Filename(imageFilename string) string {
+	return stem(imageFilename) + ".json"
+}
+
+func (w LabelMeWriter) Marshal(a *Annotation) ([]byte, error) {
+	return w.marshal(&labelMeDocument{Version: labelMeVersion, Flags: emptyFlags}, a)
+}
+
+// Update replaces rectangles of existing document. Other shapes, flags and version are kept.
+func (w LabelMeWriter) Update(existing []byte, a *Annotation) ([]byte, error) {
+	doc, err := parseWithoutRectangles(existing)
+	if err != nil {
+		return nil, err
+	}
+
+	if doc.Version == "" {
+		doc.Version = labelMeVersion
+	}
+	if len(doc.Flags) == 0 {
+		doc.Flags = emptyFlags
+	}
+
+	return w.marshal(doc, a)
+}
+
+// Strip removes rectangles of existing document. Nil is returned if there are no other shapes.
+func (LabelMeWriter) Strip(existing []byte) ([]byte, error) {
+	doc, err := parseWithoutRectangles(existing)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(doc.Shapes) == 0 {
+		return nil, nil
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// parseWithoutRectangles parses existing document and drops it's rectangles, which are objects of osp
+func parseWithoutRectangles(existing []byte) (*labelMeDocument, error) {
+	doc := &labelMeDocument{}
+	if err := json.Unmarshal(existing, doc); err != nil {
+		return nil, fmt.Errorf("error parsing existing labelme document: %w", err)
+	}
+
+	kept := make([]json.RawMessage, 0, len(doc.Shapes))
+	for ind, raw := range doc.Shapes {
+		shape := &labelMeShape{}
+		if err := json.Unmarshal(raw, shape); err != nil {
+			return nil, fmt.Errorf("error parsing labelme shape %d: %w", ind, err)
+		}
+		if shape.ShapeType != labelMeRectangle {
+			kept = append(kept, raw)
+		}
+	}
+	doc.Shapes = kept
+
+	return doc, nil
+}
+
+// marshal appends objects of annotation to doc as rectangles
+func (LabelMeWriter) marshal(doc *labelMeDocument, a *Annotation) ([]byte, error) {
+	doc.ImagePath = path.Base(a.Filename)
+	doc.ImageWidth, doc.ImageHeight = a.Width, a.Height
+	// embedded image could be rotated by osp, LabelMe loads image from imagePath without it
+	doc.ImageData = nil
+
+	for _, o := range a.Objects {
+		raw, err := json.Marshal(labelMeShape{
+			Label:     o.Label,
+			Points:    [][]float64{{float64(o.Left), float64(o.Top)}, {float64(o.Right), float64(o.Bottom)}},
+			ShapeType: labelMeRectangle,
+			Flags:     emptyFlags,
+		})
+		if err != nil {
+			return nil, err
+		}
+		doc.Shapes = append(doc.Shapes, raw)
+	}
+
+	if doc.Shapes == nil {
+		doc.Shapes = make([]json.RawMessage, 0)
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
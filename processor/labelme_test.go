@@ -0,0 +1,170 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testLabelMe = `{
+  "version": "4.5.6",
+  "flags": {"reviewed": true},
+  "shapes": [
+    {"label": "car", "points": [[5, 6], [1, 2]], "group_id": null, "shape_type": "rectangle", "flags": {}},
+    {"label": "road", "points": [[0, 0], [10, 0], [10, 10]], "group_id": 1, "shape_type": "polygon", "flags": {}},
+    {"label": "sign", "points": [[2.4, 2.6], [3.5, 7]], "shape_type": "rectangle"}
+  ],
+  "imagePath": "../images/1.png",
+  "imageData": "iVBORw0KGgo=",
+  "imageHeight": 10,
+  "imageWidth": 10
+}`
+
+func Test_parseLabelMe(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    *Annotation
+		wantErr bool
+	}{
+		{"ok", testLabelMe, &Annotation{Filename: "../images/1.png", Width: 10, Height: 10,
+			Objects: []Object{{"car", 1, 2, 5, 6}, {"sign", 2, 3, 4, 7}}}, false},
+		{"no shapes", `{"imagePath": "1.png", "shapes": []}`, &Annotation{Filename: "1.png", Objects: []Object{}}, false},
+		{"not labelme", `{"objects": []}`, nil, true},
+		{"broken", `{"shapes": [`, nil, true},
+		{"single point", `{"shapes": [{"label": "car", "points": [[1, 2]], "shape_type": "rectangle"}]}`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLabelMe([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLabelMe() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLabelMe() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLabelMeWriter_Update(t *testing.T) {
+	a := &Annotation{Filename: "cam/1.png", Width: 10, Height: 10, Objects: []Object{{"bike", 0, 0, 4, 4}}}
+
+	data, err := LabelMeWriter{}.Update([]byte(testLabelMe), a)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	doc := &labelMeDocument{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != "4.5.6" || doc.ImagePath != "1.png" || doc.ImageData != nil || !strings.Contains(string(doc.Flags), `"reviewed": true`) {
+		t.Errorf("document = %s", data)
+	}
+
+	// rectangles are replaced, polygon is kept
+	parsed, err := parseLabelMe(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.Objects, a.Objects) {
+		t.Errorf("objects = %v, want %v", parsed.Objects, a.Objects)
+	}
+	if len(doc.Shapes) != 2 || !strings.Contains(string(doc.Shapes[0]), `"polygon"`) {
+		t.Errorf("shapes = %s, want polygon and rectangle", data)
+	}
+
+	// written document is read back without loss of rectangles
+	data, err = LabelMeWriter{}.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed, err := parseLabelMe(data); err != nil || !reflect.DeepEqual(parsed.Objects, a.Objects) || parsed.Width != 10 {
+		t.Errorf("parseLabelMe(Marshal()) = %v, %v, want %v", parsed, err, a)
+	}
+}
+
+func TestLabelMeWriter_Strip(t *testing.T) {
+	data, err := LabelMeWriter{}.Strip([]byte(testLabelMe))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	doc := &labelMeDocument{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Shapes) != 1 || !strings.Contains(string(doc.Shapes[0]), `"polygon"`) || doc.ImageWidth != 10 {
+		t.Errorf("document = %s, want only polygon", data)
+	}
+
+	// document of osp boxes only is removed
+	written, err := LabelMeWriter{}.Marshal(&Annotation{Filename: "1.png", Width: 10, Height: 10, Objects: []Object{{"bike", 0, 0, 4, 4}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := (LabelMeWriter{}).Strip(written); err != nil || data != nil {
+		t.Errorf("Strip() = %s, %v, want nothing", data, err)
+	}
+}
+
+func Test_processorImpl_labelMe(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+	// LabelMe file next to unlabeled image is suggestions
+	jsonName := LabelMeWriter{}.Filename(inputFilename)
+	if err := ioutil.WriteFile(path.Join(unlabeled, jsonName), []byte(testLabelMe), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewImageProcessor(unlabeled, labeled, Options{Writers: []AnnotationWriter{VOCWriter{}, LabelMeWriter{}}})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+	defer p.Close()
+
+	suggestions, err := p.Suggestions(inputFilename)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	want := []Suggestion{{Object: Object{"car", 1, 2, 5, 6}}, {Object: Object{"sign", 2, 3, 4, 7}}}
+	if !reflect.DeepEqual(suggestions, want) {
+		t.Errorf("suggestions = %v, want %v", suggestions, want)
+	}
+
+	if _, err := p.ProcessImage(context.Background(), ImageRequest{inputFilename, 10, 10, []Object{{"car", 1, 2, 5, 6}}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// polygon drawn in LabelMe survives update in osp
+	labelMePath := path.Join(labeled, jsonName)
+	if err := ioutil.WriteFile(labelMePath, []byte(testLabelMe), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.UpdateImage(context.Background(), ImageRequest{inputFilename, 10, 10, []Object{{"bike", 0, 0, 4, 4}}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	a, err := ReadLabelMeFile(labelMePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Object{{"bike", 0, 0, 4, 4}}; !reflect.DeepEqual(a.Objects, want) || a.Filename != inputFilename {
+		t.Errorf("labelme annotation = %v, want %v of %s", a, want, inputFilename)
+	}
+	if data, _ := ioutil.ReadFile(labelMePath); !strings.Contains(string(data), `"polygon"`) {
+		t.Errorf("polygon was lost: %s", data)
+	}
+}
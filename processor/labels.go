@@ -0,0 +1,98 @@
+package processor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Label describes one of allowed object classes
+type Label struct {
+	Name        string `json:"name"`
+	Color       string `json:"color,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Key is keyboard shortcut for label in UI
+	Key string `json:"key,omitempty"`
+}
+
+// UnknownLabelError is returned when object label is not in configured label set
+type UnknownLabelError struct {
+	Label string
+}
+
+func (e *UnknownLabelError) Error() string {
+	return fmt.Sprintf("unknown label %q", e.Label)
+}
+
+// normalizeLabel trims label and, if label set is configured, replaces it with canonical name from that set.
+// Labels are matched case insensitive.
+func normalizeLabel(label string, labels []Label) (string, error) {
+	label = strings.Trim(label, " \n")
+	if label == "" {
+		return "", EmptyLabelError
+	}
+
+	if len(labels) == 0 {
+		// any label is allowed
+		return label, nil
+	}
+
+	for _, l := range labels {
+		if strings.EqualFold(l.Name, label) {
+			return l.Name, nil
+		}
+	}
+
+	return "", &UnknownLabelError{Label: label}
+}
+
+// ClassMap assigns ids to labels for formats that refer to classes by number (YOLO, COCO). Classes are configured
+// labels in their order, or labels found in annotations in alphabetical order if any label is allowed.
+// Labels are matched case insensitive, like normalizeLabel does.
+type ClassMap struct {
+	names []string
+	ids   map[string]int
+}
+
+// NewClassMap builds class map of configured labels. Annotations are used only if labels are empty.
+func NewClassMap(labels []Label, annotations ...*Annotation) *ClassMap {
+	names := make([]string, 0, len(labels))
+	for _, l := range labels {
+		names = append(names, l.Name)
+	}
+
+	if len(labels) == 0 {
+		for _, a := range annotations {
+			for _, o := range a.Objects {
+				names = append(names, o.Label)
+			}
+		}
+		sort.Strings(names)
+	}
+
+	m := &ClassMap{names: make([]string, 0, len(names)), ids: make(map[string]int, len(names))}
+	for _, name := range names {
+		key := strings.ToLower(name)
+		if _, ok := m.ids[key]; !ok {
+			m.ids[key] = len(m.names)
+			m.names = append(m.names, name)
+		}
+	}
+
+	return m
+}
+
+// Names returns class names, index of name is it's id
+func (m *ClassMap) Names() []string {
+	return m.names
+}
+
+// ID returns zero based id of label's class or UnknownLabelError
+func (m *ClassMap) ID(label string) (int, error) {
+	id, ok := m.ids[strings.ToLower(label)]
+	if !ok {
+		return 0, &UnknownLabelError{Label: label}
+	}
+
+	return id, nil
+}
@@ -0,0 +1,82 @@
+package processor
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func Test_normalizeLabel(t *testing.T) {
+	labels := []Label{{Name: "car"}, {Name: "Traffic light"}}
+
+	tests := []struct {
+		name   string
+		label  string
+		labels []Label
+		want   string
+		err    error
+	}{
+		{"empty", " ", labels, "", EmptyLabelError},
+		{"any label allowed", " Whatever\n", nil, "Whatever", nil},
+		{"exact", "car", labels, "car", nil},
+		{"case and spaces", "Car ", labels, "car", nil},
+		{"canonical case", "traffic LIGHT", labels, "Traffic light", nil},
+		{"unknown", "bike", labels, "", &UnknownLabelError{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeLabel(tt.label, tt.labels)
+
+			var unknown *UnknownLabelError
+			if _, ok := tt.err.(*UnknownLabelError); ok {
+				if !errors.As(err, &unknown) {
+					t.Fatalf("that should be UnknownLabelError but got %v", err)
+				}
+			} else if !errors.Is(err, tt.err) {
+				t.Fatalf("that should be error %v but got %v", tt.err, err)
+			}
+
+			if got != tt.want {
+				t.Errorf("normalizeLabel() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewClassMap(t *testing.T) {
+	annotations := []*Annotation{
+		{Objects: []Object{{"dog", 0, 0, 1, 1}, {"Cat", 0, 0, 1, 1}}},
+		{Objects: []Object{{"cat", 0, 0, 1, 1}, {"bird", 0, 0, 1, 1}}},
+	}
+
+	tests := []struct {
+		name   string
+		labels []Label
+		want   []string
+	}{
+		{"configured order", []Label{{Name: "dog"}, {Name: "cat"}}, []string{"dog", "cat"}},
+		{"alphabetical", nil, []string{"Cat", "bird", "dog"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewClassMap(tt.labels, annotations...)
+			if !reflect.DeepEqual(m.Names(), tt.want) {
+				t.Errorf("Names() = %v, want %v", m.Names(), tt.want)
+			}
+			for ind, name := range tt.want {
+				if id, err := m.ID(name); err != nil || id != ind {
+					t.Errorf("ID(%q) = %d, %v, want %d", name, id, err, ind)
+				}
+			}
+		})
+	}
+
+	var unknown *UnknownLabelError
+	m := NewClassMap([]Label{{Name: "dog"}}, annotations...)
+	if _, err := m.ID("CAT"); !errors.As(err, &unknown) {
+		t.Errorf("ID() error = %v, want UnknownLabelError", err)
+	}
+	if id, err := m.ID("DOG"); err != nil || id != 0 {
+		t.Errorf("ID() = %d, %v, want 0", id, err)
+	}
+}
@@ -0,0 +1,297 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"os"
+)
+
+// OrientationMode tells how images with EXIF orientation are stored.
+// Browser shows such images rotated, so boxes come from client in coordinates of rotated image.
+type OrientationMode string
+
+const (
+	// OrientationNormalize keeps image as is and converts boxes to it's raw pixel grid.
+	// Orientation is recorded in annotation, so boxes can be shown rotated again.
+	OrientationNormalize OrientationMode = "normalize"
+	// OrientationRotate physically rotates image (it's re-encoded without EXIF) and keeps boxes as they are
+	OrientationRotate OrientationMode = "rotate"
+)
+
+// EXIF orientations (see TIFF/EXIF specification, tag 0x0112)
+const (
+	OrientationNormal    = 1
+	OrientationTranspose = 5
+)
+
+// rotatedJPEGQuality is quality of images re-encoded by OrientationRotate
+const rotatedJPEGQuality = 95
+
+var BadExifError = errors.New("bad exif data")
+
+const (
+	markerPrefix = 0xff
+	markerSOI    = 0xd8
+	markerAPP1   = 0xe1
+	markerSOS    = 0xda
+	markerEOI    = 0xd9
+
+	exifOrientationTag = 0x0112
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// ReadOrientation returns EXIF orientation of image (1..8). Images without EXIF (including not jpeg ones) have
+// OrientationNormal.
+func ReadOrientation(imagePath string) (int, error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return 0, fmt.Errorf("error opening image: %w", err)
+	}
+	defer file.Close()
+
+	return readOrientation(bufio.NewReader(file))
+}
+
+// readOrientation looks for EXIF segment among jpeg markers before image data
+func readOrientation(r io.Reader) (int, error) {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi[0] != markerPrefix || soi[1] != markerSOI {
+		// not a jpeg
+		return OrientationNormal, nil
+	}
+
+	for {
+		var marker [4]byte
+		if _, err := io.ReadFull(r, marker[:2]); err != nil {
+			return OrientationNormal, nil
+		}
+		if marker[0] != markerPrefix {
+			return 0, fmt.Errorf("%w: bad jpeg marker", BadExifError)
+		}
+		if marker[1] == markerPrefix {
+			// padding
+			continue
+		}
+		if marker[1] == markerSOS || marker[1] == markerEOI {
+			// image data begins, there is no exif
+			return OrientationNormal, nil
+		}
+
+		if _, err := io.ReadFull(r, marker[2:]); err != nil {
+			return OrientationNormal, nil
+		}
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return 0, fmt.Errorf("%w: bad segment length", BadExifError)
+		}
+
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return OrientationNormal, nil
+		}
+
+		if marker[1] == markerAPP1 && bytes.HasPrefix(segment, exifHeader) {
+			return parseExifOrientation(segment[len(exifHeader):])
+		}
+	}
+}
+
+// parseExifOrientation finds orientation tag in the first IFD of TIFF structure
+func parseExifOrientation(tiff []byte) (int, error) {
+	if len(tiff) < 8 {
+		return 0, fmt.Errorf("%w: short tiff header", BadExifError)
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, fmt.Errorf("%w: unknown byte order", BadExifError)
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0, fmt.Errorf("%w: bad ifd offset", BadExifError)
+	}
+
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0, fmt.Errorf("%w: truncated ifd", BadExifError)
+		}
+
+		if order.Uint16(tiff[entry:]) != exifOrientationTag {
+			continue
+		}
+
+		// orientation is SHORT stored in the beginning of value field
+		orientation := int(order.Uint16(tiff[entry+8:]))
+		if orientation < 1 || orientation > 8 {
+			return 0, fmt.Errorf("%w: bad orientation %d", BadExifError, orientation)
+		}
+
+		return orientation, nil
+	}
+
+	return OrientationNormal, nil
+}
+
+// orientedSize returns size of image as it's shown (rotated according to orientation)
+func orientedSize(width, height, orientation int) (int, int) {
+	if orientation >= OrientationTranspose {
+		return height, width
+	}
+
+	return width, height
+}
+
+// orientPoint converts point of raw image of width x height to coordinates of image shown with orientation
+func orientPoint(x, y, width, height, orientation int) (int, int) {
+	switch orientation {
+	case 2:
+		return width - x, y
+	case 3:
+		return width - x, height - y
+	case 4:
+		return x, height - y
+	case 5:
+		return y, x
+	case 6:
+		return height - y, x
+	case 7:
+		return height - y, width - x
+	case 8:
+		return y, width - x
+	}
+
+	return x, y
+}
+
+// unorientPoint converts point of shown image to coordinates of raw image of width x height
+func unorientPoint(u, v, width, height, orientation int) (int, int) {
+	switch orientation {
+	case 2:
+		return width - u, v
+	case 3:
+		return width - u, height - v
+	case 4:
+		return u, height - v
+	case 5:
+		return v, u
+	case 6:
+		return v, height - u
+	case 7:
+		return width - v, height - u
+	case 8:
+		return width - v, u
+	}
+
+	return u, v
+}
+
+// transformBox converts box corners with convert and makes left/top the smallest coordinates again
+func transformBox(o Object, convert func(x, y int) (int, int)) Object {
+	l, t := convert(o.Left, o.Top)
+	r, b := convert(o.Right, o.Bottom)
+
+	o.Left, o.Right = minInt(l, r), maxInt(l, r)
+	o.Top, o.Bottom = minInt(t, b), maxInt(t, b)
+
+	return o
+}
+
+// OrientObject converts box of raw image of width x height to coordinates of image shown with orientation
+func OrientObject(o Object, width, height, orientation int) Object {
+	return transformBox(o, func(x, y int) (int, int) {
+		return orientPoint(x, y, width, height, orientation)
+	})
+}
+
+// unorientObject converts box of shown image to coordinates of raw image of width x height
+func unorientObject(o Object, width, height, orientation int) Object {
+	return transformBox(o, func(x, y int) (int, int) {
+		return unorientPoint(x, y, width, height, orientation)
+	})
+}
+
+// Orient returns copy of img rotated and flipped as it should be shown according to orientation
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation <= OrientationNormal || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	dstWidth, dstHeight := orientedSize(width, height, orientation)
+	rect := image.Rect(0, 0, dstWidth, dstHeight)
+
+	// grayscale images stay grayscale, all others are converted to RGBA
+	var dst draw.Image
+	if _, ok := img.(*image.Gray); ok {
+		dst = image.NewGray(rect)
+	} else {
+		dst = image.NewRGBA(rect)
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// pixel occupies [x, x+1), so it's far corner is used for flipped axes
+			u, v := orientPoint(x, y, width-1, height-1, orientation)
+			dst.Set(u, v, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+
+	return dst
+}
+
+// rotateImageFile replaces jpeg image with copy rotated according to it's orientation. Result has no EXIF.
+func rotateImageFile(imagePath string, orientation int) error {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return fmt.Errorf("error opening image: %w", err)
+	}
+
+	img, _, err := image.Decode(file)
+	_ = file.Close()
+	if err != nil {
+		return fmt.Errorf("%w (%s: %v)", UnsupportedImageError, imagePath, err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, Orient(img, orientation), &jpeg.Options{Quality: rotatedJPEGQuality}); err != nil {
+		return fmt.Errorf("error encoding rotated image: %w", err)
+	}
+
+	return writeFileAtomic(imagePath, buf.Bytes())
+}
+
+// validOrientationMode reports whether mode is known
+func validOrientationMode(mode OrientationMode) bool {
+	return mode == OrientationNormalize || mode == OrientationRotate
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
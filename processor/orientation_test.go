@@ -0,0 +1,207 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// jpegWithOrientation encodes img as jpeg with exif segment containing orientation
+func jpegWithOrientation(t *testing.T, img image.Image, orientation int, order binary.ByteOrder) []byte {
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// tiff header, ifd with single entry and next ifd offset
+	tiff := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], exifOrientationTag)
+	order.PutUint16(tiff[12:], 3) // SHORT
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], uint16(orientation))
+
+	segment := append(append([]byte{}, exifHeader...), tiff...)
+	app1 := []byte{markerPrefix, markerAPP1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+
+	res := append([]byte{}, data[:2]...)
+	res = append(res, app1...)
+	res = append(res, segment...)
+	return append(res, data[2:]...)
+}
+
+func Test_readOrientation(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	plain := &bytes.Buffer{}
+	_ = jpeg.Encode(plain, img, nil)
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+		err  error
+	}{
+		{"little endian", jpegWithOrientation(t, img, 6, binary.LittleEndian), 6, nil},
+		{"big endian", jpegWithOrientation(t, img, 3, binary.BigEndian), 3, nil},
+		{"no exif", plain.Bytes(), OrientationNormal, nil},
+		{"not jpeg", []byte("\x89PNG\r\n"), OrientationNormal, nil},
+		{"bad orientation", jpegWithOrientation(t, img, 9, binary.BigEndian), 0, BadExifError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readOrientation(bytes.NewReader(tt.data))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("readOrientation() error = %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("readOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrientObject(t *testing.T) {
+	// raw image 10x6, box near it's top left corner
+	raw := Object{Label: "car", Left: 1, Top: 0, Right: 4, Bottom: 2}
+
+	tests := []struct {
+		orientation int
+		want        Object
+	}{
+		{1, Object{Label: "car", Left: 1, Top: 0, Right: 4, Bottom: 2}},
+		{2, Object{Label: "car", Left: 6, Top: 0, Right: 9, Bottom: 2}},
+		{3, Object{Label: "car", Left: 6, Top: 4, Right: 9, Bottom: 6}},
+		{4, Object{Label: "car", Left: 1, Top: 4, Right: 4, Bottom: 6}},
+		{5, Object{Label: "car", Left: 0, Top: 1, Right: 2, Bottom: 4}},
+		{6, Object{Label: "car", Left: 4, Top: 1, Right: 6, Bottom: 4}},
+		{7, Object{Label: "car", Left: 4, Top: 6, Right: 6, Bottom: 9}},
+		{8, Object{Label: "car", Left: 0, Top: 6, Right: 2, Bottom: 9}},
+	}
+	for _, tt := range tests {
+		got := OrientObject(raw, 10, 6, tt.orientation)
+		if got != tt.want {
+			t.Errorf("orientation %d: OrientObject() = %v, want %v", tt.orientation, got, tt.want)
+		}
+		if back := unorientObject(got, 10, 6, tt.orientation); back != raw {
+			t.Errorf("orientation %d: unorientObject() = %v, want %v", tt.orientation, back, raw)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// 3x2 image with marked top left pixel
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	img.Set(0, 0, color.White)
+
+	tests := []struct {
+		orientation int
+		w, h, x, y  int
+	}{
+		{1, 3, 2, 0, 0},
+		{2, 3, 2, 2, 0},
+		{3, 3, 2, 2, 1},
+		{6, 2, 3, 1, 0},
+		{8, 2, 3, 0, 2},
+	}
+	for _, tt := range tests {
+		got := Orient(img, tt.orientation)
+		if b := got.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("orientation %d: size = %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.w, tt.h)
+			continue
+		}
+		if r, _, _, _ := got.At(tt.x, tt.y).RGBA(); r != 0xffff {
+			t.Errorf("orientation %d: marked pixel should be at %d,%d", tt.orientation, tt.x, tt.y)
+		}
+	}
+}
+
+func Test_processorImpl_orientation(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	// raw image is 10x6, browser shows it rotated as 6x10
+	data := jpegWithOrientation(t, image.NewRGBA(image.Rect(0, 0, 10, 6)), 6, binary.BigEndian)
+	shown := []Object{{"car", 0, 0, 3, 5}}
+
+	tests := []struct {
+		mode          OrientationMode
+		width, height int
+		orientation   int
+		objects       []Object
+	}{
+		{OrientationNormalize, 10, 6, 6, []Object{{"car", 0, 3, 5, 6}}},
+		{OrientationRotate, 6, 10, OrientationNormal, shown},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			unlabeled, labeled, _ := setupTempDir(tempDir)
+			if err := ioutil.WriteFile(path.Join(unlabeled, "photo.jpg"), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			p, err := NewImageProcessor(unlabeled, labeled, Options{OrientationMode: tt.mode})
+			if err != nil {
+				t.Fatalf("error creating new image processor: %v", err)
+			}
+			defer p.Close()
+
+			// client reports size of rotated image
+			if _, err := p.ProcessImage(context.Background(), ImageRequest{"photo.jpg", 6, 10, shown}); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			annotation, err := ReadAnnotation(labeled, "photo.jpg")
+			if err != nil {
+				t.Fatalf("error reading annotation: %v", err)
+			}
+			if annotation.Width != tt.width || annotation.Height != tt.height || annotation.Orientation != tt.orientation {
+				t.Errorf("annotation is %dx%d with orientation %d, want %dx%d with %d",
+					annotation.Width, annotation.Height, annotation.Orientation, tt.width, tt.height, tt.orientation)
+			}
+			if !reflect.DeepEqual(annotation.Objects, tt.objects) {
+				t.Errorf("objects = %v, want %v", annotation.Objects, tt.objects)
+			}
+
+			// editor gets boxes as they were drawn
+			if oriented := annotation.Oriented(); !reflect.DeepEqual(oriented.Objects, shown) || oriented.Width != 6 {
+				t.Errorf("oriented annotation = %v, want objects %v of 6x10", oriented, shown)
+			}
+
+			info, err := readImageInfo(path.Join(labeled, "photo.jpg"))
+			if err != nil {
+				t.Fatalf("error reading labeled image: %v", err)
+			}
+			orientation, _ := ReadOrientation(path.Join(labeled, "photo.jpg"))
+			if info.Width != tt.width || info.Height != tt.height || orientation != tt.orientation {
+				t.Errorf("labeled image is %dx%d with orientation %d", info.Width, info.Height, orientation)
+			}
+		})
+	}
+
+	unlabeled, labeled, _ := setupTempDir(tempDir)
+	if _, err := NewImageProcessor(unlabeled, labeled, Options{OrientationMode: "flip"}); err == nil {
+		t.Error("unknown orientation mode should be rejected")
+	}
+}
@@ -0,0 +1,245 @@
+package processor
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/porfirion/osp/safepath"
+)
+
+var xmlHeader = []byte("<?xml version=\"1.0\"?>\n")
+
+type pascalvoc struct {
+	XMLName  xml.Name `xml:"annotation"`
+	Folder   string   `xml:"folder"`
+	Filename string   `xml:"filename"`
+	Path     string   `xml:"path"`
+	Database string   `xml:"source>database"`
+
+	Width  int `xml:"size>width"`
+	Height int `xml:"size>height"`
+	Depth  int `xml:"size>depth"`
+
+	Segmented int `xml:"segmented"`
+
+	// Orientation is EXIF orientation of image. Boxes are always in coordinates of raw (not rotated) image.
+	// It's not a part of Pascal VOC format, but other tools just ignore it.
+	Orientation int `xml:"orientation,omitempty"`
+
+	Objects []pascalvocObject `xml:"object"`
+}
+
+type pascalvocObject struct {
+	Name      string `xml:"name"`
+	Pose      string `xml:"pose"`
+	Truncated int    `xml:"truncated"`
+	Difficult int    `xml:"difficult"`
+	Xmin      int    `xml:"bndbox>xmin"`
+	Ymin      int    `xml:"bndbox>ymin"`
+	Xmax      int    `xml:"bndbox>xmax"`
+	Ymax      int    `xml:"bndbox>ymax"`
+
+	// Confidence and Origin are recorded for images labeled with suggestions (see OriginAccepted and others).
+	// They are not a part of Pascal VOC format too.
+	Confidence float64 `xml:"confidence,omitempty"`
+	Origin     string  `xml:"origin,omitempty"`
+}
+
+// Annotation is a parsed Pascal VOC document of a single image
+type Annotation struct {
+	// Filename of image. It's path relative to labeled path if annotation is read with ReadAnnotation
+	// and just file name if it's read with ReadAnnotationFile
+	Filename string `json:"filename"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Depth    int    `json:"depth"`
+	// Orientation is EXIF orientation of image (zero if unknown). Objects are in coordinates of raw image
+	Orientation int      `json:"orientation,omitempty"`
+	Objects     []Object `json:"objects"`
+
+	// Folder and Path locate image like in Pascal VOC document (empty if unknown)
+	Folder string `json:"-"`
+	Path   string `json:"-"`
+	// Origins and Confidences of objects, empty if they weren't recorded (see OriginAccepted and others)
+	Origins     []string  `json:"-"`
+	Confidences []float64 `json:"-"`
+}
+
+// AnnotationFilename returns name of annotation xml for specified image
+func AnnotationFilename(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + ".xml"
+}
+
+// ReadAnnotation reads and parses Pascal VOC xml of specified image from dir.
+// Filename is path relative to dir and may contain sub directories.
+func ReadAnnotation(dir, filename string) (*Annotation, error) {
+	xmlPath, err := safepath.Resolve(dir, AnnotationFilename(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := ReadAnnotationFile(xmlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Filename != "" {
+		// document keeps only file name, sub directory is known from location of annotation
+		a.Filename = path.Join(path.Dir(filename), path.Base(a.Filename))
+	}
+
+	return a, nil
+}
+
+// WriteAnnotation writes annotation as Pascal VOC xml into dir next to it's image.
+// Filename of annotation is path of image relative to dir, objects are in coordinates of raw image.
+func WriteAnnotation(dir string, a *Annotation) error {
+	imagePath, err := safepath.Resolve(dir, a.Filename)
+	if err != nil {
+		return err
+	}
+
+	xmlPath, err := safepath.Resolve(dir, AnnotationFilename(a.Filename))
+	if err != nil {
+		return err
+	}
+
+	located := *a
+	located.Folder = path.Dir(a.Filename)
+	if located.Folder == "." {
+		located.Folder = filepath.Base(dir)
+	}
+	located.Path = imagePath
+
+	if err := os.MkdirAll(filepath.Dir(xmlPath), 0755); err != nil {
+		return fmt.Errorf("error creating annotation directory: %w", err)
+	}
+
+	_, err = writeDocument(xmlPath, newPascalvoc(&located))
+	return err
+}
+
+// ReadAnnotationFile reads and parses Pascal VOC xml file
+func ReadAnnotationFile(xmlPath string) (*Annotation, error) {
+	data, err := ioutil.ReadFile(xmlPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading annotation: %w", err)
+	}
+
+	doc := &pascalvoc{}
+	if err := xml.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("error parsing annotation %s: %w", xmlPath, err)
+	}
+
+	return doc.annotation(), nil
+}
+
+func (doc *pascalvoc) annotation() *Annotation {
+	a := &Annotation{
+		Filename: doc.Filename,
+		Width:    doc.Width,
+		Height:   doc.Height,
+		Depth:    doc.Depth,
+
+		Orientation: doc.Orientation,
+		Objects:     make([]Object, 0, len(doc.Objects)),
+
+		Folder: doc.Folder,
+		Path:   doc.Path,
+	}
+
+	recorded := false
+	for _, o := range doc.Objects {
+		a.Objects = append(a.Objects, Object{
+			Label:  o.Name,
+			Left:   o.Xmin,
+			Top:    o.Ymin,
+			Right:  o.Xmax,
+			Bottom: o.Ymax,
+		})
+		recorded = recorded || o.Origin != ""
+	}
+
+	if recorded {
+		for _, o := range doc.Objects {
+			a.Origins = append(a.Origins, o.Origin)
+			a.Confidences = append(a.Confidences, o.Confidence)
+		}
+	}
+
+	return a
+}
+
+// newPascalvoc builds Pascal VOC document of annotation. Only file name of image is kept, sub directory is folder.
+func newPascalvoc(a *Annotation) *pascalvoc {
+	doc := &pascalvoc{
+		Folder:   a.Folder,
+		Filename: path.Base(a.Filename),
+		Path:     a.Path,
+		Database: "Unknown",
+
+		Width:  a.Width,
+		Height: a.Height,
+		Depth:  a.Depth,
+
+		Orientation: a.Orientation,
+
+		Objects: make([]pascalvocObject, 0, len(a.Objects)),
+	}
+
+	for ind, o := range a.Objects {
+		obj := newPascalvocObject(o)
+		if len(a.Origins) == len(a.Objects) {
+			obj.Origin = a.Origins[ind]
+		}
+		if len(a.Confidences) == len(a.Objects) {
+			obj.Confidence = a.Confidences[ind]
+		}
+		doc.Objects = append(doc.Objects, obj)
+	}
+
+	return doc
+}
+
+// Oriented returns copy of annotation in coordinates of image shown according to it's orientation,
+// like browser shows it. Width and height are swapped for rotated images.
+func (a *Annotation) Oriented() *Annotation {
+	res := *a
+	res.Width, res.Height = orientedSize(a.Width, a.Height, a.Orientation)
+
+	res.Objects = make([]Object, 0, len(a.Objects))
+	for _, o := range a.Objects {
+		res.Objects = append(res.Objects, OrientObject(o, a.Width, a.Height, a.Orientation))
+	}
+
+	return &res
+}
+
+func newPascalvocObject(o Object) pascalvocObject {
+	return pascalvocObject{
+		Name:      o.Label,
+		Pose:      "Unspecified",
+		Truncated: 0,
+		Difficult: 0,
+
+		Xmin: o.Left,
+		Ymin: o.Top,
+		Xmax: o.Right,
+		Ymax: o.Bottom,
+	}
+}
+
+// marshal returns full xml document including header
+func (doc *pascalvoc) marshal() ([]byte, error) {
+	output, err := xml.MarshalIndent(doc, "  ", "    ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling document: %w", err)
+	}
+
+	return append(append([]byte{}, xmlHeader...), output...), nil
+}
@@ -0,0 +1,159 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/porfirion/osp/safepath"
+)
+
+var RejectDisabledError = errors.New("rejected path is not configured")
+var RejectedExistsError = errors.New("rejected image with the same name already exists")
+
+// skippedFilename is list of skipped images kept in unlabeled path. It's hidden, so it isn't listed as image
+const skippedFilename = ".skipped"
+
+// reasonSuffix is appended to name of rejected image to get name of file with reason of rejection
+const reasonSuffix = ".reason.txt"
+
+// skipList is persistent set of skipped images. It's changed by processing goroutine and read by others
+type skipList struct {
+	mu    sync.RWMutex
+	path  string
+	names map[string]bool
+}
+
+// loadSkipList reads list of skipped images from dir. Images that don't exist anymore are dropped.
+func loadSkipList(dir string) (*skipList, error) {
+	l := &skipList{path: filepath.Join(dir, skippedFilename), names: make(map[string]bool)}
+
+	data, err := ioutil.ReadFile(l.path)
+	if os.IsNotExist(err) {
+		return l, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading skipped images: %w", err)
+	}
+
+	for _, name := range strings.Split(string(data), "\n") {
+		if name == "" {
+			continue
+		}
+		if imagePath, err := safepath.Resolve(dir, name); err == nil && fileExists(imagePath) {
+			l.names[name] = true
+		}
+	}
+
+	return l, nil
+}
+
+func (l *skipList) contains(filename string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.names[filename]
+}
+
+// set adds or removes image and saves list if it was changed
+func (l *skipList) set(filename string, skipped bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.names[filename] == skipped {
+		return nil
+	}
+
+	if skipped {
+		l.names[filename] = true
+	} else {
+		delete(l.names, filename)
+	}
+
+	names := make([]string, 0, len(l.names))
+	for name := range l.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	content := ""
+	if len(names) > 0 {
+		content = strings.Join(names, "\n") + "\n"
+	}
+
+	if err := writeFileAtomic(l.path, []byte(content)); err != nil {
+		return fmt.Errorf("error saving skipped images: %w", err)
+	}
+
+	return nil
+}
+
+func (p *processorImpl) IsSkipped(filename string) bool {
+	return p.skipped.contains(filename)
+}
+
+// skipImage marks unlabeled image as skipped. It stays in unlabeled path, but isn't offered first anymore.
+func (p *processorImpl) skipImage(c Command, paths imagePaths) (interface{}, error) {
+	if !fileExists(paths.unlabeled) {
+		return nil, fmt.Errorf("%w (%s)", MissingInputFileError, paths.unlabeled)
+	}
+
+	if err := p.skipped.set(c.Filename, true); err != nil {
+		return nil, err
+	}
+
+	return true, nil
+}
+
+// rejectImage moves unlabeled image to rejected path and writes reason of rejection next to it
+func (p *processorImpl) rejectImage(c Command, paths imagePaths) (interface{}, error) {
+	if p.options.RejectedPath == "" {
+		return nil, RejectDisabledError
+	}
+
+	oldFilePath := paths.unlabeled
+	if !fileExists(oldFilePath) {
+		return nil, fmt.Errorf("%w (%s)", MissingInputFileError, oldFilePath)
+	}
+
+	newFilePath, err := safepath.Resolve(p.options.RejectedPath, c.Filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if fileExists(newFilePath) {
+		return nil, fmt.Errorf("%w (%s)", RejectedExistsError, c.Filename)
+	}
+
+	newDir := filepath.Dir(newFilePath)
+	if err := os.MkdirAll(newDir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating rejected directory: %w", err)
+	}
+
+	// reason is written first, so rejected image always has it
+	reasonPath := newFilePath + reasonSuffix
+	if err := writeFileAtomic(reasonPath, []byte(strings.TrimSpace(c.Reason)+"\n")); err != nil {
+		return nil, fmt.Errorf("error writing reason: %w", err)
+	}
+
+	if err := os.Rename(oldFilePath, newFilePath); err != nil {
+		if rmErr := os.Remove(reasonPath); rmErr != nil {
+			logger.Printf("error removing reason %s after failed move: %v\n", reasonPath, rmErr)
+		}
+		return nil, fmt.Errorf("error moving image: %w", err)
+	}
+
+	syncDir(filepath.Dir(oldFilePath))
+	syncDir(newDir)
+	p.moved(Move{From: c.Filename, To: c.Filename, Rejected: true})
+
+	if err := p.skipped.set(c.Filename, false); err != nil {
+		logger.Printf("error removing %s from skipped: %v\n", c.Filename, err)
+	}
+
+	return true, nil
+}
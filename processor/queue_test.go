@@ -0,0 +1,153 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_processorImpl_skipImage(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+	p, err := NewImageProcessor(unlabeled, labeled, Options{})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+	defer p.Close()
+
+	if _, err := p.SkipImage(context.Background(), "missing.png"); !errors.Is(err, MissingInputFileError) {
+		t.Fatalf("skipping missing image should fail with %v but got %v", MissingInputFileError, err)
+	}
+
+	if _, err := p.SkipImage(context.Background(), inputFilename); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !p.IsSkipped(inputFilename) {
+		t.Errorf("image should be skipped")
+	}
+
+	// list of skipped images survives restart
+	reloaded, err := loadSkipList(unlabeled)
+	if err != nil {
+		t.Fatalf("error loading skipped images: %v", err)
+	}
+	if !reloaded.contains(inputFilename) {
+		t.Errorf("skipped image should be saved")
+	}
+
+	if _, err := p.ProcessImage(context.Background(), ImageRequest{inputFilename, 10, 10, []Object{{"car", 1, 2, 3, 4}}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if p.IsSkipped(inputFilename) {
+		t.Errorf("labeled image shouldn't be skipped anymore")
+	}
+
+	// images that were moved away by others are forgotten
+	copyTestImage(t, path.Join(unlabeled, "gone.png"))
+	if _, err := p.SkipImage(context.Background(), "gone.png"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	_ = os.Remove(path.Join(unlabeled, "gone.png"))
+	if reloaded, err = loadSkipList(unlabeled); err != nil {
+		t.Fatalf("error loading skipped images: %v", err)
+	}
+	if reloaded.contains("gone.png") {
+		t.Errorf("missing image shouldn't be loaded as skipped")
+	}
+}
+
+func Test_processorImpl_rejectImage(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+	disabled, err := NewImageProcessor(unlabeled, labeled, Options{})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+	defer disabled.Close()
+	if _, err := disabled.RejectImage(context.Background(), inputFilename, "blurred"); !errors.Is(err, RejectDisabledError) {
+		t.Fatalf("rejecting without rejected path should fail with %v but got %v", RejectDisabledError, err)
+	}
+	disabled.Close()
+
+	rejected := path.Join(tempDir, "rejected")
+	var moves []Move
+	p, err := NewImageProcessor(unlabeled, labeled, Options{
+		RejectedPath: rejected,
+		OnMove:       func(m Move) { moves = append(moves, m) },
+	})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+	defer p.Close()
+
+	if _, err := p.SkipImage(context.Background(), inputFilename); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := p.RejectImage(context.Background(), inputFilename, " blurred \n"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if _, err := os.Stat(path.Join(unlabeled, inputFilename)); !os.IsNotExist(err) {
+		t.Errorf("image should be moved from unlabeled, got %v", err)
+	}
+	if _, err := os.Stat(path.Join(rejected, inputFilename)); err != nil {
+		t.Errorf("image should be moved to rejected: %v", err)
+	}
+	if reason, err := ioutil.ReadFile(path.Join(rejected, inputFilename+reasonSuffix)); err != nil || string(reason) != "blurred\n" {
+		t.Errorf("reason = %q (%v), want %q", reason, err, "blurred\n")
+	}
+	if p.IsSkipped(inputFilename) {
+		t.Errorf("rejected image shouldn't be skipped anymore")
+	}
+	if want := (Move{From: inputFilename, To: inputFilename, Rejected: true}); len(moves) != 1 || moves[0] != want {
+		t.Errorf("moves = %v, want [%v]", moves, want)
+	}
+
+	copyTestImage(t, path.Join(unlabeled, inputFilename))
+	if _, err := p.RejectImage(context.Background(), inputFilename, "again"); !errors.Is(err, RejectedExistsError) {
+		t.Errorf("rejecting the same name again should fail with %v but got %v", RejectedExistsError, err)
+	}
+}
+
+func Test_processorImpl_MarkNegative(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+	p, err := NewImageProcessor(unlabeled, labeled, Options{})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+	defer p.Close()
+
+	if _, err := p.MarkNegative(context.Background(), ImageRequest{Filename: inputFilename, Width: 10, Height: 10}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	annotation, err := ReadAnnotation(labeled, inputFilename)
+	if err != nil {
+		t.Fatalf("error reading annotation: %v", err)
+	}
+	if len(annotation.Objects) != 0 || annotation.Width != 10 || annotation.Height != 10 {
+		t.Errorf("negative annotation = %v, want 10x10 without objects", annotation)
+	}
+}
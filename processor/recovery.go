@@ -0,0 +1,164 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// brokenAnnotationSuffix is appended to annotations that can't be parsed during recovery
+const brokenAnnotationSuffix = ".broken"
+
+// recoverPairs repairs image/annotation pairs left half-finished by crash or failed command.
+// Annotation is written before image is moved, so existing annotation means that image was labeled:
+//   - temporary files of interrupted writes are removed;
+//   - annotation whose image is still in unlabeled path gets it's image moved to labeled path;
+//   - labeled image without annotation is moved back to unlabeled path (only files with allowed extensions are images);
+//   - annotation that can't be parsed is renamed with brokenAnnotationSuffix;
+//   - backups of replaced images are removed: saves made before restart can't be undone.
+//
+// Whole labeled tree is checked, images in sub directories are moved to the same sub directories.
+func (p *processorImpl) recoverPairs() error {
+	if err := os.RemoveAll(filepath.Join(p.labeledPath, backupDir)); err != nil {
+		return fmt.Errorf("error removing backups: %w", err)
+	}
+
+	files, err := p.listLabeledTree()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range files {
+		filePath := filepath.Join(p.labeledPath, filepath.FromSlash(name))
+
+		if isTempFile(path.Base(name)) {
+			logger.Printf("recovery: removing temp file %s\n", filePath)
+			if err := os.Remove(filePath); err != nil {
+				return fmt.Errorf("error removing temp file: %w", err)
+			}
+			continue
+		}
+
+		if strings.ToLower(path.Ext(name)) != ".xml" {
+			continue
+		}
+
+		annotation, err := ReadAnnotationFile(filePath)
+		if err != nil {
+			logger.Printf("recovery: broken annotation %s: %v\n", filePath, err)
+			if err := os.Rename(filePath, filePath+brokenAnnotationSuffix); err != nil {
+				return fmt.Errorf("error renaming broken annotation: %w", err)
+			}
+			continue
+		}
+
+		// image lies in the same sub directory as it's annotation
+		imageName := path.Join(path.Dir(name), path.Base(annotation.Filename))
+		paths, err := p.resolvePaths(imageName)
+		if err != nil {
+			logger.Printf("recovery: annotation %s points to bad image: %v\n", filePath, err)
+			continue
+		}
+
+		if fileExists(paths.labeled) {
+			// consistent pair
+			continue
+		}
+
+		unlabeledImage := paths.unlabeled
+		if !fileExists(unlabeledImage) {
+			logger.Printf("recovery: image %s of annotation %s not found\n", imageName, filePath)
+			continue
+		}
+
+		logger.Printf("recovery: finishing labeling of %s\n", imageName)
+		if err := os.Rename(unlabeledImage, paths.labeled); err != nil {
+			return fmt.Errorf("error moving image: %w", err)
+		}
+		p.moved(Move{From: imageName, To: imageName, ToLabeled: true})
+	}
+
+	// list again: some annotations could be renamed and some images moved
+	if files, err = p.listLabeledTree(); err != nil {
+		return err
+	}
+
+	for _, name := range files {
+		base := path.Base(name)
+		ext := strings.ToLower(path.Ext(base))
+		if strings.HasPrefix(base, ".") || ext == ".xml" || ext == brokenAnnotationSuffix {
+			continue
+		}
+
+		// annotations of other formats (see AnnotationWriter) and other files aren't images
+		if !HasImageExtension(name, ImageExtensions(p.options.Extensions)) {
+			continue
+		}
+
+		labeledImage := filepath.Join(p.labeledPath, filepath.FromSlash(name))
+		if fileExists(filepath.Join(p.labeledPath, filepath.FromSlash(AnnotationFilename(name)))) {
+			continue
+		}
+
+		unlabeledImage := filepath.Join(p.unlabeledPath, filepath.FromSlash(name))
+		if fileExists(unlabeledImage) {
+			logger.Printf("recovery: image %s without annotation exists in both paths, leaving it as is\n", name)
+			continue
+		}
+
+		logger.Printf("recovery: moving image %s without annotation back to unlabeled\n", name)
+		if err := os.MkdirAll(filepath.Dir(unlabeledImage), 0755); err != nil {
+			return fmt.Errorf("error creating unlabeled directory: %w", err)
+		}
+		if err := os.Rename(labeledImage, unlabeledImage); err != nil {
+			return fmt.Errorf("error moving image: %w", err)
+		}
+		removeEmptyDirs(p.labeledPath, filepath.Dir(labeledImage))
+		p.moved(Move{From: name, To: name, ToLabeled: false})
+	}
+
+	syncDir(p.labeledPath)
+	syncDir(p.unlabeledPath)
+
+	return nil
+}
+
+// listLabeledTree returns paths of all files in labeled tree relative to it (including hidden ones,
+// because temporary files are hidden). Hidden directories are skipped.
+func (p *processorImpl) listLabeledTree() ([]string, error) {
+	files := make([]string, 0)
+
+	err := filepath.Walk(p.labeledPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if filePath != p.labeledPath && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(p.labeledPath, filePath)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, filepath.ToSlash(rel))
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading labeled path: %w", err)
+	}
+
+	return files, nil
+}
+
+func fileExists(filePath string) bool {
+	info, err := os.Stat(filePath)
+	return err == nil && !info.IsDir()
+}
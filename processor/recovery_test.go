@@ -0,0 +1,128 @@
+package processor
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_processorImpl_recoverPairs(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, _ := setupTempDir(tempDir)
+
+	annotation := func(filename string) string {
+		return `<?xml version="1.0"?><annotation><filename>` + filename + `</filename></annotation>`
+	}
+
+	files := map[string]string{
+		// consistent pair
+		path.Join(labeled, "ok.png"): "",
+		path.Join(labeled, "ok.xml"): annotation("ok.png"),
+		// annotation of other format isn't an image
+		path.Join(labeled, "ok.json"): "{}",
+		// crashed after writing annotation
+		path.Join(unlabeled, "moved.png"): "",
+		path.Join(labeled, "moved.xml"):   annotation("moved.png"),
+		// crashed after removing annotation on unlabel
+		path.Join(labeled, "unlabeled.png"): "",
+		// crashed while writing annotation
+		path.Join(labeled, tempFilePrefix+"ok.xml.123.tmp"): "<?xml",
+		// broken annotation of labeled image
+		path.Join(labeled, "broken.png"): "",
+		path.Join(labeled, "broken.xml"): "<annotation>",
+		// annotation without image
+		path.Join(labeled, "orphan.xml"): annotation("orphan.png"),
+		// replaced image kept for undo
+		path.Join(labeled, backupDir, "123.png"): "",
+		// the same in sub directories
+		path.Join(unlabeled, "cam", "moved.png"):   "",
+		path.Join(labeled, "cam", "moved.xml"):     annotation("moved.png"),
+		path.Join(labeled, "day", "unlabeled.png"): "",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(path.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := &processorImpl{unlabeledPath: unlabeled, labeledPath: labeled}
+	if err := p.recoverPairs(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	exist := []string{
+		path.Join(labeled, "ok.png"),
+		path.Join(labeled, "ok.xml"),
+		path.Join(labeled, "ok.json"),
+		path.Join(labeled, "moved.png"),
+		path.Join(labeled, "moved.xml"),
+		path.Join(unlabeled, "unlabeled.png"),
+		path.Join(unlabeled, "broken.png"),
+		path.Join(labeled, "broken.xml"+brokenAnnotationSuffix),
+		path.Join(labeled, "orphan.xml"),
+		path.Join(labeled, "cam", "moved.png"),
+		path.Join(unlabeled, "day", "unlabeled.png"),
+	}
+	for _, name := range exist {
+		if !fileExists(name) {
+			t.Errorf("%s should exist after recovery", name)
+		}
+	}
+
+	missing := []string{
+		path.Join(unlabeled, "moved.png"),
+		path.Join(labeled, "unlabeled.png"),
+		path.Join(labeled, tempFilePrefix+"ok.xml.123.tmp"),
+		path.Join(labeled, "broken.png"),
+		path.Join(labeled, "broken.xml"),
+		path.Join(unlabeled, "cam", "moved.png"),
+		path.Join(labeled, "day", "unlabeled.png"),
+		path.Join(labeled, backupDir, "123.png"),
+	}
+	for _, name := range missing {
+		if fileExists(name) {
+			t.Errorf("%s shouldn't exist after recovery", name)
+		}
+	}
+}
+
+func Test_processorImpl_labelImage_rollback(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+	p := &processorImpl{unlabeledPath: unlabeled, labeledPath: labeled, options: Options{MinBoxSize: 1}}
+
+	// image can't be moved over non-empty directory
+	blocker := path.Join(labeled, inputFilename)
+	_ = os.MkdirAll(path.Join(blocker, "something"), 0755)
+
+	paths, err := p.resolvePaths(inputFilename)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if _, err := p.labelImage(Command{Filename: inputFilename, Objects: []Object{{"car", 1, 1, 5, 5}}}, paths); err == nil {
+		t.Fatal("labeling should fail when image can't be moved")
+	}
+
+	if fileExists(path.Join(labeled, AnnotationFilename(inputFilename))) {
+		t.Error("annotation should be removed after failed move")
+	}
+	if !fileExists(path.Join(unlabeled, inputFilename)) {
+		t.Error("image should stay unlabeled after failed move")
+	}
+}
@@ -0,0 +1,268 @@
+package processor
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/porfirion/osp/safepath"
+)
+
+// Origins of saved bounding boxes. They are recorded only for images that had suggestions.
+const (
+	// OriginAccepted is suggested box saved as is
+	OriginAccepted = "accepted"
+	// OriginModified is suggested box with changed label or position
+	OriginModified = "modified"
+	// OriginDrawn is box drawn from scratch
+	OriginDrawn = "drawn"
+)
+
+// matchIoU is minimal intersection over union of saved box and suggested one to treat saved box as modified suggestion
+const matchIoU = 0.5
+
+// Suggestion is proposed bounding box, for example found by detector
+type Suggestion struct {
+	Object
+	// Confidence of detector (zero if unknown)
+	Confidence float64 `json:"confidence,omitempty"`
+}
+
+// SuggestionsDocument is content of suggestions file and answer of labeler
+type SuggestionsDocument struct {
+	Objects []Suggestion `json:"objects"`
+}
+
+// SuggestionsFilename returns name of JSON file with suggestions for specified image
+func SuggestionsFilename(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + ".json"
+}
+
+// hasSuggestions reports whether unlabeled image has suggestions file (possibly empty)
+func hasSuggestions(imagePath string) bool {
+	return fileExists(SuggestionsFilename(imagePath)) || fileExists(AnnotationFilename(imagePath))
+}
+
+// readSuggestions reads sidecar file of unlabeled image: JSON document like {"objects": [{"label": "car", "left": 1,
+// "top": 2, "right": 3, "bottom": 4, "confidence": 0.9}]}, LabelMe json or Pascal VOC xml with optional confidence
+// of objects. Boxes are in coordinates of raw image. Returns nil if there is no sidecar file.
+func readSuggestions(imagePath string) ([]Suggestion, error) {
+	data, err := ioutil.ReadFile(SuggestionsFilename(imagePath))
+	if err == nil {
+		return parseSuggestions(data)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading suggestions: %w", err)
+	}
+
+	data, err = ioutil.ReadFile(AnnotationFilename(imagePath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading suggestions: %w", err)
+	}
+
+	doc := &pascalvoc{}
+	if err := xml.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("error parsing suggestions: %w", err)
+	}
+
+	suggestions := make([]Suggestion, 0, len(doc.Objects))
+	for _, o := range doc.Objects {
+		suggestions = append(suggestions, Suggestion{
+			Object:     Object{Label: o.Name, Left: o.Xmin, Top: o.Ymin, Right: o.Xmax, Bottom: o.Ymax},
+			Confidence: o.Confidence,
+		})
+	}
+
+	return suggestions, nil
+}
+
+// parseSuggestions parses JSON suggestions file. It's SuggestionsDocument or LabelMe document, whose rectangles
+// become suggestions.
+func parseSuggestions(data []byte) ([]Suggestion, error) {
+	doc := &struct {
+		SuggestionsDocument
+		Shapes json.RawMessage `json:"shapes"`
+	}{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("error parsing suggestions: %w", err)
+	}
+
+	if doc.Shapes == nil {
+		return doc.Objects, nil
+	}
+
+	a, err := parseLabelMe(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing suggestions: %w", err)
+	}
+
+	suggestions := make([]Suggestion, 0, len(a.Objects))
+	for _, o := range a.Objects {
+		suggestions = append(suggestions, Suggestion{Object: o})
+	}
+
+	return suggestions, nil
+}
+
+// readOrientedSuggestions reads suggestions of image and converts them into coordinates of image as it's shown
+func readOrientedSuggestions(imagePath string) ([]Suggestion, error) {
+	suggestions, err := readSuggestions(imagePath)
+	if err != nil || len(suggestions) == 0 {
+		return nil, err
+	}
+
+	info, err := readImageInfo(imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	orientation, err := ReadOrientation(imagePath)
+	if err != nil {
+		orientation = OrientationNormal
+	}
+
+	for ind := range suggestions {
+		suggestions[ind].Object = OrientObject(suggestions[ind].Object, info.Width, info.Height, orientation)
+	}
+
+	return suggestions, nil
+}
+
+// ReadSuggestions returns suggested boxes for image from dir (nil if there are none).
+// Filename is path relative to dir. Boxes are in coordinates of image rotated according to it's orientation, like
+// browser shows it.
+func ReadSuggestions(dir, filename string) ([]Suggestion, error) {
+	imagePath, err := safepath.Resolve(dir, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return readOrientedSuggestions(imagePath)
+}
+
+// reference is box that saved one could be made from: suggestion or object of previous annotation
+type reference struct {
+	Object
+	// origin of object of previous annotation, empty for suggestions
+	origin     string
+	confidence float64
+}
+
+func suggestionReferences(suggestions []Suggestion) []reference {
+	refs := make([]reference, 0, len(suggestions))
+	for _, s := range suggestions {
+		refs = append(refs, reference{Object: s.Object, confidence: s.Confidence})
+	}
+	return refs
+}
+
+// annotationReferences converts objects of annotation into references in coordinates of shown image.
+// Returns nil if origins weren't recorded in annotation.
+func annotationReferences(doc *pascalvoc) []reference {
+	refs := make([]reference, 0, len(doc.Objects))
+	recorded := false
+
+	for _, o := range doc.Objects {
+		obj := Object{Label: o.Name, Left: o.Xmin, Top: o.Ymin, Right: o.Xmax, Bottom: o.Ymax}
+		refs = append(refs, reference{
+			Object:     OrientObject(obj, doc.Width, doc.Height, doc.Orientation),
+			origin:     o.Origin,
+			confidence: o.Confidence,
+		})
+		recorded = recorded || o.Origin != ""
+	}
+
+	if !recorded {
+		return nil
+	}
+
+	return refs
+}
+
+// objectOrigins finds how each of objects was made by matching them with references. Exact matches are found first,
+// then boxes overlapping by at least matchIoU (box with changed label or redrawn one). Each reference is matched once.
+func objectOrigins(objects []Object, refs []reference) (origins []string, confidences []float64) {
+	matches := make([]int, len(objects))
+	exact := make([]bool, len(objects))
+	used := make([]bool, len(refs))
+
+	for i, o := range objects {
+		matches[i] = -1
+		for j, r := range refs {
+			if !used[j] && strings.EqualFold(o.Label, r.Label) &&
+				o.Left == r.Left && o.Top == r.Top && o.Right == r.Right && o.Bottom == r.Bottom {
+				matches[i], exact[i], used[j] = j, true, true
+				break
+			}
+		}
+	}
+
+	for i, o := range objects {
+		if matches[i] >= 0 {
+			continue
+		}
+
+		best, bestIoU := -1, matchIoU
+		for j, r := range refs {
+			if iou := intersectionOverUnion(o, r.Object); !used[j] && iou >= bestIoU {
+				best, bestIoU = j, iou
+			}
+		}
+		if best >= 0 {
+			matches[i], used[best] = best, true
+		}
+	}
+
+	origins = make([]string, len(objects))
+	confidences = make([]float64, len(objects))
+	for i := range objects {
+		if matches[i] < 0 {
+			origins[i] = OriginDrawn
+			continue
+		}
+
+		r := refs[matches[i]]
+		confidences[i] = r.confidence
+		switch {
+		case exact[i] && r.origin == "":
+			origins[i] = OriginAccepted
+		case !exact[i] && (r.origin == "" || r.origin == OriginAccepted):
+			origins[i] = OriginModified
+		default:
+			origins[i] = r.origin
+		}
+	}
+
+	return origins, confidences
+}
+
+// recordOrigins sets origin and confidence of document objects made from objects of command
+func recordOrigins(doc *pascalvoc, objects []Object, refs []reference) {
+	if len(refs) == 0 || len(objects) != len(doc.Objects) {
+		return
+	}
+
+	origins, confidences := objectOrigins(objects, refs)
+	for ind := range doc.Objects {
+		doc.Objects[ind].Origin = origins[ind]
+		doc.Objects[ind].Confidence = confidences[ind]
+	}
+}
+
+func intersectionOverUnion(a, b Object) float64 {
+	w := minInt(a.Right, b.Right) - maxInt(a.Left, b.Left)
+	h := minInt(a.Bottom, b.Bottom) - maxInt(a.Top, b.Top)
+	if w <= 0 || h <= 0 {
+		return 0
+	}
+
+	intersection := float64(w * h)
+	union := float64((a.Right-a.Left)*(a.Bottom-a.Top)+(b.Right-b.Left)*(b.Bottom-b.Top)) - intersection
+
+	return intersection / union
+}
@@ -0,0 +1,157 @@
+package processor
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func Test_objectOrigins(t *testing.T) {
+	suggestions := []reference{
+		{Object: Object{"car", 0, 0, 10, 10}, confidence: 0.9},
+		{Object: Object{"person", 20, 20, 30, 40}, confidence: 0.5},
+	}
+	previous := []reference{
+		{Object: Object{"car", 0, 0, 10, 10}, origin: OriginAccepted, confidence: 0.9},
+		{Object: Object{"person", 20, 20, 30, 40}, origin: OriginDrawn},
+	}
+
+	tests := []struct {
+		name        string
+		objects     []Object
+		refs        []reference
+		origins     []string
+		confidences []float64
+	}{
+		{"accepted", []Object{{"Car", 0, 0, 10, 10}}, suggestions, []string{OriginAccepted}, []float64{0.9}},
+		{"label changed", []Object{{"bike", 0, 0, 10, 10}}, suggestions, []string{OriginModified}, []float64{0.9}},
+		{"redrawn", []Object{{"person", 21, 21, 30, 40}}, suggestions, []string{OriginModified}, []float64{0.5}},
+		{"drawn", []Object{{"car", 50, 50, 60, 60}, {"car", 5, 5, 15, 15}}, suggestions, []string{OriginDrawn, OriginDrawn}, []float64{0, 0}},
+		{"matched once", []Object{{"bike", 0, 0, 10, 10}, {"car", 0, 0, 10, 10}}, suggestions, []string{OriginDrawn, OriginAccepted}, []float64{0, 0.9}},
+		{"kept on update", []Object{{"car", 0, 0, 10, 10}, {"person", 20, 20, 30, 40}}, previous, []string{OriginAccepted, OriginDrawn}, []float64{0.9, 0}},
+		{"changed on update", []Object{{"bike", 0, 0, 10, 10}, {"bike", 20, 20, 30, 40}}, previous, []string{OriginModified, OriginDrawn}, []float64{0.9, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origins, confidences := objectOrigins(tt.objects, tt.refs)
+			if !reflect.DeepEqual(origins, tt.origins) || !reflect.DeepEqual(confidences, tt.confidences) {
+				t.Errorf("objectOrigins() = %v %v, want %v %v", origins, confidences, tt.origins, tt.confidences)
+			}
+		})
+	}
+}
+
+func Test_readSuggestions(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	files := map[string]string{
+		"json.json": `{"objects": [{"label": "car", "left": 1, "top": 2, "right": 3, "bottom": 4, "confidence": 0.75}]}`,
+		"xml.xml": `<annotation><object><name>car</name><confidence>0.5</confidence>
+			<bndbox><xmin>1</xmin><ymin>2</ymin><xmax>3</xmax><ymax>4</ymax></bndbox></object></annotation>`,
+		"broken.json": `{"objects": `,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(path.Join(tempDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		image   string
+		want    []Suggestion
+		wantErr bool
+	}{
+		{"json", "json.png", []Suggestion{{Object{"car", 1, 2, 3, 4}, 0.75}}, false},
+		{"xml", "xml.jpg", []Suggestion{{Object{"car", 1, 2, 3, 4}, 0.5}}, false},
+		{"none", "none.png", nil, false},
+		{"broken", "broken.png", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readSuggestions(path.Join(tempDir, tt.image))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readSuggestions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readSuggestions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_processorImpl_suggestions(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+	sidecar := `{"objects": [{"label": "car", "left": 0, "top": 0, "right": 5, "bottom": 5, "confidence": 0.9},
+		{"label": "car", "left": 5, "top": 5, "right": 10, "bottom": 10, "confidence": 0.4}]}`
+	if err := ioutil.WriteFile(path.Join(unlabeled, SuggestionsFilename(inputFilename)), []byte(sidecar), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewImageProcessor(unlabeled, labeled, Options{})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+	defer p.Close()
+
+	suggestions, err := ReadSuggestions(unlabeled, inputFilename)
+	if err != nil || len(suggestions) != 2 {
+		t.Fatalf("ReadSuggestions() = %v, %v, want 2 suggestions", suggestions, err)
+	}
+
+	// origins are written into annotation
+	readOrigins := func() (origins []string, confidences []float64) {
+		data, err := ioutil.ReadFile(path.Join(labeled, AnnotationFilename(inputFilename)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc := &pascalvoc{}
+		if err := xml.Unmarshal(data, doc); err != nil {
+			t.Fatal(err)
+		}
+		for _, o := range doc.Objects {
+			origins = append(origins, o.Origin)
+			confidences = append(confidences, o.Confidence)
+		}
+		return origins, confidences
+	}
+
+	objects := []Object{{"car", 0, 0, 5, 5}, {"person", 5, 5, 10, 10}, {"bike", 1, 6, 3, 9}}
+	if _, err := p.ProcessImage(context.Background(), ImageRequest{inputFilename, 10, 10, objects}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	origins, confidences := readOrigins()
+	if want := []string{OriginAccepted, OriginModified, OriginDrawn}; !reflect.DeepEqual(origins, want) {
+		t.Errorf("origins = %v, want %v", origins, want)
+	}
+	if want := []float64{0.9, 0.4, 0}; !reflect.DeepEqual(confidences, want) {
+		t.Errorf("confidences = %v, want %v", confidences, want)
+	}
+
+	objects = []Object{{"person", 0, 0, 5, 5}, {"person", 5, 5, 10, 10}}
+	if _, err := p.UpdateImage(context.Background(), ImageRequest{inputFilename, 10, 10, objects}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	origins, _ = readOrigins()
+	if want := []string{OriginModified, OriginModified}; !reflect.DeepEqual(origins, want) {
+		t.Errorf("origins after update = %v, want %v", origins, want)
+	}
+}
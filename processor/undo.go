@@ -0,0 +1,211 @@
+package processor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/porfirion/osp/safepath"
+)
+
+var NothingToUndoError = errors.New("nothing to undo")
+var ChangedSinceSaveError = errors.New("annotation was changed after save")
+var MissingBackupError = errors.New("replaced labeled image wasn't kept")
+
+// backupDir keeps labeled images replaced by ConflictOverwrite and ConflictMerge, so such saves can be undone.
+// It's hidden, so it isn't listed. History of saves lives in memory only, so backups are removed on startup.
+const backupDir = ".osp-backup"
+
+// Revision describes annotation saved by ProcessImage, MarkNegative or UpdateImage. It contains everything needed
+// to undo the save.
+type Revision struct {
+	// Filename of labeled image
+	Filename string
+	// Unlabeled is name of image in unlabeled path before it was labeled (empty if image was labeled before save)
+	Unlabeled string
+	// Objects are boxes as they were sent by client
+	Objects []Object
+	// Previous is content of replaced annotation (nil if there was none)
+	Previous []byte
+	// Backup is name of replaced labeled image in backup directory (empty if there was none)
+	Backup string
+	// Saved is content of written annotation. Undo is refused if annotation was changed after save
+	Saved []byte
+}
+
+// UndoResult is returned by Undo
+type UndoResult struct {
+	// Filename of image after undo
+	Filename string
+	// Labeled is true if image stayed in labeled path and only previous annotation was restored
+	Labeled bool
+	// Objects of undone save, so they can be corrected and saved again
+	Objects []Object
+}
+
+// undoImage reverts save described by revision.
+// Image that was labeled is moved back to unlabeled path and it's annotation is removed. If it replaced existing
+// labeled image (ConflictOverwrite or ConflictMerge), replaced image is put back from backup together with previous
+// annotation. Undo is refused if there is no backup. Undoing update just restores previous annotation.
+// Images rotated in OrientationRotate mode stay rotated.
+func (p *processorImpl) undoImage(c Command, paths imagePaths) (interface{}, error) {
+	rev := c.Revision
+	if rev == nil {
+		return nil, NothingToUndoError
+	}
+
+	if !fileExists(paths.labeled) {
+		return nil, fmt.Errorf("%w (%s)", MissingInputFileError, paths.labeled)
+	}
+
+	xmlPath := paths.annotation
+	current, err := ioutil.ReadFile(xmlPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading annotation: %w", err)
+	}
+	if !bytes.Equal(current, rev.Saved) {
+		return nil, fmt.Errorf("%w (%s)", ChangedSinceSaveError, c.Filename)
+	}
+
+	result := &UndoResult{Filename: c.Filename, Objects: rev.Objects}
+
+	if rev.Unlabeled == "" {
+		if err := writeFileAtomic(xmlPath, rev.Previous); err != nil {
+			return nil, fmt.Errorf("error restoring annotation: %w", err)
+		}
+		p.restoreExtraAnnotations(c.Filename, rev.Previous)
+		result.Labeled = true
+		return result, nil
+	}
+
+	unlabeledPath, err := safepath.Resolve(p.unlabeledPath, rev.Unlabeled)
+	if err != nil {
+		return nil, err
+	}
+
+	if fileExists(unlabeledPath) {
+		return nil, fmt.Errorf("%w (%s)", UnlabeledExistsError, unlabeledPath)
+	}
+
+	unlabeledDir := filepath.Dir(unlabeledPath)
+	if err := os.MkdirAll(unlabeledDir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating unlabeled directory: %w", err)
+	}
+
+	result.Filename = rev.Unlabeled
+
+	if rev.Previous != nil || rev.Backup != "" {
+		if err := p.undoReplace(c, paths, unlabeledPath); err != nil {
+			return nil, err
+		}
+
+		syncDir(filepath.Dir(paths.labeled))
+		syncDir(unlabeledDir)
+		p.moved(Move{From: c.Filename, To: rev.Unlabeled, Copied: true})
+
+		return result, nil
+	}
+
+	// the same order as in unlabelImage: image without annotation is moved back to unlabeled by recovery
+	if err := os.Remove(xmlPath); err != nil {
+		return nil, fmt.Errorf("error removing annotation: %w", err)
+	}
+
+	if err := os.Rename(paths.labeled, unlabeledPath); err != nil {
+		// rollback, image stays labeled
+		if wErr := writeFileAtomic(xmlPath, rev.Saved); wErr != nil {
+			logger.Printf("error restoring annotation %s after failed move: %v\n", xmlPath, wErr)
+		}
+		return nil, fmt.Errorf("error moving image: %w", err)
+	}
+
+	labeledDir := filepath.Dir(paths.labeled)
+	syncDir(labeledDir)
+	syncDir(unlabeledDir)
+	p.restoreExtraAnnotations(c.Filename, nil)
+	removeEmptyDirs(p.labeledPath, labeledDir)
+	p.moved(Move{From: c.Filename, To: rev.Unlabeled})
+
+	return result, nil
+}
+
+// undoReplace moves labeled image back to unlabeled path and puts image it replaced back with it's annotation.
+// Everything is rolled back on failure.
+func (p *processorImpl) undoReplace(c Command, paths imagePaths, unlabeledPath string) error {
+	rev := c.Revision
+	if rev.Backup == "" {
+		return fmt.Errorf("%w (%s)", MissingBackupError, c.Filename)
+	}
+
+	backupPath, err := safepath.Resolve(filepath.Join(p.labeledPath, backupDir), rev.Backup)
+	if err != nil {
+		return err
+	}
+	if !fileExists(backupPath) {
+		return fmt.Errorf("%w (%s)", MissingBackupError, c.Filename)
+	}
+
+	if err := os.Rename(paths.labeled, unlabeledPath); err != nil {
+		return fmt.Errorf("error moving image: %w", err)
+	}
+
+	if err := os.Rename(backupPath, paths.labeled); err != nil {
+		if mvErr := os.Rename(unlabeledPath, paths.labeled); mvErr != nil {
+			logger.Printf("error moving %s back after failed restore: %v\n", unlabeledPath, mvErr)
+		}
+		return fmt.Errorf("error restoring replaced image: %w", err)
+	}
+
+	if rev.Previous != nil {
+		err = writeFileAtomic(paths.annotation, rev.Previous)
+	} else {
+		err = os.Remove(paths.annotation)
+	}
+	if err != nil {
+		if mvErr := os.Rename(paths.labeled, backupPath); mvErr != nil {
+			logger.Printf("error moving %s back to backup after failed restore: %v\n", paths.labeled, mvErr)
+		} else if mvErr := os.Rename(unlabeledPath, paths.labeled); mvErr != nil {
+			logger.Printf("error moving %s back after failed restore: %v\n", unlabeledPath, mvErr)
+		}
+		return fmt.Errorf("error restoring annotation: %w", err)
+	}
+
+	p.restoreExtraAnnotations(c.Filename, rev.Previous)
+
+	return nil
+}
+
+// backupImage moves labeled image that is going to be replaced into backup directory and returns it's new name
+func (p *processorImpl) backupImage(filePath string) (string, error) {
+	dir := filepath.Join(p.labeledPath, backupDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating backup directory: %w", err)
+	}
+
+	// unique name is reserved by empty file, image replaces it
+	file, err := ioutil.TempFile(dir, "*"+filepath.Ext(filePath))
+	if err != nil {
+		return "", fmt.Errorf("error creating backup: %w", err)
+	}
+	_ = file.Close()
+
+	if err := os.Rename(filePath, file.Name()); err != nil {
+		_ = os.Remove(file.Name())
+		return "", fmt.Errorf("error moving replaced image to backup: %w", err)
+	}
+
+	return filepath.Base(file.Name()), nil
+}
+
+// restoreBackup moves image from backup directory back to filePath
+func (p *processorImpl) restoreBackup(backup, filePath string) error {
+	backupPath, err := safepath.Resolve(filepath.Join(p.labeledPath, backupDir), backup)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(backupPath, filePath)
+}
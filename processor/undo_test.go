@@ -0,0 +1,177 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func Test_processorImpl_Undo(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+	var moves []Move
+	p, err := NewImageProcessor(unlabeled, labeled, Options{OnMove: func(m Move) { moves = append(moves, m) }})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+	defer p.Close()
+
+	if _, err := p.Undo(context.Background(), nil); !errors.Is(err, NothingToUndoError) {
+		t.Fatalf("undo without revision should fail with %v but got %v", NothingToUndoError, err)
+	}
+
+	saved := []Object{{"car", 1, 2, 3, 4}}
+	res, err := p.ProcessImage(context.Background(), ImageRequest{inputFilename, 10, 10, saved})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	labelRev := res.(*LabelResult).Revision
+
+	updated := []Object{{"bike", 2, 3, 4, 5}}
+	res, err = p.UpdateImage(context.Background(), ImageRequest{inputFilename, 10, 10, updated})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	updateRev := res.(*LabelResult).Revision
+
+	// saves are undone in reverse order only
+	if _, err := p.Undo(context.Background(), labelRev); !errors.Is(err, ChangedSinceSaveError) {
+		t.Fatalf("undo of overwritten save should fail with %v but got %v", ChangedSinceSaveError, err)
+	}
+
+	res, err = p.Undo(context.Background(), updateRev)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if want := (&UndoResult{Filename: inputFilename, Labeled: true, Objects: updated}); !reflect.DeepEqual(res, want) {
+		t.Errorf("undo result = %v, want %v", res, want)
+	}
+	annotation, err := ReadAnnotation(labeled, inputFilename)
+	if err != nil {
+		t.Fatalf("error reading annotation: %v", err)
+	}
+	if !reflect.DeepEqual(annotation.Objects, saved) {
+		t.Errorf("restored objects = %v, want %v", annotation.Objects, saved)
+	}
+
+	moves = nil
+	res, err = p.Undo(context.Background(), labelRev)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if want := (&UndoResult{Filename: inputFilename, Objects: saved}); !reflect.DeepEqual(res, want) {
+		t.Errorf("undo result = %v, want %v", res, want)
+	}
+	if _, err := os.Stat(path.Join(unlabeled, inputFilename)); err != nil {
+		t.Errorf("image should be moved back to unlabeled: %v", err)
+	}
+	if _, err := os.Stat(path.Join(labeled, AnnotationFilename(inputFilename))); !os.IsNotExist(err) {
+		t.Errorf("annotation should be removed, got %v", err)
+	}
+	if want := (Move{From: inputFilename, To: inputFilename}); len(moves) != 1 || moves[0] != want {
+		t.Errorf("moves = %v, want [%v]", moves, want)
+	}
+}
+
+func Test_processorImpl_Undo_overwrite(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+	var moves []Move
+	p, err := NewImageProcessor(unlabeled, labeled, Options{
+		ConflictPolicy: ConflictOverwrite,
+		OnMove:         func(m Move) { moves = append(moves, m) },
+	})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+	defer p.Close()
+
+	labeledImage, unlabeledImage := path.Join(labeled, inputFilename), path.Join(unlabeled, inputFilename)
+	xmlPath := path.Join(labeled, AnnotationFilename(inputFilename))
+
+	previous := []Object{{"car", 1, 2, 3, 4}}
+	if _, err := p.ProcessImage(context.Background(), ImageRequest{inputFilename, 10, 10, previous}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	previousImage, previousXML := readTestFile(t, labeledImage), readTestFile(t, xmlPath)
+
+	// another image with the same name replaces labeled one
+	file, err := os.Create(unlabeledImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, testImageSize, testImageSize))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{R: 255, A: 255}), image.Point{}, draw.Src)
+	_ = png.Encode(file, img)
+	_ = file.Close()
+	newImage := readTestFile(t, unlabeledImage)
+
+	res, err := p.ProcessImage(context.Background(), ImageRequest{inputFilename, 10, 10, []Object{{"bike", 2, 3, 4, 5}}})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	rev := res.(*LabelResult).Revision
+	if rev.Backup == "" {
+		t.Fatalf("replaced image should be kept in backup")
+	}
+
+	// undo is refused without backup
+	withoutBackup := *rev
+	withoutBackup.Backup = ""
+	if _, err := p.Undo(context.Background(), &withoutBackup); !errors.Is(err, MissingBackupError) {
+		t.Fatalf("undo without backup should fail with %v but got %v", MissingBackupError, err)
+	}
+	if !bytes.Equal(readTestFile(t, labeledImage), newImage) {
+		t.Errorf("refused undo shouldn't change labeled image")
+	}
+
+	moves = nil
+	if _, err := p.Undo(context.Background(), rev); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if !bytes.Equal(readTestFile(t, unlabeledImage), newImage) {
+		t.Errorf("new image should be moved back to unlabeled")
+	}
+	if !bytes.Equal(readTestFile(t, labeledImage), previousImage) {
+		t.Errorf("replaced image should be restored in labeled")
+	}
+	if !bytes.Equal(readTestFile(t, xmlPath), previousXML) {
+		t.Errorf("replaced annotation should be restored")
+	}
+	if want := (Move{From: inputFilename, To: inputFilename, Copied: true}); len(moves) != 1 || moves[0] != want {
+		t.Errorf("moves = %v, want [%v]", moves, want)
+	}
+	if _, err := os.Stat(path.Join(labeled, backupDir, rev.Backup)); !os.IsNotExist(err) {
+		t.Errorf("backup should be used by undo, got %v", err)
+	}
+}
+
+func readTestFile(t *testing.T, filePath string) []byte {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
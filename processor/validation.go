@@ -0,0 +1,93 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+)
+
+var OutOfBoundsError = errors.New("bounding box is out of image bounds")
+var InvertedBoxError = errors.New("bounding box is inverted")
+var BoxTooSmallError = errors.New("bounding box is too small")
+
+// validateObject checks bounding box against image size.
+// If clamp is set, boxes crossing image border are cut by it instead of being rejected.
+func validateObject(o Object, width, height int, minSize int, clamp bool) (Object, error) {
+	if o.Left > o.Right || o.Top > o.Bottom {
+		return o, fmt.Errorf("%w (%s: %d,%d,%d,%d)", InvertedBoxError, o.Label, o.Left, o.Top, o.Right, o.Bottom)
+	}
+
+	if o.Left < 0 || o.Top < 0 || o.Right > width || o.Bottom > height {
+		if !clamp {
+			return o, fmt.Errorf("%w (%s: %d,%d,%d,%d of %dx%d)", OutOfBoundsError, o.Label, o.Left, o.Top, o.Right, o.Bottom, width, height)
+		}
+
+		o.Left, o.Right = clampInt(o.Left, 0, width), clampInt(o.Right, 0, width)
+		o.Top, o.Bottom = clampInt(o.Top, 0, height), clampInt(o.Bottom, 0, height)
+	}
+
+	if o.Right-o.Left < minSize || o.Bottom-o.Top < minSize {
+		return o, fmt.Errorf("%w (%s: %dx%d, minimum is %d)", BoxTooSmallError, o.Label, o.Right-o.Left, o.Bottom-o.Top, minSize)
+	}
+
+	return o, nil
+}
+
+// CheckAnnotation checks annotation of existing image, which isn't made by processor (like imported one).
+// Objects are expected in coordinates of raw image. Size is checked only if it's set in annotation.
+// Returns annotation with normalized labels, validated objects and size, depth and orientation of image itself.
+// Minimal box size is 1 if it isn't set, like in processor.
+func CheckAnnotation(imagePath string, a *Annotation, options Options) (*Annotation, error) {
+	if options.MinBoxSize < 1 {
+		options.MinBoxSize = 1
+	}
+
+	info, err := readImageInfo(imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Width != 0 || a.Height != 0 {
+		if err := info.checkSize(a.Width, a.Height); err != nil {
+			return nil, err
+		}
+	}
+
+	orientation, err := ReadOrientation(imagePath)
+	if err != nil {
+		// boxes are in raw coordinates anyway
+		orientation = OrientationNormal
+	}
+
+	res := &Annotation{
+		Filename:    a.Filename,
+		Width:       info.Width,
+		Height:      info.Height,
+		Depth:       info.Depth,
+		Orientation: orientation,
+		Objects:     make([]Object, 0, len(a.Objects)),
+	}
+
+	for _, o := range a.Objects {
+		if o.Label, err = normalizeLabel(o.Label, options.Labels); err != nil {
+			return nil, err
+		}
+
+		if o, err = validateObject(o, info.Width, info.Height, options.MinBoxSize, options.ClampBoxes); err != nil {
+			return nil, err
+		}
+
+		res.Objects = append(res.Objects, o)
+	}
+
+	return res, nil
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
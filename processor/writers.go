@@ -0,0 +1,411 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/porfirion/osp/safepath"
+)
+
+var UnknownFormatError = errors.New("unknown annotation format")
+var MissingVOCFormatError = errors.New("pascal voc format is required")
+var MissingImageSizeError = errors.New("image size is unknown")
+var YOLOClassesConflictError = errors.New("yolo annotation of image would replace list of classes")
+
+// Annotation formats that can be written on save
+const (
+	// FormatVOC is Pascal VOC xml. It's always written: it's read back by editor, undo and recovery
+	FormatVOC = "voc"
+	// FormatCOCO is COCO json document describing single image
+	FormatCOCO = "coco"
+	// FormatLabelMe is LabelMe json with rectangle shapes
+	FormatLabelMe = "labelme"
+	// FormatYOLO is YOLO txt with class ids in order of configured labels
+	FormatYOLO = "yolo"
+)
+
+// AnnotationWriter converts annotation of labeled image into file of some format, which is kept next to image.
+// Annotation passed to writer has filename relative to labeled path and objects in coordinates of raw image.
+type AnnotationWriter interface {
+	// Format is name of format used in config
+	Format() string
+	// Filename returns name of annotation file for image, like "cam/1.xml" for "cam/1.jpg"
+	Filename(imageFilename string) string
+	// Marshal returns content of annotation file
+	Marshal(a *Annotation) ([]byte, error)
+}
+
+// AnnotationUpdater is implemented by writers of formats that keep data unknown to osp, like polygons of LabelMe.
+// Existing annotation file is updated instead of being replaced, and it's kept when image is unlabeled.
+type AnnotationUpdater interface {
+	Update(existing []byte, a *Annotation) ([]byte, error)
+	// Strip removes objects of osp from existing annotation. Nil is returned if nothing else is left.
+	Strip(existing []byte) ([]byte, error)
+}
+
+// NewAnnotationWriters creates writers of formats. Pascal VOC is required, labels are required for YOLO.
+// Only Pascal VOC is written if formats are empty.
+func NewAnnotationWriters(formats []string, labels []Label) ([]AnnotationWriter, error) {
+	if len(formats) == 0 {
+		formats = []string{FormatVOC}
+	}
+
+	writers := make([]AnnotationWriter, 0, len(formats))
+	seen := make(map[string]bool, len(formats))
+	for _, format := range formats {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if seen[format] {
+			continue
+		}
+		seen[format] = true
+
+		switch format {
+		case FormatVOC:
+			writers = append(writers, VOCWriter{})
+		case FormatCOCO:
+			writers = append(writers, COCOWriter{Labels: labels})
+		case FormatLabelMe:
+			writers = append(writers, LabelMeWriter{})
+		case FormatYOLO:
+			if len(labels) == 0 {
+				return nil, errors.New("yolo format requires labels: class ids are their indexes")
+			}
+			writers = append(writers, YOLOWriter{Labels: labels})
+		default:
+			return nil, fmt.Errorf("%w %q", UnknownFormatError, format)
+		}
+	}
+
+	if !seen[FormatVOC] {
+		return nil, MissingVOCFormatError
+	}
+
+	return writers, nil
+}
+
+// stem returns filename without extension
+func stem(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename))
+}
+
+// VOCWriter writes Pascal VOC xml
+type VOCWriter struct{}
+
+func (VOCWriter) Format() string {
+	return FormatVOC
+}
+
+func (VOCWriter) Filename(imageFilename string) string {
+	return AnnotationFilename(imageFilename)
+}
+
+func (VOCWriter) Marshal(a *Annotation) ([]byte, error) {
+	return newPascalvoc(a).marshal()
+}
+
+// COCOWriter writes COCO json document with single image. Category ids are indexes of configured labels starting
+// from 1, so documents of different images can be merged. If any label is allowed, categories are labels of image
+// in alphabetical order.
+type COCOWriter struct {
+	Labels []Label
+}
+
+// COCODocument is COCO json. It's written for single image by COCOWriter and for whole dataset by export,
+// datasets of other tools are read by import.
+type COCODocument struct {
+	Images      []COCOImage      `json:"images"`
+	Categories  []COCOCategory   `json:"categories"`
+	Annotations []COCOAnnotation `json:"annotations"`
+}
+
+type COCOImage struct {
+	ID       int    `json:"id"`
+	FileName string `json:"file_name"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+}
+
+type COCOCategory struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type COCOAnnotation struct {
+	ID         int `json:"id"`
+	ImageID    int `json:"image_id"`
+	CategoryID int `json:"category_id"`
+	// x, y, width, height; other tools write fractional values
+	BBox    []float64 `json:"bbox"`
+	Area    float64   `json:"area"`
+	IsCrowd int       `json:"iscrowd"`
+	// always empty when written, some tools fail without it. It's polygons or RLE mask in documents of other tools.
+	Segmentation json.RawMessage `json:"segmentation"`
+}
+
+// COCOCategories returns categories of classes, ids start from 1
+func COCOCategories(classes *ClassMap) []COCOCategory {
+	categories := make([]COCOCategory, 0, len(classes.Names()))
+	for ind, name := range classes.Names() {
+		categories = append(categories, COCOCategory{ID: ind + 1, Name: name})
+	}
+
+	return categories
+}
+
+// NewCOCOAnnotation converts object into annotation of category of it's label
+func NewCOCOAnnotation(id, imageID int, o Object, classes *ClassMap) (COCOAnnotation, error) {
+	class, err := classes.ID(o.Label)
+	if err != nil {
+		return COCOAnnotation{}, err
+	}
+
+	width, height := o.Right-o.Left, o.Bottom-o.Top
+	return COCOAnnotation{
+		ID:           id,
+		ImageID:      imageID,
+		CategoryID:   class + 1,
+		BBox:         []float64{float64(o.Left), float64(o.Top), float64(width), float64(height)},
+		Area:         float64(width * height),
+		Segmentation: json.RawMessage("[]"),
+	}, nil
+}
+
+// Object returns bounding box of annotation rounded to pixels
+func (a COCOAnnotation) Object(label string) Object {
+	x, y, w, h := a.BBox[0], a.BBox[1], a.BBox[2], a.BBox[3]
+	return Object{
+		Label:  label,
+		Left:   int(math.Round(x)),
+		Top:    int(math.Round(y)),
+		Right:  int(math.Round(x + w)),
+		Bottom: int(math.Round(y + h)),
+	}
+}
+
+func (COCOWriter) Format() string {
+	return FormatCOCO
+}
+
+func (COCOWriter) Filename(imageFilename string) string {
+	return stem(imageFilename) + ".coco.json"
+}
+
+func (w COCOWriter) Marshal(a *Annotation) ([]byte, error) {
+	classes := NewClassMap(w.Labels, a)
+
+	doc := &COCODocument{
+		Images:      []COCOImage{{ID: 1, FileName: path.Base(a.Filename), Width: a.Width, Height: a.Height}},
+		Categories:  COCOCategories(classes),
+		Annotations: make([]COCOAnnotation, 0, len(a.Objects)),
+	}
+
+	for ind, o := range a.Objects {
+		ann, err := NewCOCOAnnotation(ind+1, 1, o, classes)
+		if err != nil {
+			return nil, err
+		}
+		doc.Annotations = append(doc.Annotations, ann)
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// YOLOClassesFilename is list of class names written into labeled path, line number is class id
+const YOLOClassesFilename = "classes.txt"
+
+// YOLOClassesConflict reports whether YOLO annotation of image has the same name as list of classes, which is
+// the case for image "classes" in root of dataset. Such image can't be written in YOLO format.
+func YOLOClassesConflict(imageFilename string) bool {
+	return strings.EqualFold(YOLOWriter{}.Filename(imageFilename), YOLOClassesFilename)
+}
+
+// YOLOWriter writes YOLO txt. Class id is index of label in Labels, coordinates are normalized by image size.
+type YOLOWriter struct {
+	Labels []Label
+}
+
+func (YOLOWriter) Format() string {
+	return FormatYOLO
+}
+
+func (YOLOWriter) Filename(imageFilename string) string {
+	return stem(imageFilename) + ".txt"
+}
+
+// Classes returns content of classes.txt
+func (w YOLOWriter) Classes() []byte {
+	return []byte(strings.Join(NewClassMap(w.Labels).Names(), "\n") + "\n")
+}
+
+func (w YOLOWriter) Marshal(a *Annotation) ([]byte, error) {
+	return YOLOLabels(a, NewClassMap(w.Labels))
+}
+
+// YOLOLabels converts objects of annotation into YOLO txt lines "class x_center y_center width height".
+// Coordinates are normalized by image size, so size is required and boxes should be inside of image.
+// Annotation of image that would replace list of classes is refused.
+func YOLOLabels(a *Annotation, classes *ClassMap) ([]byte, error) {
+	if a.Width <= 0 || a.Height <= 0 {
+		return nil, fmt.Errorf("%w (%s)", MissingImageSizeError, a.Filename)
+	}
+	if YOLOClassesConflict(a.Filename) {
+		return nil, fmt.Errorf("%w (%s)", YOLOClassesConflictError, a.Filename)
+	}
+
+	buf := &bytes.Buffer{}
+	for _, o := range a.Objects {
+		if o.Left < 0 || o.Top < 0 || o.Right > a.Width || o.Bottom > a.Height || o.Left >= o.Right || o.Top >= o.Bottom {
+			return nil, fmt.Errorf("%w (%s: %s %d,%d,%d,%d of %dx%d)", OutOfBoundsError,
+				a.Filename, o.Label, o.Left, o.Top, o.Right, o.Bottom, a.Width, a.Height)
+		}
+
+		class, err := classes.ID(o.Label)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", a.Filename, err)
+		}
+
+		width, height := float64(a.Width), float64(a.Height)
+		fmt.Fprintf(buf, "%d %.6f %.6f %.6f %.6f\n",
+			class,
+			float64(o.Left+o.Right)/2/width,
+			float64(o.Top+o.Bottom)/2/height,
+			float64(o.Right-o.Left)/width,
+			float64(o.Bottom-o.Top)/height,
+		)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ExtraWriters returns writers of formats other than Pascal VOC, which is written separately as commit marker
+func ExtraWriters(writers []AnnotationWriter) []AnnotationWriter {
+	extra := make([]AnnotationWriter, 0, len(writers))
+	for _, w := range writers {
+		if w.Format() != FormatVOC {
+			extra = append(extra, w)
+		}
+	}
+	return extra
+}
+
+// ExtraAnnotationPath resolves location of annotation of image filename written by w into dir. YOLO annotation
+// that would replace list of classes is refused, so it is neither written nor removed on rollback.
+func ExtraAnnotationPath(dir string, w AnnotationWriter, filename string) (string, error) {
+	if _, ok := w.(YOLOWriter); ok && YOLOClassesConflict(filename) {
+		return "", fmt.Errorf("%w (%s)", YOLOClassesConflictError, filename)
+	}
+
+	return safepath.Resolve(dir, w.Filename(filename))
+}
+
+// WriteExtraAnnotations writes annotation of image from dir in formats of writers other than Pascal VOC.
+// Filename of annotation is relative to dir. Existing files of formats implementing AnnotationUpdater are updated.
+func WriteExtraAnnotations(dir string, a *Annotation, writers []AnnotationWriter) error {
+	for _, w := range ExtraWriters(writers) {
+		filePath, err := ExtraAnnotationPath(dir, w, a.Filename)
+		if err != nil {
+			return err
+		}
+
+		var data []byte
+		existing, err := ioutil.ReadFile(filePath)
+		if updater, ok := w.(AnnotationUpdater); ok && err == nil {
+			data, err = updater.Update(existing, a)
+		} else if err == nil || os.IsNotExist(err) {
+			data, err = w.Marshal(a)
+		}
+		if err != nil {
+			return fmt.Errorf("error marshalling %s annotation: %w", w.Format(), err)
+		}
+
+		if err := writeFileAtomic(filePath, data); err != nil {
+			return fmt.Errorf("error writing %s annotation: %w", w.Format(), err)
+		}
+	}
+
+	return nil
+}
+
+// WriteYOLOClasses writes class names of YOLO writer into dir. Nothing is written if there is no YOLO writer.
+func WriteYOLOClasses(dir string, writers []AnnotationWriter) error {
+	for _, w := range writers {
+		if yolo, ok := w.(YOLOWriter); ok {
+			if err := writeFileAtomic(filepath.Join(dir, YOLOClassesFilename), yolo.Classes()); err != nil {
+				return fmt.Errorf("error writing yolo classes: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// writeExtraAnnotations writes annotation of labeled image filename in formats other than Pascal VOC.
+// They are written before xml, so failed save is rolled back by restoreExtraAnnotations.
+func (p *processorImpl) writeExtraAnnotations(filename string, doc *pascalvoc) error {
+	a := doc.annotation()
+	a.Filename = filename
+
+	return WriteExtraAnnotations(p.labeledPath, a, p.options.Writers)
+}
+
+// restoreExtraAnnotations makes annotations of other formats match Pascal VOC xml of labeled image.
+// They are removed if xml is nil. Errors are only logged: xml stays the record of annotation.
+func (p *processorImpl) restoreExtraAnnotations(filename string, xmlData []byte) {
+	if xmlData != nil {
+		doc := &pascalvoc{}
+		err := xml.Unmarshal(xmlData, doc)
+		if err == nil {
+			err = p.writeExtraAnnotations(filename, doc)
+		}
+		if err != nil {
+			logger.Printf("error restoring annotations of %s: %v\n", filename, err)
+		}
+		return
+	}
+
+	for _, w := range ExtraWriters(p.options.Writers) {
+		filePath, err := ExtraAnnotationPath(p.labeledPath, w, filename)
+		if err != nil {
+			continue
+		}
+		if err := stripAnnotation(w, filePath); err != nil {
+			logger.Printf("error removing %s annotation of %s: %v\n", w.Format(), filename, err)
+		}
+	}
+}
+
+// stripAnnotation removes annotation file written by w. File of AnnotationUpdater is only stripped of osp objects
+// if there is something else in it, so shapes drawn in other tools aren't lost when image is unlabeled.
+func stripAnnotation(w AnnotationWriter, filePath string) error {
+	if updater, ok := w.(AnnotationUpdater); ok {
+		existing, err := ioutil.ReadFile(filePath)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		data, err := updater.Strip(existing)
+		if err != nil {
+			return err
+		}
+		if data != nil {
+			return writeFileAtomic(filePath, data)
+		}
+	}
+
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
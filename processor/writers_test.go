@@ -0,0 +1,253 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_NewAnnotationWriters(t *testing.T) {
+	labels := []Label{{Name: "car"}}
+
+	tests := []struct {
+		name    string
+		formats []string
+		labels  []Label
+		want    []string
+		wantErr error
+	}{
+		{"default", nil, nil, []string{FormatVOC}, nil},
+		{"all", []string{"voc", "COCO", " labelme", "yolo", "voc"}, labels, []string{FormatVOC, FormatCOCO, FormatLabelMe, FormatYOLO}, nil},
+		{"without voc", []string{"coco"}, labels, nil, MissingVOCFormatError},
+		{"unknown", []string{"voc", "csv"}, labels, nil, UnknownFormatError},
+		{"yolo without labels", []string{"voc", "yolo"}, nil, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writers, err := NewAnnotationWriters(tt.formats, tt.labels)
+			if tt.want == nil {
+				if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+					t.Fatalf("NewAnnotationWriters() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			formats := make([]string, 0, len(writers))
+			for _, w := range writers {
+				formats = append(formats, w.Format())
+			}
+			if !reflect.DeepEqual(formats, tt.want) {
+				t.Errorf("formats = %v, want %v", formats, tt.want)
+			}
+		})
+	}
+}
+
+func Test_writers_Marshal(t *testing.T) {
+	labels := []Label{{Name: "person"}, {Name: "car"}}
+	a := &Annotation{
+		Filename: "cam/1.jpg",
+		Width:    100,
+		Height:   50,
+		Objects:  []Object{{"car", 10, 10, 30, 20}, {"person", 0, 0, 50, 50}},
+	}
+
+	tests := []struct {
+		writer   AnnotationWriter
+		filename string
+		want     string
+	}{
+		{VOCWriter{}, "cam/1.xml", "<xmin>10</xmin>"},
+		{COCOWriter{Labels: labels}, "cam/1.coco.json", `"category_id": 2,
+      "bbox": [
+        10,
+        10,
+        20,
+        10
+      ]`},
+		{COCOWriter{}, "cam/1.coco.json", `"name": "car"`},
+		{LabelMeWriter{}, "cam/1.json", `"imagePath": "1.jpg"`},
+		{LabelMeWriter{}, "cam/1.json", `"shape_type": "rectangle"`},
+		{YOLOWriter{Labels: labels}, "cam/1.txt", "1 0.200000 0.300000 0.200000 0.200000\n0 0.250000 0.500000 0.500000 1.000000\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.writer.Format(), func(t *testing.T) {
+			if got := tt.writer.Filename(a.Filename); got != tt.filename {
+				t.Errorf("Filename() = %v, want %v", got, tt.filename)
+			}
+
+			data, err := tt.writer.Marshal(a)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !strings.Contains(string(data), tt.want) {
+				t.Errorf("Marshal() = %s, want it to contain %s", data, tt.want)
+			}
+		})
+	}
+
+	var unknown *UnknownLabelError
+	if _, err := (YOLOWriter{Labels: labels[:1]}).Marshal(a); !errors.As(err, &unknown) {
+		t.Errorf("yolo of unknown label should fail with UnknownLabelError, got %v", err)
+	}
+}
+
+func Test_processorImpl_writers(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, inputFilename := setupTempDir(tempDir)
+
+	labels := []Label{{Name: "car"}, {Name: "bike"}}
+	writers, err := NewAnnotationWriters([]string{FormatVOC, FormatCOCO, FormatLabelMe, FormatYOLO}, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewImageProcessor(unlabeled, labeled, Options{Labels: labels, Writers: writers})
+	if err != nil {
+		t.Fatal("error creating new image processor")
+	}
+	defer p.Close()
+
+	if data, err := ioutil.ReadFile(path.Join(labeled, YOLOClassesFilename)); err != nil || string(data) != "car\nbike\n" {
+		t.Errorf("classes = %q, %v, want car and bike", data, err)
+	}
+
+	// readYOLO reads yolo file of image
+	readYOLO := func() string {
+		data, _ := ioutil.ReadFile(path.Join(labeled, YOLOWriter{}.Filename(inputFilename)))
+		return string(data)
+	}
+	// exist reports whether annotation files of all formats exist
+	exist := func() (res []bool) {
+		for _, w := range writers {
+			res = append(res, fileExists(path.Join(labeled, w.Filename(inputFilename))))
+		}
+		return res
+	}
+
+	res, err := p.ProcessImage(context.Background(), ImageRequest{inputFilename, 10, 10, []Object{{"car", 0, 0, 5, 5}}})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	labelRev := res.(*LabelResult).Revision
+
+	if got := exist(); !reflect.DeepEqual(got, []bool{true, true, true, true}) {
+		t.Errorf("annotation files exist = %v, want all", got)
+	}
+	if got := readYOLO(); got != "0 0.250000 0.250000 0.500000 0.500000\n" {
+		t.Errorf("yolo = %q", got)
+	}
+
+	res, err = p.UpdateImage(context.Background(), ImageRequest{inputFilename, 10, 10, []Object{{"bike", 0, 0, 10, 10}}})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got := readYOLO(); got != "1 0.500000 0.500000 1.000000 1.000000\n" {
+		t.Errorf("yolo after update = %q", got)
+	}
+
+	// undo of update restores other formats from previous xml
+	if _, err := p.Undo(context.Background(), res.(*LabelResult).Revision); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got := readYOLO(); got != "0 0.250000 0.250000 0.500000 0.500000\n" {
+		t.Errorf("yolo after undo = %q", got)
+	}
+
+	// failed save leaves previous annotations
+	if _, err := p.UpdateImage(context.Background(), ImageRequest{inputFilename, 10, 10, []Object{{"tent", 0, 0, 10, 10}}}); err == nil {
+		t.Fatal("update with unknown label should fail")
+	}
+	if got := readYOLO(); got != "0 0.250000 0.250000 0.500000 0.500000\n" {
+		t.Errorf("yolo after failed update = %q", got)
+	}
+
+	if _, err := p.Undo(context.Background(), labelRev); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got := exist(); !reflect.DeepEqual(got, []bool{false, false, false, false}) {
+		t.Errorf("annotation files exist after undo = %v, want none", got)
+	}
+
+	if _, err := p.ProcessImage(context.Background(), ImageRequest{inputFilename, 10, 10, []Object{{"car", 0, 0, 5, 5}}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := p.UnlabelImage(context.Background(), inputFilename); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got := exist(); !reflect.DeepEqual(got, []bool{false, false, false, false}) {
+		t.Errorf("annotation files exist after unlabel = %v, want none", got)
+	}
+
+	// yolo annotation of image classes would replace list of classes
+	classesImage := "classes" + path.Ext(inputFilename)
+	if err := os.Rename(path.Join(unlabeled, inputFilename), path.Join(unlabeled, classesImage)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.ProcessImage(context.Background(), ImageRequest{classesImage, 10, 10, []Object{{"car", 0, 0, 5, 5}}}); !errors.Is(err, YOLOClassesConflictError) {
+		t.Errorf("ProcessImage() error = %v, want %v", err, YOLOClassesConflictError)
+	}
+	if data, err := ioutil.ReadFile(path.Join(labeled, YOLOClassesFilename)); err != nil || string(data) != "car\nbike\n" {
+		t.Errorf("classes = %q, %v, want car and bike", data, err)
+	}
+}
+
+func Test_NewImageProcessor_withoutVOC(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+	unlabeled, labeled, _ := setupTempDir(tempDir)
+
+	if _, err := NewImageProcessor(unlabeled, labeled, Options{Writers: []AnnotationWriter{LabelMeWriter{}}}); !errors.Is(err, MissingVOCFormatError) {
+		t.Errorf("NewImageProcessor() error = %v, want %v", err, MissingVOCFormatError)
+	}
+}
+
+func TestYOLOLabels(t *testing.T) {
+	classes := NewClassMap([]Label{{Name: "cat"}, {Name: "dog"}})
+
+	tests := []struct {
+		name       string
+		annotation *Annotation
+		want       string
+		err        error
+	}{
+		{"ok", &Annotation{Filename: "a.jpg", Width: 100, Height: 50, Objects: []Object{Object{"dog", 10, 10, 30, 30}, Object{"cat", 0, 0, 100, 50}}},
+			"1 0.200000 0.400000 0.200000 0.400000\n0 0.500000 0.500000 1.000000 1.000000\n", nil},
+		{"no objects", &Annotation{Filename: "a.jpg", Width: 100, Height: 50}, "", nil},
+		{"no size", &Annotation{Filename: "a.jpg", Objects: []Object{Object{"dog", 1, 1, 2, 2}}}, "", MissingImageSizeError},
+		{"negative", &Annotation{Filename: "a.jpg", Width: 100, Height: 50, Objects: []Object{Object{"dog", -1, 1, 2, 2}}}, "", OutOfBoundsError},
+		{"beyond right", &Annotation{Filename: "a.jpg", Width: 100, Height: 50, Objects: []Object{Object{"dog", 1, 1, 101, 2}}}, "", OutOfBoundsError},
+		{"beyond bottom", &Annotation{Filename: "a.jpg", Width: 100, Height: 50, Objects: []Object{Object{"dog", 1, 1, 2, 51}}}, "", OutOfBoundsError},
+		{"classes", &Annotation{Filename: "classes.jpg", Width: 100, Height: 50}, "", YOLOClassesConflictError},
+		{"classes in sub directory", &Annotation{Filename: "cam/classes.jpg", Width: 100, Height: 50}, "", nil},
+		{"inverted", &Annotation{Filename: "a.jpg", Width: 100, Height: 50, Objects: []Object{Object{"dog", 5, 1, 2, 2}}}, "", OutOfBoundsError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := YOLOLabels(tt.annotation, classes)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("that should be error %v but got %v", tt.err, err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("YOLOLabels() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
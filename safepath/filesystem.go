@@ -0,0 +1,42 @@
+package safepath
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// FileSystem is http.FileSystem that opens files only inside of Root using Resolve.
+// Directories and hidden files (any path component starting with ".") aren't served.
+type FileSystem struct {
+	Root string
+}
+
+func (fs FileSystem) Open(name string) (http.File, error) {
+	name = strings.TrimPrefix(name, "/")
+	if name == "" || Hidden(name) {
+		return nil, os.ErrNotExist
+	}
+
+	resolved, err := Resolve(fs.Root, name)
+	if err != nil {
+		// http.FileServer responds with 403 for permission errors
+		return nil, os.ErrPermission
+	}
+
+	file, err := os.Open(resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err == nil && info.IsDir() {
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
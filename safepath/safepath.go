@@ -0,0 +1,112 @@
+// Package safepath resolves user supplied file names inside root directories,
+// so that they can't be used to reach files outside of them.
+package safepath
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UnsafePathError is returned when name can't be safely resolved inside root
+type UnsafePathError struct {
+	Name   string
+	Reason string
+}
+
+func (e *UnsafePathError) Error() string {
+	return fmt.Sprintf("unsafe path %q: %s", e.Name, e.Reason)
+}
+
+// Resolve joins name to root and checks that result stays inside root.
+// Name should be relative and slash separated (like in urls). Absolute names, ".." elements
+// and symlinks pointing outside of root are rejected with UnsafePathError.
+// Resolved file doesn't have to exist, but it's existing parent directories are checked for symlinks too.
+func Resolve(root, name string) (string, error) {
+	if name == "" {
+		return "", &UnsafePathError{Name: name, Reason: "empty name"}
+	}
+
+	if strings.ContainsRune(name, 0) {
+		return "", &UnsafePathError{Name: name, Reason: "null byte"}
+	}
+
+	if strings.HasPrefix(name, "/") || strings.HasPrefix(name, "\\") || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", &UnsafePathError{Name: name, Reason: "absolute path"}
+	}
+
+	for _, part := range strings.FieldsFunc(name, isSeparator) {
+		if part == ".." {
+			return "", &UnsafePathError{Name: name, Reason: "path traversal"}
+		}
+	}
+
+	cleaned := filepath.Clean(filepath.FromSlash(name))
+	if cleaned == "." {
+		return "", &UnsafePathError{Name: name, Reason: "empty name"}
+	}
+
+	resolved := filepath.Join(root, cleaned)
+
+	if err := checkSymlinks(root, resolved); err != nil {
+		if unsafe, ok := err.(*UnsafePathError); ok {
+			unsafe.Name = name
+		}
+		return "", err
+	}
+
+	return resolved, nil
+}
+
+// Hidden reports whether any element of slash separated name starts with ".", like ".skipped" or ".osp-backup/a.jpg"
+func Hidden(name string) bool {
+	for _, part := range strings.FieldsFunc(name, isSeparator) {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkSymlinks verifies that deepest existing part of target resolves inside of root
+func checkSymlinks(root, target string) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return fmt.Errorf("error resolving root: %w", err)
+	}
+
+	existing := target
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error checking path: %w", err)
+		}
+
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return nil
+		}
+		existing = parent
+	}
+
+	realTarget, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		// broken symlink, we can't say where it points
+		return &UnsafePathError{Reason: "unresolvable symlink"}
+	}
+
+	rel, err := filepath.Rel(realRoot, realTarget)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return &UnsafePathError{Reason: "symlink points outside of root"}
+	}
+
+	return nil
+}
+
+func isSeparator(r rune) bool {
+	return r == '/' || r == '\\'
+}
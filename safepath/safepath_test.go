@@ -0,0 +1,110 @@
+package safepath
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setupRoot(t *testing.T) (root, outside string, cleanup func()) {
+	tempDir, err := ioutil.TempDir("", "safepath")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+
+	root, outside = filepath.Join(tempDir, "root"), filepath.Join(tempDir, "outside")
+	_ = os.MkdirAll(filepath.Join(root, "sub"), 0755)
+	_ = os.MkdirAll(outside, 0755)
+	_ = ioutil.WriteFile(filepath.Join(root, "image.png"), []byte("image"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(root, ".skipped"), []byte("image.png\n"), 0644)
+	_ = os.MkdirAll(filepath.Join(root, ".osp-backup"), 0755)
+	_ = ioutil.WriteFile(filepath.Join(root, ".osp-backup", "image.png"), []byte("image"), 0644)
+
+	// symlinks inside of root are fine, pointing outside are not
+	_ = os.Symlink(filepath.Join(root, "image.png"), filepath.Join(root, "inner-link.png"))
+	_ = os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "secret-link.txt"))
+	_ = os.Symlink(outside, filepath.Join(root, "outside-dir"))
+
+	return root, outside, func() { _ = os.RemoveAll(tempDir) }
+}
+
+func TestResolve(t *testing.T) {
+	root, _, cleanup := setupRoot(t)
+	defer cleanup()
+
+	tests := []struct {
+		name   string
+		file   string
+		want   string
+		unsafe bool
+	}{
+		{"plain", "image.png", filepath.Join(root, "image.png"), false},
+		{"not existing", "new.png", filepath.Join(root, "new.png"), false},
+		{"subdirectory", "sub/new.png", filepath.Join(root, "sub", "new.png"), false},
+		{"dot elements", "./sub/./new.png", filepath.Join(root, "sub", "new.png"), false},
+		{"inner symlink", "inner-link.png", filepath.Join(root, "inner-link.png"), false},
+		{"empty", "", "", true},
+		{"dot", ".", "", true},
+		{"parent", "..", "", true},
+		{"traversal", "../../etc/passwd", "", true},
+		{"traversal in the middle", "sub/../../outside/secret.txt", "", true},
+		{"backslash traversal", "..\\secret.txt", "", true},
+		{"absolute", "/etc/passwd", "", true},
+		{"null byte", "image.png\x00.txt", "", true},
+		{"symlink outside", "secret-link.txt", "", true},
+		{"through symlinked dir", "outside-dir/secret.txt", "", true},
+		{"new file in symlinked dir", "outside-dir/new.txt", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Resolve(root, tt.file)
+
+			var unsafe *UnsafePathError
+			if tt.unsafe != errors.As(err, &unsafe) {
+				t.Fatalf("Resolve() error = %v, unsafe expected: %v", err, tt.unsafe)
+			}
+			if !tt.unsafe && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileSystem(t *testing.T) {
+	root, _, cleanup := setupRoot(t)
+	defer cleanup()
+
+	handler := http.FileServer(FileSystem{Root: root})
+
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{"/image.png", http.StatusOK},
+		{"/missing.png", http.StatusNotFound},
+		{"/secret-link.txt", http.StatusForbidden},
+		{"/outside-dir/secret.txt", http.StatusForbidden},
+		{"/", http.StatusNotFound},
+		{"/sub/", http.StatusNotFound},
+		{"/sub", http.StatusNotFound},
+		{"/.skipped", http.StatusNotFound},
+		{"/.osp-backup/image.png", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+// Package thumbnail generates small JPEG copies of images for previews and caches them on disk
+package thumbnail
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	// supported image formats
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/porfirion/osp/processor"
+	"github.com/porfirion/osp/safepath"
+)
+
+// DefaultSize is maximal width and height of thumbnail. Previews are shown as 100px, so it's enough for hidpi screens
+const DefaultSize = 200
+
+// jpegQuality of generated thumbnails
+const jpegQuality = 80
+
+// samples is number of source pixels (in each direction) averaged for single pixel of thumbnail
+const samples = 4
+
+var MissingImageError = errors.New("image not found")
+
+// Cache keeps thumbnails of images from Root in Dir. Thumbnail is placed under the same relative path
+// with ".jpg" appended and gets modification time of it's image, so changed images are noticed and regenerated.
+// Thumbnails of removed images are not cleaned, Dir can be safely removed at any time.
+type Cache struct {
+	dir, root string
+	size      int
+}
+
+// New creates cache of thumbnails of images from root in dir. Thumbnails fit into size x size (DefaultSize if not set)
+func New(dir, root string, size int) *Cache {
+	if size <= 0 {
+		size = DefaultSize
+	}
+
+	return &Cache{dir: dir, root: root, size: size}
+}
+
+// Get returns path of up to date thumbnail of image filename (relative to root), generating it if necessary
+func (c *Cache) Get(filename string) (string, error) {
+	imagePath, err := safepath.Resolve(c.root, filename)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(imagePath)
+	if err != nil || info.IsDir() {
+		return "", fmt.Errorf("%w (%s)", MissingImageError, filename)
+	}
+
+	// filename is already checked by Resolve
+	thumbPath := filepath.Join(c.dir, filepath.FromSlash(filename)+".jpg")
+
+	if thumbInfo, err := os.Stat(thumbPath); err == nil && thumbInfo.ModTime().Equal(info.ModTime()) {
+		return thumbPath, nil
+	}
+
+	if err := c.generate(imagePath, thumbPath); err != nil {
+		return "", err
+	}
+
+	// thumbnail gets time of image to be compared with it later
+	if err := os.Chtimes(thumbPath, info.ModTime(), info.ModTime()); err != nil {
+		return "", fmt.Errorf("error setting thumbnail time: %w", err)
+	}
+
+	return thumbPath, nil
+}
+
+// generate decodes image, scales it down and atomically writes it as jpeg to thumbPath
+func (c *Cache) generate(imagePath, thumbPath string) (err error) {
+	src, err := os.Open(imagePath)
+	if err != nil {
+		return fmt.Errorf("error opening image: %w", err)
+	}
+	defer src.Close()
+
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return fmt.Errorf("%w (%v)", processor.UnsupportedImageError, err)
+	}
+
+	dir := filepath.Dir(thumbPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating thumbnails directory: %w", err)
+	}
+
+	// other requests may generate the same thumbnail simultaneously, so it's written to temp file first
+	file, err := ioutil.TempFile(dir, "."+strings.TrimSuffix(filepath.Base(thumbPath), ".jpg")+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating thumbnail: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}
+	}()
+
+	orientation, err := processor.ReadOrientation(imagePath)
+	if err != nil {
+		// show it as is, like browsers do
+		orientation = processor.OrientationNormal
+	}
+
+	// thumbnail is rotated to look like image shown by browser, jpeg encoder doesn't write exif
+	thumb := processor.Orient(resize(img, c.size), orientation)
+
+	if err = jpeg.Encode(file, thumb, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return fmt.Errorf("error encoding thumbnail: %w", err)
+	}
+
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("error writing thumbnail: %w", err)
+	}
+
+	if err = os.Rename(file.Name(), thumbPath); err != nil {
+		return fmt.Errorf("error renaming thumbnail: %w", err)
+	}
+
+	return nil
+}
+
+// resize scales img down to fit into size x size keeping aspect ratio. Smaller images keep their size.
+// Each pixel is average of samples x samples source pixels, which is good enough for previews and much faster
+// than averaging all source pixels of large photos.
+func resize(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	dstW, dstH := srcW, srcH
+	if srcW > size || srcH > size {
+		if srcW >= srcH {
+			dstW, dstH = size, srcH*size/srcW
+		} else {
+			dstW, dstH = srcW*size/srcH, size
+		}
+	}
+	if dstW < 1 {
+		dstW = 1
+	}
+	if dstH < 1 {
+		dstH = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			var r, g, b, a, n uint32
+
+			for sy := 0; sy < samples; sy++ {
+				// centers of samples inside source area of thumbnail pixel
+				srcY := bounds.Min.Y + ((2*y+1)*samples+2*sy+1-samples)*srcH/(2*samples*dstH)
+				for sx := 0; sx < samples; sx++ {
+					srcX := bounds.Min.X + ((2*x+1)*samples+2*sx+1-samples)*srcW/(2*samples*dstW)
+
+					cr, cg, cb, ca := img.At(srcX, srcY).RGBA()
+					r, g, b, a, n = r+cr, g+cg, b+cb, a+ca, n+1
+				}
+			}
+
+			// jpeg has no transparency, so transparent parts are put on white background
+			// (colors are alpha-premultiplied)
+			background := 0xffff - a/n
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r/n + background) >> 8),
+				G: uint8((g/n + background) >> 8),
+				B: uint8((b/n + background) >> 8),
+				A: 0xff,
+			})
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,142 @@
+package thumbnail
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/porfirion/osp/processor"
+	"github.com/porfirion/osp/safepath"
+)
+
+func Test_resize(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		size          int
+		wantW, wantH  int
+	}{
+		{"landscape", 400, 100, 200, 200, 50},
+		{"portrait", 100, 400, 200, 50, 200},
+		{"small", 30, 20, 200, 30, 20},
+		{"thin", 1000, 1, 200, 200, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resize(image.NewRGBA(image.Rect(0, 0, tt.width, tt.height)), tt.size).Bounds()
+			if got.Dx() != tt.wantW || got.Dy() != tt.wantH {
+				t.Errorf("resize() = %dx%d, want %dx%d", got.Dx(), got.Dy(), tt.wantW, tt.wantH)
+			}
+		})
+	}
+
+	// transparent pixels become white, opaque ones keep color
+	img := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: 0xff, A: 0xff})
+		}
+	}
+	thumb := resize(img, 2)
+	if c := thumb.RGBAAt(0, 0); c != (color.RGBA{R: 0xff, A: 0xff}) {
+		t.Errorf("opaque pixel = %v, want red", c)
+	}
+	if c := thumb.RGBAAt(1, 0); c != (color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}) {
+		t.Errorf("transparent pixel = %v, want white", c)
+	}
+}
+
+func writePNG(t *testing.T, filePath string, width, height int) {
+	file, err := os.Create(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if err := png.Encode(file, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readJPEGSize(t *testing.T, filePath string) (int, int) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	config, err := jpeg.DecodeConfig(file)
+	if err != nil {
+		t.Fatalf("thumbnail isn't jpeg: %v", err)
+	}
+
+	return config.Width, config.Height
+}
+
+func TestCache_Get(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "thumbnails")
+	if err != nil {
+		t.Fatal("error creating temp dir")
+	}
+	defer os.RemoveAll(tempDir)
+
+	root, dir := path.Join(tempDir, "images"), path.Join(tempDir, "thumbs")
+	_ = os.MkdirAll(path.Join(root, "cam"), 0755)
+
+	imagePath := path.Join(root, "cam", "a.png")
+	writePNG(t, imagePath, 400, 200)
+	_ = ioutil.WriteFile(path.Join(root, "broken.png"), []byte("not an image"), 0644)
+
+	cache := New(dir, root, 100)
+
+	thumbPath, err := cache.Get("cam/a.png")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if thumbPath != path.Join(dir, "cam", "a.png.jpg") {
+		t.Errorf("thumbnail path = %s", thumbPath)
+	}
+	if w, h := readJPEGSize(t, thumbPath); w != 100 || h != 50 {
+		t.Errorf("thumbnail size = %dx%d, want 100x50", w, h)
+	}
+
+	// cached thumbnail is reused
+	_ = ioutil.WriteFile(thumbPath, []byte("cached"), 0644)
+	info, _ := os.Stat(imagePath)
+	_ = os.Chtimes(thumbPath, info.ModTime(), info.ModTime())
+	if _, err := cache.Get("cam/a.png"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if data, _ := ioutil.ReadFile(thumbPath); string(data) != "cached" {
+		t.Errorf("thumbnail shouldn't be regenerated")
+	}
+
+	// changed image gets new thumbnail
+	writePNG(t, imagePath, 200, 400)
+	later := info.ModTime().Add(time.Second)
+	_ = os.Chtimes(imagePath, later, later)
+	if _, err := cache.Get("cam/a.png"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if w, h := readJPEGSize(t, thumbPath); w != 50 || h != 100 {
+		t.Errorf("thumbnail size = %dx%d, want 50x100", w, h)
+	}
+
+	if _, err := cache.Get("missing.png"); !errors.Is(err, MissingImageError) {
+		t.Errorf("that should be error %v but got %v", MissingImageError, err)
+	}
+	if _, err := cache.Get("broken.png"); !errors.Is(err, processor.UnsupportedImageError) {
+		t.Errorf("that should be error %v but got %v", processor.UnsupportedImageError, err)
+	}
+	var unsafePath *safepath.UnsafePathError
+	if _, err := cache.Get("../images/cam/a.png"); !errors.As(err, &unsafePath) {
+		t.Errorf("that should be UnsafePathError but got %v", err)
+	}
+}